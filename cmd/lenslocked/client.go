@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+)
+
+const (
+	// perPage is how many items are asked for at once when
+	// listing, the most the API returns.
+	perPage = 100
+
+	// retries is how many times a request that failed for a
+	// reason that may go away is sent again.
+	retries = 3
+)
+
+// Client talks to the API as the owner of Token. Galleries and
+// images are returned as the models types, filled in with what
+// the API sends.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// APIError is an error returned by the API.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Status, e.Message)
+}
+
+// temporary reports whether the request may succeed if sent
+// again.
+func (e *APIError) temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// The payloads below mirror the resources of docs/openapi.yaml.
+type galleryPayload struct {
+	ID                uint      `json:"id"`
+	Title             string    `json:"title"`
+	Description       string    `json:"description"`
+	Slug              string    `json:"slug"`
+	Visibility        string    `json:"visibility"`
+	DownloadsDisabled bool      `json:"downloads_disabled"`
+	ShowMetadata      bool      `json:"show_metadata"`
+	StripMetadata     bool      `json:"strip_metadata"`
+	AllowOriginals    bool      `json:"allow_originals"`
+	CoverImageID      uint      `json:"cover_image_id"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// galleryInput creates a gallery. The API rejects fields it
+// does not expect, so it only has those it accepts.
+type galleryInput struct {
+	Title             string `json:"title"`
+	Description       string `json:"description"`
+	Slug              string `json:"slug,omitempty"`
+	Visibility        string `json:"visibility,omitempty"`
+	DownloadsDisabled bool   `json:"downloads_disabled"`
+	ShowMetadata      bool   `json:"show_metadata"`
+	StripMetadata     bool   `json:"strip_metadata"`
+	AllowOriginals    bool   `json:"allow_originals"`
+}
+
+func newGalleryInput(g *models.Gallery) *galleryInput {
+	return &galleryInput{
+		Title:             g.Title,
+		Description:       g.Description,
+		Slug:              g.Slug,
+		Visibility:        g.Visibility,
+		DownloadsDisabled: g.DownloadsDisabled,
+		ShowMetadata:      g.ShowMetadata,
+		StripMetadata:     g.StripMetadata,
+		AllowOriginals:    g.AllowOriginals,
+	}
+}
+
+func (p *galleryPayload) gallery() *models.Gallery {
+	g := models.Gallery{
+		Title:             p.Title,
+		Description:       p.Description,
+		Slug:              p.Slug,
+		Visibility:        p.Visibility,
+		DownloadsDisabled: p.DownloadsDisabled,
+		ShowMetadata:      p.ShowMetadata,
+		StripMetadata:     p.StripMetadata,
+		AllowOriginals:    p.AllowOriginals,
+		CoverImageID:      p.CoverImageID,
+	}
+	g.ID, g.CreatedAt, g.UpdatedAt = p.ID, p.CreatedAt, p.UpdatedAt
+	return &g
+}
+
+type imagePayload struct {
+	ID        uint       `json:"id"`
+	GalleryID uint       `json:"gallery_id"`
+	Filename  string     `json:"filename"`
+	Position  int        `json:"position"`
+	Caption   string     `json:"caption"`
+	AltText   string     `json:"alt_text"`
+	Size      int64      `json:"size"`
+	Checksum  string     `json:"checksum"`
+	TakenAt   *time.Time `json:"taken_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (p *imagePayload) image() models.Image {
+	i := models.Image{
+		GalleryID: p.GalleryID,
+		Filename:  p.Filename,
+		Position:  p.Position,
+		Caption:   p.Caption,
+		AltText:   p.AltText,
+		Size:      p.Size,
+		Checksum:  p.Checksum,
+		TakenAt:   p.TakenAt,
+	}
+	i.ID, i.CreatedAt, i.UpdatedAt = p.ID, p.CreatedAt, p.UpdatedAt
+	return i
+}
+
+type listPayload struct {
+	Data  json.RawMessage `json:"data"`
+	Total int             `json:"total"`
+}
+
+// Duplicate is a file the API found identical to an image the
+// user already had.
+type Duplicate struct {
+	Filename        string `json:"filename"`
+	ExistingImageID uint   `json:"existing_image_id"`
+	Skipped         bool   `json:"skipped"`
+}
+
+// Me returns the owner of the token.
+func (c *Client) Me() (*models.User, error) {
+	var p struct {
+		ID       uint   `json:"id"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Plan     string `json:"plan"`
+		Used     int64  `json:"storage_used"`
+		Quota    int64  `json:"storage_quota"`
+	}
+	if err := c.getJSON("/me", &p); err != nil {
+		return nil, err
+	}
+	u := models.User{
+		Name:         p.Name,
+		Email:        p.Email,
+		Username:     p.Username,
+		Plan:         p.Plan,
+		StorageUsed:  p.Used,
+		StorageQuota: p.Quota,
+	}
+	u.ID = p.ID
+	return &u, nil
+}
+
+// Galleries returns every gallery of the user.
+func (c *Client) Galleries() ([]models.Gallery, error) {
+	var galleries []models.Gallery
+	err := c.list("/galleries", func(data json.RawMessage) (int, error) {
+		var page []galleryPayload
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, err
+		}
+		for i := range page {
+			galleries = append(galleries, *page[i].gallery())
+		}
+		return len(page), nil
+	})
+	return galleries, err
+}
+
+// CreateGallery creates a gallery with the settings of g, and
+// returns it as it was saved.
+func (c *Client) CreateGallery(g *models.Gallery) (*models.Gallery, error) {
+	body, err := json.Marshal(newGalleryInput(g))
+	if err != nil {
+		return nil, err
+	}
+	var p galleryPayload
+	err = c.doJSON(func() (*http.Request, error) {
+		req, err := c.newRequest("POST", "/galleries", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}, &p)
+	if err != nil {
+		return nil, err
+	}
+	return p.gallery(), nil
+}
+
+// DeleteGallery moves the gallery to the trash.
+func (c *Client) DeleteGallery(id uint) error {
+	return c.delete(fmt.Sprintf("/galleries/%d", id))
+}
+
+// Images returns every image of the gallery, in order.
+func (c *Client) Images(galleryID uint) ([]models.Image, error) {
+	var images []models.Image
+	path := fmt.Sprintf("/galleries/%d/images", galleryID)
+	err := c.list(path, func(data json.RawMessage) (int, error) {
+		var page []imagePayload
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, err
+		}
+		for i := range page {
+			images = append(images, page[i].image())
+		}
+		return len(page), nil
+	})
+	return images, err
+}
+
+// DeleteImage moves the image to the trash.
+func (c *Client) DeleteImage(galleryID, imageID uint) error {
+	return c.delete(fmt.Sprintf("/galleries/%d/images/%d", galleryID, imageID))
+}
+
+// Upload adds the file at path to the gallery. The image is nil
+// when the file was skipped as a duplicate.
+func (c *Client) Upload(galleryID uint, path string) (*models.Image, *Duplicate, error) {
+	var res struct {
+		Data       []imagePayload `json:"data"`
+		Duplicates []Duplicate    `json:"duplicates"`
+	}
+	err := c.doJSON(func() (*http.Request, error) {
+		// The file is streamed, so it is opened again for every
+		// attempt.
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			defer f.Close()
+			part, err := mw.CreateFormFile("images", filepath.Base(path))
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		req, err := c.newRequest("POST", fmt.Sprintf("/galleries/%d/images", galleryID), pr)
+		if err != nil {
+			pr.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	}, &res)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dup *Duplicate
+	if len(res.Duplicates) > 0 {
+		dup = &res.Duplicates[0]
+	}
+	if len(res.Data) == 0 {
+		return nil, dup, nil
+	}
+	image := res.Data[0].image()
+	return &image, dup, nil
+}
+
+// Download writes the original file of the image to w.
+func (c *Client) Download(image *models.Image, w io.Writer) error {
+	path := fmt.Sprintf("/galleries/%d/images/%d/file", image.GalleryID, image.ID)
+	res, err := c.do(func() (*http.Request, error) {
+		return c.newRequest("GET", path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (c *Client) delete(path string) error {
+	res, err := c.do(func() (*http.Request, error) {
+		return c.newRequest("DELETE", path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// list gets every page of the list at path, handing the data
+// of each one to add, which returns how many items it had.
+func (c *Client) list(path string, add func(json.RawMessage) (int, error)) error {
+	seen := 0
+	for page := 1; ; page++ {
+		var p listPayload
+		err := c.getJSON(fmt.Sprintf("%s?page=%d&per_page=%d", path, page, perPage), &p)
+		if err != nil {
+			return err
+		}
+		n, err := add(p.Data)
+		if err != nil {
+			return err
+		}
+		seen += n
+		if n == 0 || seen >= p.Total {
+			return nil
+		}
+	}
+}
+
+func (c *Client) getJSON(path string, v interface{}) error {
+	return c.doJSON(func() (*http.Request, error) {
+		return c.newRequest("GET", path, nil)
+	}, v)
+}
+
+func (c *Client) doJSON(newReq func() (*http.Request, error), v interface{}) error {
+	res, err := c.do(newReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// do sends the request made by newReq, sending a new one when
+// the server could not be reached or failed in a way that may
+// go away. Responses with an error status are returned as an
+// *APIError.
+func (c *Client) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	var err error
+	for attempt := 0; attempt < retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var req *http.Request
+		req, err = newReq()
+		if err != nil {
+			return nil, err
+		}
+		var res *http.Response
+		res, err = c.HTTP.Do(req)
+		if err != nil {
+			continue
+		}
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+		apiErr := readAPIError(res)
+		if !apiErr.temporary() {
+			return nil, apiErr
+		}
+		err = apiErr
+	}
+	return nil, err
+}
+
+func (c *Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.BaseURL, "/") + "/api/v1" + path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func readAPIError(res *http.Response) *APIError {
+	defer res.Body.Close()
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	apiErr := &body.Error
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(res.StatusCode)
+	}
+	apiErr.Status = res.StatusCode
+	return apiErr
+}
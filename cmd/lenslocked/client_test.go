@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"status":401,"message":"nope"}}`)
+			return
+		}
+		if r.URL.Path != "/api/v1/galleries/7/images" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"status":404,"message":"Gallery not found"}}`)
+			return
+		}
+		// Two images a page, three in all.
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"data":[{"id":1,"gallery_id":7,"filename":"a.jpg"},{"id":2,"gallery_id":7,"filename":"b.jpg"}],"total":3}`)
+		case "2":
+			fmt.Fprint(w, `{"data":[{"id":3,"gallery_id":7,"filename":"c.jpg","checksum":"abc"}],"total":3}`)
+		default:
+			fmt.Fprint(w, `{"data":[],"total":3}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, Token: "secret", HTTP: srv.Client()}
+	images, err := c.Images(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 || images[2].ID != 3 || images[2].Checksum != "abc" {
+		t.Errorf("Images() = %+v", images)
+	}
+
+	_, err = c.Images(8)
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.Status != 404 || apiErr.Message != "Gallery not found" {
+		t.Errorf("Images(8) error = %v", err)
+	}
+
+	c.Token = "wrong"
+	if _, err := c.Images(7); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Images with a wrong token error = %v", err)
+	}
+}
+
+func TestImageFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.JPG", "a.png", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.jpg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := imageFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.JPG")}
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Errorf("imageFiles() = %v, want %v", paths, want)
+	}
+}
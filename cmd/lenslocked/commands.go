@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+)
+
+// errUsage is returned by commands given the wrong arguments.
+var errUsage = errors.New("wrong arguments")
+
+// imageExts are the extensions of the files upload picks up
+// from a directory.
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+	".avif": true,
+	".heic": true,
+	".heif": true,
+}
+
+func whoami(c *Client, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	user, err := c.Me()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s <%s>\n", user.Name, user.Email)
+	if user.Username != "" {
+		fmt.Println("Username:", user.Username)
+	}
+	fmt.Println("Plan:", user.Plan)
+	if user.StorageLimited() {
+		fmt.Printf("Storage: %s of %s\n", formatSize(user.StorageUsed), formatSize(user.StorageQuota))
+	} else {
+		fmt.Printf("Storage: %s\n", formatSize(user.StorageUsed))
+	}
+	return nil
+}
+
+func listGalleries(c *Client, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	galleries, err := c.Galleries()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tVISIBILITY\tCREATED")
+	for _, g := range galleries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", g.ID, g.Title, g.Visibility, g.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func createGallery(c *Client, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	visibility := fs.String("visibility", models.VisibilityPrivate, "public, unlisted or private")
+	description := fs.String("description", "", "description, in Markdown")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		return errUsage
+	}
+	gallery, err := c.CreateGallery(&models.Gallery{
+		Title:       strings.Join(fs.Args(), " "),
+		Description: *description,
+		Visibility:  *visibility,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created gallery %d, %s\n", gallery.ID, gallery.Title)
+	return nil
+}
+
+func deleteGallery(c *Client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteGallery(id); err != nil {
+		return err
+	}
+	fmt.Printf("Moved gallery %d to the trash\n", id)
+	return nil
+}
+
+func listImages(c *Client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	galleryID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	images, err := c.Images(galleryID)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFILENAME\tSIZE\tCAPTION")
+	for _, i := range images {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i.ID, i.Filename, formatSize(i.Size), i.Caption)
+	}
+	return tw.Flush()
+}
+
+func removeImages(c *Client, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	galleryID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	for _, arg := range args[1:] {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+		if err := c.DeleteImage(galleryID, id); err != nil {
+			return fmt.Errorf("image %d: %w", id, err)
+		}
+		fmt.Printf("Moved image %d to the trash\n", id)
+	}
+	return nil
+}
+
+// upload uploads the images in a directory to a gallery. Files
+// identical to an image already in the gallery are skipped, so
+// an interrupted upload is resumed by running it again.
+func upload(c *Client, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	parallel := fs.Int("p", 4, "number of files uploaded at once")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 || *parallel < 1 {
+		return errUsage
+	}
+	galleryID, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	paths, err := imageFiles(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	images, err := c.Images(galleryID)
+	if err != nil {
+		return err
+	}
+	uploaded := make(map[string]bool)
+	for _, image := range images {
+		uploaded[image.Checksum] = true
+	}
+
+	var mu sync.Mutex
+	var added, skipped int
+	err = forEach(paths, *parallel, func(path string) error {
+		name := filepath.Base(path)
+		sum, err := checksum(path)
+		if err != nil {
+			return err
+		}
+		if uploaded[sum] {
+			mu.Lock()
+			skipped++
+			mu.Unlock()
+			fmt.Printf("%s: already uploaded\n", name)
+			return nil
+		}
+		image, dup, err := c.Upload(galleryID, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case image == nil:
+			skipped++
+			fmt.Printf("%s: skipped, identical to image %d\n", name, dup.ExistingImageID)
+		case dup != nil:
+			added++
+			fmt.Printf("%s: uploaded, identical to image %d\n", name, dup.ExistingImageID)
+		default:
+			added++
+			fmt.Printf("%s: uploaded as image %d\n", name, image.ID)
+		}
+		return nil
+	})
+	fmt.Printf("%d uploaded, %d skipped\n", added, skipped)
+	return err
+}
+
+// download downloads the original images of a gallery to a
+// directory. Files already there with the checksum of the image
+// are skipped, so an interrupted download is resumed by running
+// it again.
+func download(c *Client, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	parallel := fs.Int("p", 4, "number of files downloaded at once")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 || *parallel < 1 {
+		return errUsage
+	}
+	galleryID, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir := fs.Arg(1)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	images, err := c.Images(galleryID)
+	if err != nil {
+		return err
+	}
+	byFilename := make(map[string]*models.Image, len(images))
+	filenames := make([]string, 0, len(images))
+	for i := range images {
+		byFilename[images[i].Filename] = &images[i]
+		filenames = append(filenames, images[i].Filename)
+	}
+
+	var mu sync.Mutex
+	var fetched, skipped int
+	err = forEach(filenames, *parallel, func(filename string) error {
+		image := byFilename[filename]
+		path := filepath.Join(dir, filepath.Base(filename))
+		if sum, err := checksum(path); err == nil && sum == image.Checksum {
+			mu.Lock()
+			skipped++
+			mu.Unlock()
+			return nil
+		}
+		if err := downloadFile(c, image, path); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+		fmt.Printf("%s: downloaded\n", filename)
+		return nil
+	})
+	fmt.Printf("%d downloaded, %d already there\n", fetched, skipped)
+	return err
+}
+
+// downloadFile writes the image to path, through a temporary
+// file so an interrupted download never leaves half a file at
+// path.
+func downloadFile(c *Client, image *models.Image, path string) error {
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := c.Download(image, f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// forEach runs fn for every item with at most n running at
+// once. It stops starting new ones after the first error, and
+// returns it.
+func forEach(items []string, n int, fn func(string) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	work := make(chan string)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := fn(item); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		mu.Lock()
+		stop := firstErr != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+	var apiErr *APIError
+	if errors.As(firstErr, &apiErr) && apiErr.Status == http.StatusRequestEntityTooLarge {
+		return errors.New("your storage quota is full")
+	}
+	return firstErr
+}
+
+// imageFiles returns the images in dir, sorted by name. Files in
+// subdirectories are left out.
+func imageFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() || !imageExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// checksum returns the SHA-256 of the file in hex, as the API
+// reports it for images.
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,104 @@
+// Command lenslocked manages galleries from the command line
+// through the API. It authenticates with a personal access
+// token, created on the API tokens page of the account.
+//
+// Usage:
+//
+//	lenslocked [-server url] [-token token] <command> [arguments]
+//
+// The server and token default to the LENSLOCKED_URL and
+// LENSLOCKED_TOKEN environment variables.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// command is a subcommand, run with the arguments that follow
+// its name.
+type command struct {
+	usage string
+	short string
+	run   func(c *Client, args []string) error
+}
+
+var commands = map[string]*command{
+	"whoami":    {"whoami", "Show the owner of the token", whoami},
+	"galleries": {"galleries", "List your galleries", listGalleries},
+	"create":    {"create [-visibility v] [-description d] <title>", "Create a gallery", createGallery},
+	"delete":    {"delete <gallery>", "Move a gallery to the trash", deleteGallery},
+	"images":    {"images <gallery>", "List the images of a gallery", listImages},
+	"upload":    {"upload [-p n] <gallery> <dir>", "Upload the images in a directory", upload},
+	"rm":        {"rm <gallery> <image>...", "Move images to the trash", removeImages},
+	"download":  {"download [-p n] <gallery> <dir>", "Download the original images of a gallery", download},
+}
+
+var commandOrder = []string{"whoami", "galleries", "create", "delete", "images", "upload", "rm", "download"}
+
+func main() {
+	server := flag.String("server", envOr("LENSLOCKED_URL", "http://localhost:4000"), "URL of the server")
+	token := flag.String("token", os.Getenv("LENSLOCKED_TOKEN"), "personal access token")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "lenslocked: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "lenslocked: set LENSLOCKED_TOKEN or -token to a personal access token")
+		os.Exit(2)
+	}
+
+	client := &Client{
+		BaseURL: *server,
+		Token:   *token,
+		HTTP:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if err := cmd.run(client, flag.Args()[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: lenslocked %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "lenslocked:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lenslocked [-server url] [-token token] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range commandOrder {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "  %-50s %s\n", cmd.usage, cmd.short)
+	}
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// parseID parses the ID of a gallery or image.
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%q is not a valid ID", s)
+	}
+	return uint(id), nil
+}
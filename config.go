@@ -3,7 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strings"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
 )
 
 type PostgressConfig struct {
@@ -45,6 +49,14 @@ type Config struct {
 	Database PostgressConfig `json:"database"`
 	Mailgun  MailgunConfig   `json:"mailgun"`
 	Dropbox  OAuthConfig     `json:"dropbox"`
+	Plans    PlansConfig     `json:"plans"`
+	GC       GCConfig        `json:"gc"`
+	Metrics  MetricsConfig   `json:"metrics"`
+	// TrustedProxies lists the addresses, or CIDR ranges, of
+	// the proxies whose X-Forwarded-For header is trusted to
+	// hold the address of the client. When it is left out, the
+	// proxy is expected on the same machine.
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
 func DefaultConfig() Config {
@@ -54,9 +66,19 @@ func DefaultConfig() Config {
 		Pepper:   "mUGD8rTdJe",
 		HMACKey:  "the-secret-key",
 		Database: DefaultPostgressConfig(),
+		Plans:    DefaultPlansConfig(),
+		GC:       DefaultGCConfig(),
+		Metrics:  DefaultMetricsConfig(),
+
+		TrustedProxies: []string{"127.0.0.1", "::1"},
 	}
 }
 
+// TrustedProxyNetworks parses TrustedProxies.
+func (c Config) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	return parseNetworks("trusted_proxies", c.TrustedProxies)
+}
+
 func (c Config) IsProd() bool {
 	return c.Env == "prod"
 }
@@ -77,6 +99,9 @@ func LoadConfig(configRequired bool) Config {
 	if err != nil {
 		panic(err)
 	}
+	if c.TrustedProxies == nil {
+		c.TrustedProxies = DefaultConfig().TrustedProxies
+	}
 	fmt.Println("Succesfull Loaded config.json")
 	return c
 }
@@ -93,3 +118,92 @@ type OAuthConfig struct {
 	TokenURL    string `json:"token_url"`
 	RedirectURL string `json:"redirect_url"`
 }
+
+// PlansConfig sets the storage quota of each plan in megabytes.
+// Users who are on no plan are on the Default one, and a quota
+// of 0 means unlimited storage.
+type PlansConfig struct {
+	Default  string           `json:"default"`
+	QuotasMB map[string]int64 `json:"quotas_mb"`
+}
+
+func DefaultPlansConfig() PlansConfig {
+	return PlansConfig{
+		Default: "free",
+		QuotasMB: map[string]int64{
+			"free": 1024,
+			"pro":  51200,
+		},
+	}
+}
+
+func (c PlansConfig) Quotas() models.Quotas {
+	plans := make(map[string]int64, len(c.QuotasMB))
+	for plan, mb := range c.QuotasMB {
+		plans[plan] = mb << 20
+	}
+	return models.Quotas{
+		Plans:       plans,
+		DefaultPlan: c.Default,
+	}
+}
+
+// GCConfig sets how often the server looks for orphaned files
+// and dangling image records, which it only reports unless
+// Remove is true. An interval of 0 turns it off.
+type GCConfig struct {
+	IntervalHours int  `json:"interval_hours"`
+	Remove        bool `json:"remove"`
+}
+
+func DefaultGCConfig() GCConfig {
+	return GCConfig{
+		IntervalHours: 24,
+	}
+}
+
+// MetricsConfig lists the addresses, or CIDR ranges, allowed to
+// scrape /metrics. Behind a trusted proxy they are matched
+// against the address it forwards. Nobody is allowed when the
+// list is empty.
+type MetricsConfig struct {
+	Allow []string `json:"allow"`
+}
+
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Allow: []string{"127.0.0.1/32", "::1/128"},
+	}
+}
+
+// Networks parses the allow list.
+func (c MetricsConfig) Networks() ([]*net.IPNet, error) {
+	return parseNetworks("metrics", c.Allow)
+}
+
+// parseNetworks parses the list of addresses and CIDR ranges of
+// the named setting, turning single addresses into ranges
+// holding only them.
+func parseNetworks(setting string, list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, allow := range list {
+		if !strings.Contains(allow, "/") {
+			ip := net.ParseIP(allow)
+			if ip == nil {
+				return nil, fmt.Errorf("%s: invalid address %q", setting, allow)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(allow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", setting, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
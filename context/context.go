@@ -2,12 +2,17 @@ package context
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/samueldaviddelacruz/lenslocked.com/models"
 )
 
 const (
-	userKey privateKey = "user"
+	userKey         privateKey = "user"
+	apiTokenKey     privateKey = "apiToken"
+	impersonatorKey privateKey = "impersonator"
+	loggerKey       privateKey = "logger"
+	clientIPKey     privateKey = "clientIP"
 )
 
 type privateKey string
@@ -24,3 +29,67 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+// WithAPIToken records the API token the request was
+// authenticated with, if it was not by the session cookie.
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, token)
+}
+
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(apiTokenKey); temp != nil {
+		if token, ok := temp.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// WithImpersonator records the admin impersonating the user of
+// the request.
+func WithImpersonator(ctx context.Context, admin *models.User) context.Context {
+	return context.WithValue(ctx, impersonatorKey, admin)
+}
+
+// Impersonator returns the admin impersonating the user of the
+// request, or nil when nobody is.
+func Impersonator(ctx context.Context) *models.User {
+	if temp := ctx.Value(impersonatorKey); temp != nil {
+		if admin, ok := temp.(*models.User); ok {
+			return admin
+		}
+	}
+	return nil
+}
+
+// WithLogger records the logger of the request, which carries
+// its ID and, once it is known, the ID of its user.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the logger of the request, or the default one
+// outside of requests.
+func Logger(ctx context.Context) *slog.Logger {
+	if temp := ctx.Value(loggerKey); temp != nil {
+		if logger, ok := temp.(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// WithClientIP records the address the request came from, which
+// is only taken from X-Forwarded-For when our proxy sent it.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the address the request came from, or "" when
+// it was not recorded.
+func ClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return ""
+}
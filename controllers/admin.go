@@ -0,0 +1,808 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/email"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+const (
+	// adminSearchLimit is how many users a search lists.
+	adminSearchLimit = 50
+	// adminEventsLimit is how many audit events the page of a
+	// user lists.
+	adminEventsLimit = 50
+	// adminReportsLimit is how many reports the moderation
+	// queue lists.
+	adminReportsLimit = 50
+)
+
+func NewAdmin(us models.UserService, gs models.GalleryService, is models.ImageService, ts models.TrashService, as models.AuditService, rs models.ReportService, emailer *email.Client) *Admin {
+	return &Admin{
+		UsersView:   views.NewView("bootstrap", "admin/users"),
+		UserView:    views.NewView("bootstrap", "admin/user"),
+		GalleryView: views.NewView("bootstrap", "admin/gallery"),
+		AuditView:   views.NewView("bootstrap", "admin/audit"),
+		ReportsView: views.NewView("bootstrap", "admin/reports"),
+		ReportView:  views.NewView("bootstrap", "admin/report"),
+		us:          us,
+		gs:          gs,
+		is:          is,
+		ts:          ts,
+		as:          as,
+		rs:          rs,
+		emailer:     emailer,
+	}
+}
+
+// Admin is the back office, where admins look up users, act as
+// them to see what they see, disable their accounts, review
+// reported galleries and remove abusive content. Every action
+// is recorded in the audit log of the user it concerns.
+type Admin struct {
+	UsersView   *views.View
+	UserView    *views.View
+	GalleryView *views.View
+	AuditView   *views.View
+	ReportsView *views.View
+	ReportView  *views.View
+	us          models.UserService
+	gs          models.GalleryService
+	is          models.ImageService
+	ts          models.TrashService
+	as          models.AuditService
+	rs          models.ReportService
+	emailer     *email.Client
+}
+
+// RemoveForm is used to remove a gallery or an image, or to hide
+// a gallery, with the reason recorded in the audit log.
+type RemoveForm struct {
+	Reason string `schema:"reason"`
+}
+
+// ResolveForm is used to close the reports about a gallery as
+// models.ReportActioned or models.ReportDismissed.
+type ResolveForm struct {
+	State string `schema:"state"`
+}
+
+// AuditForm filters the audit log. Page starts at 1.
+type AuditForm struct {
+	Action string `schema:"action"`
+	UserID uint   `schema:"user"`
+	Page   int    `schema:"page"`
+}
+
+// adminUsersData is what the users template expects as its
+// Yield.
+type adminUsersData struct {
+	Query string
+	Users []models.User
+}
+
+// adminUserData is what the user template expects as its Yield.
+// Actors holds the users who acted in Events.
+type adminUserData struct {
+	User        *models.User
+	Galleries   []models.Gallery
+	ImageCounts map[uint]int
+	Events      []models.AuditEvent
+	Actors      map[uint]*models.User
+}
+
+// adminAuditData is what the audit template expects as its
+// Yield. Users holds the users who acted in Events or whose
+// accounts they concern. PrevPage and NextPage are 0 on the
+// first and last pages.
+type adminAuditData struct {
+	Form     AuditForm
+	Actions  []string
+	Events   []models.AuditEvent
+	Users    map[uint]*models.User
+	PrevPage int
+	NextPage int
+}
+
+// adminGalleryData is what the gallery template expects as its
+// Yield.
+type adminGalleryData struct {
+	Gallery *models.Gallery
+	Owner   *models.User
+	Reports []models.Report
+}
+
+// adminReportsData is what the reports template expects as its
+// Yield. Galleries holds the reported galleries, leaving out
+// those deleted since.
+type adminReportsData struct {
+	State     string
+	States    []string
+	Reports   []models.Report
+	Galleries map[uint]*models.Gallery
+}
+
+// adminReportData is what the report template expects as its
+// Yield. Gallery and Owner are nil when the gallery was deleted
+// since it was reported. Reports holds every report about the
+// gallery, and Users the users who filed or resolved them.
+type adminReportData struct {
+	Report  *models.Report
+	Gallery *models.Gallery
+	Owner   *models.User
+	Reports []models.Report
+	Users   map[uint]*models.User
+}
+
+// Users searches users by email, name or username, listing the
+// newest ones when no query is provided.
+//
+// GET /admin/users
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	data := adminUsersData{Query: r.URL.Query().Get("q")}
+	users, err := a.us.Search(data.Query, adminSearchLimit)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data.Users = users
+	var vd views.Data
+	vd.Yield = &data
+	a.UsersView.Render(w, r, vd)
+}
+
+// GET /admin/users/:id
+func (a *Admin) User(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	data, err := a.userData(user)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = data
+	a.UserView.Render(w, r, vd)
+}
+
+// Disable keeps the user from logging in, and signs them out
+// of the sessions and tokens they have. Their profile,
+// galleries and collections are then not found by anyone but
+// admins, share links included, until they are enabled again.
+//
+// POST /admin/users/:id/disable
+func (a *Admin) Disable(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, true)
+}
+
+// POST /admin/users/:id/enable
+func (a *Admin) Enable(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, false)
+}
+
+func (a *Admin) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/admin/users/%v", user.ID)
+	if user.Admin {
+		views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "Admins cannot be disabled.",
+		})
+		return
+	}
+	action, message := models.AuditUserEnable, user.Email+" can log in again."
+	user.DisabledAt = nil
+	if disabled {
+		now := time.Now()
+		action, message = models.AuditUserDisable, user.Email+" was disabled."
+		user.DisabledAt = &now
+	}
+	if err := a.us.Update(user); err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	a.record(r, action, user.ID, "", "")
+	views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+// Impersonate makes the admin act as the user until they stop,
+// to see the site as the user does.
+//
+// POST /admin/users/:id/impersonate
+func (a *Admin) Impersonate(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	if user.Admin {
+		views.RedirectAlert(w, r, fmt.Sprintf("/admin/users/%v", user.ID), http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "Admins cannot be impersonated.",
+		})
+		return
+	}
+	a.record(r, models.AuditImpersonateStart, user.ID, "", "")
+	http.SetCookie(w, &http.Cookie{
+		Name:     "impersonate",
+		Value:    strconv.FormatUint(uint64(user.ID), 10),
+		Path:     "/",
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+// StopImpersonating returns the admin to their own account. It
+// is not behind RequireAdmin, since the current user is the
+// one impersonated until it is done.
+//
+// POST /admin/impersonate/stop
+func (a *Admin) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if context.Impersonator(r.Context()) == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	a.record(r, models.AuditImpersonateStop, user.ID, "", "")
+	clearImpersonation(w)
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%v", user.ID), http.StatusFound)
+}
+
+// Audit lists the events of every account, newest first,
+// optionally only those of one action or one account.
+//
+// GET /admin/audit
+func (a *Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	var form AuditForm
+	parseURLParams(r, &form)
+	if form.Page < 1 {
+		form.Page = 1
+	}
+	// One more event than is listed tells whether there is a
+	// next page.
+	events, err := a.as.Search(models.AuditFilter{
+		Action: form.Action,
+		UserID: form.UserID,
+		Offset: (form.Page - 1) * adminEventsLimit,
+		Limit:  adminEventsLimit + 1,
+	})
+	more := len(events) > adminEventsLimit
+	if more {
+		events = events[:adminEventsLimit]
+	}
+	var users map[uint]*models.User
+	if err == nil {
+		users, err = a.eventUsers(events)
+	}
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data := adminAuditData{
+		Form:     form,
+		Actions:  models.AuditActions,
+		Events:   events,
+		Users:    users,
+		PrevPage: form.Page - 1,
+	}
+	if more {
+		data.NextPage = form.Page + 1
+	}
+	var vd views.Data
+	vd.Yield = &data
+	a.AuditView.Render(w, r, vd)
+}
+
+// GET /admin/galleries/:id
+func (a *Admin) Gallery(w http.ResponseWriter, r *http.Request) {
+	gallery, owner, err := a.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	reports, err := a.rs.ByGalleryID(gallery.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = &adminGalleryData{Gallery: gallery, Owner: owner, Reports: reports}
+	a.GalleryView.Render(w, r, vd)
+}
+
+// RemoveGallery deletes a gallery for good, skipping the trash
+// of its owner so it cannot be restored.
+//
+// POST /admin/galleries/:id/remove
+func (a *Admin) RemoveGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, owner, err := a.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	var form RemoveForm
+	parseForm(r, &form)
+	path := fmt.Sprintf("/admin/galleries/%v", gallery.ID)
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	deleted, err := a.ts.GalleryByID(gallery.ID)
+	if err == nil {
+		err = a.ts.PurgeGallery(deleted)
+	}
+	if err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	a.record(r, models.AuditGalleryRemove, owner.ID,
+		fmt.Sprintf("gallery %v", gallery.ID), gallery.Title+": "+form.Reason)
+	// Removing the gallery is the action the reports about it
+	// asked for.
+	if err := a.rs.Resolve(gallery.ID, models.ReportActioned, context.User(r.Context()).ID); err != nil {
+		logError(r, err)
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/admin/users/%v", owner.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " was removed.",
+	})
+}
+
+// RemoveImage deletes an image for good, skipping the trash of
+// its owner so it cannot be restored.
+//
+// POST /admin/galleries/:id/images/:imageID/remove
+func (a *Admin) RemoveImage(w http.ResponseWriter, r *http.Request) {
+	gallery, owner, err := a.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/admin/galleries/%v", gallery.ID)
+	id, err := strconv.ParseUint(mux.Vars(r)["imageID"], 10, 64)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	image, ok := imageByID(gallery.Images, uint(id))
+	if !ok {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	var form RemoveForm
+	parseForm(r, &form)
+	if err := a.is.Delete(image); err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	deleted, err := a.ts.ImageByID(image.ID)
+	if err == nil {
+		err = a.ts.PurgeImage(deleted)
+	}
+	if err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	a.record(r, models.AuditImageRemove, owner.ID,
+		fmt.Sprintf("image %v of gallery %v", image.ID, gallery.ID), image.Filename+": "+form.Reason)
+	views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: image.Filename + " was removed.",
+	})
+}
+
+// Reports is the moderation queue, listing the reports in the
+// state asked for, the open ones by default.
+//
+// GET /admin/reports
+func (a *Admin) Reports(w http.ResponseWriter, r *http.Request) {
+	data := adminReportsData{
+		State:     r.URL.Query().Get("state"),
+		States:    models.ReportStates,
+		Galleries: make(map[uint]*models.Gallery),
+	}
+	if data.State == "" {
+		data.State = models.ReportOpen
+	}
+	reports, err := a.rs.ByState(data.State, adminReportsLimit)
+	if err == nil {
+		data.Reports = reports
+		for _, report := range reports {
+			if _, ok := data.Galleries[report.GalleryID]; ok {
+				continue
+			}
+			var gallery *models.Gallery
+			gallery, err = a.gs.ByID(report.GalleryID)
+			if err == models.ErrNotFound {
+				err = nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			data.Galleries[gallery.ID] = gallery
+		}
+	}
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = &data
+	a.ReportsView.Render(w, r, vd)
+}
+
+// Report shows a report along with the other reports about the
+// same gallery, and what can be done about them.
+//
+// GET /admin/reports/:id
+func (a *Admin) Report(w http.ResponseWriter, r *http.Request) {
+	report, err := a.reportByID(w, r)
+	if err != nil {
+		return
+	}
+	data, err := a.reportData(report)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = data
+	a.ReportView.Render(w, r, vd)
+}
+
+// HideGallery hides the reported gallery from everyone but its
+// owner while the reports about it are reviewed, and emails the
+// owner why.
+//
+// POST /admin/reports/:id/hide
+func (a *Admin) HideGallery(w http.ResponseWriter, r *http.Request) {
+	report, gallery, owner, err := a.reportedGallery(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/admin/reports/%v", report.ID)
+	var form RemoveForm
+	parseForm(r, &form)
+	form.Reason = strings.TrimSpace(form.Reason)
+	if form.Reason == "" {
+		views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "Please give a reason, which is sent to the owner of the gallery.",
+		})
+		return
+	}
+	now := time.Now()
+	gallery.HiddenAt = &now
+	if err := a.gs.Update(gallery); err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	a.record(r, models.AuditGalleryHide, owner.ID,
+		fmt.Sprintf("gallery %v", gallery.ID), gallery.Title+": "+form.Reason)
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " is hidden, and " + owner.Email + " was told why.",
+	}
+	if err := a.emailer.GalleryHidden(owner.Name, owner.Email, gallery.ID, gallery.Title, form.Reason); err != nil {
+		logError(r, err)
+		alert.Level = views.AlertLvlWarning
+		alert.Message = gallery.Title + " is hidden, but the email to " + owner.Email + " could not be sent."
+	}
+	views.RedirectAlert(w, r, path, http.StatusFound, alert)
+}
+
+// UnhideGallery shows the reported gallery to visitors again.
+//
+// POST /admin/reports/:id/unhide
+func (a *Admin) UnhideGallery(w http.ResponseWriter, r *http.Request) {
+	report, gallery, owner, err := a.reportedGallery(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/admin/reports/%v", report.ID)
+	if err := a.unhide(r, gallery, owner); err != nil {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " is visible again.",
+	})
+}
+
+// Resolve closes every open report about the gallery of the
+// report. Dismissing them shows the gallery again if it was
+// hidden, as nothing was wrong with it.
+//
+// POST /admin/reports/:id/resolve
+func (a *Admin) Resolve(w http.ResponseWriter, r *http.Request) {
+	report, err := a.reportByID(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/admin/reports/%v", report.ID)
+	var form ResolveForm
+	parseForm(r, &form)
+	if err := a.rs.Resolve(report.GalleryID, form.State, context.User(r.Context()).ID); err != nil {
+		if err == models.ErrReportStateInvalid {
+			views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+				Level:   views.AlertLvlError,
+				Message: "Please choose whether the reports were actioned or dismissed.",
+			})
+			return
+		}
+		a.redirectError(w, r, path, err)
+		return
+	}
+	gallery, err := a.gs.ByID(report.GalleryID)
+	if err == nil {
+		var owner *models.User
+		owner, err = a.us.ByID(gallery.UserID)
+		if err == nil {
+			a.record(r, models.AuditReportResolve, owner.ID,
+				fmt.Sprintf("gallery %v", gallery.ID), gallery.Title+": "+form.State)
+			if form.State == models.ReportDismissed && gallery.Hidden() {
+				err = a.unhide(r, gallery, owner)
+			}
+		}
+	}
+	if err != nil && err != models.ErrNotFound {
+		a.redirectError(w, r, path, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/admin/reports", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The reports were " + form.State + ".",
+	})
+}
+
+func (a *Admin) unhide(r *http.Request, gallery *models.Gallery, owner *models.User) error {
+	gallery.HiddenAt = nil
+	if err := a.gs.Update(gallery); err != nil {
+		return err
+	}
+	a.record(r, models.AuditGalleryUnhide, owner.ID,
+		fmt.Sprintf("gallery %v", gallery.ID), gallery.Title)
+	return nil
+}
+
+func (a *Admin) reportData(report *models.Report) (*adminReportData, error) {
+	data := adminReportData{Report: report, Users: make(map[uint]*models.User)}
+	gallery, err := a.gs.ByID(report.GalleryID)
+	switch err {
+	case nil:
+		data.Gallery = gallery
+		data.Owner, err = a.us.ByID(gallery.UserID)
+		if err != nil {
+			return nil, err
+		}
+	case models.ErrNotFound:
+	default:
+		return nil, err
+	}
+	data.Reports, err = a.rs.ByGalleryID(report.GalleryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range data.Reports {
+		for _, id := range []uint{report.ReporterID, report.ResolvedByID} {
+			if _, ok := data.Users[id]; ok || id == 0 {
+				continue
+			}
+			user, err := a.us.ByID(id)
+			if err == models.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			data.Users[id] = user
+		}
+	}
+	return &data, nil
+}
+
+func (a *Admin) userData(user *models.User) (*adminUserData, error) {
+	galleries, err := a.gs.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(galleries))
+	for i := range galleries {
+		ids[i] = galleries[i].ID
+	}
+	images, err := a.is.ByGalleryIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	events, err := a.as.ByUserID(user.ID, adminEventsLimit)
+	if err != nil {
+		return nil, err
+	}
+	actors, err := a.eventUsers(events)
+	if err != nil {
+		return nil, err
+	}
+	data := adminUserData{
+		User:        user,
+		Galleries:   galleries,
+		ImageCounts: make(map[uint]int, len(galleries)),
+		Events:      events,
+		Actors:      actors,
+	}
+	for id, images := range images {
+		data.ImageCounts[id] = len(images)
+	}
+	return &data, nil
+}
+
+// eventUsers returns the users who acted in the events or whose
+// accounts they concern, by ID. Users deleted since are left
+// out.
+func (a *Admin) eventUsers(events []models.AuditEvent) (map[uint]*models.User, error) {
+	users := make(map[uint]*models.User)
+	for _, event := range events {
+		for _, id := range []uint{event.ActorID, event.UserID} {
+			if _, ok := users[id]; ok || id == 0 {
+				continue
+			}
+			user, err := a.us.ByID(id)
+			if err == models.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			users[id] = user
+		}
+	}
+	return users, nil
+}
+
+// record adds an event about the account of the user to the
+// audit log.
+func (a *Admin) record(r *http.Request, action string, userID uint, target, detail string) {
+	event := newAuditEvent(r, action, userID)
+	event.Target = target
+	event.Detail = detail
+	recordAudit(r, a.as, event)
+}
+
+func (a *Admin) redirectError(w http.ResponseWriter, r *http.Request, path string, err error) {
+	logError(r, err)
+	views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlError,
+		Message: views.AlertMsgGeneric,
+	})
+}
+
+// userByID returns the user with the ID in the URL.
+func (a *Admin) userByID(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := a.us.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// reportByID returns the report with the ID in the URL.
+func (a *Admin) reportByID(w http.ResponseWriter, r *http.Request) (*models.Report, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return nil, err
+	}
+	report, err := a.rs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Report not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return report, nil
+}
+
+// reportedGallery returns the report with the ID in the URL,
+// along with the gallery it is about and its owner. It
+// redirects back to the report when the gallery was deleted.
+func (a *Admin) reportedGallery(w http.ResponseWriter, r *http.Request) (*models.Report, *models.Gallery, *models.User, error) {
+	report, err := a.reportByID(w, r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	path := fmt.Sprintf("/admin/reports/%v", report.ID)
+	gallery, err := a.gs.ByID(report.GalleryID)
+	var owner *models.User
+	if err == nil {
+		owner, err = a.us.ByID(gallery.UserID)
+	}
+	switch err {
+	case nil:
+		return report, gallery, owner, nil
+	case models.ErrNotFound:
+		views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "The gallery was deleted since it was reported.",
+		})
+	default:
+		a.redirectError(w, r, path, err)
+	}
+	return nil, nil, nil, err
+}
+
+// galleryByID returns the gallery with the ID in the URL, along
+// with its images, and its owner.
+func (a *Admin) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, *models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, nil, err
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	var owner *models.User
+	if err == nil {
+		gallery.Images, err = a.is.ByGalleryID(gallery.ID)
+	}
+	if err == nil {
+		owner, err = a.us.ByID(gallery.UserID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, nil, err
+	}
+	return gallery, owner, nil
+}
+
+// clearImpersonation removes the impersonate cookie, returning
+// the admin to their own account.
+func clearImpersonation(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "impersonate",
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,673 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+const (
+	// apiPerPage is how many items a page of a list has unless
+	// the client asks for another number, up to apiMaxPerPage.
+	apiPerPage    = 20
+	apiMaxPerPage = 100
+
+	// maxAPIBodyBytes is the largest JSON body accepted.
+	maxAPIBodyBytes = 1 << 20 // 1 megabyte
+)
+
+func NewAPI(gs models.GalleryService, is models.ImageService, as models.AuditService) *API {
+	return &API{
+		gs: gs,
+		is: is,
+		as: as,
+	}
+}
+
+// API serves the JSON API under /api/v1, which acts on the
+// galleries and images of the current user. Resources are
+// written with the api* types below, so the models can change
+// without changing what clients get.
+type API struct {
+	gs models.GalleryService
+	is models.ImageService
+	as models.AuditService
+}
+
+type apiUser struct {
+	ID              uint   `json:"id"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	Username        string `json:"username"`
+	Bio             string `json:"bio"`
+	Theme           string `json:"theme"`
+	DuplicatePolicy string `json:"duplicate_policy"`
+	Plan            string `json:"plan"`
+	StorageUsed     int64  `json:"storage_used"`
+	// StorageQuota is 0 when the storage of the user is not
+	// limited.
+	StorageQuota int64 `json:"storage_quota"`
+}
+
+func newAPIUser(u *models.User) *apiUser {
+	return &apiUser{
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Username:        u.Username,
+		Bio:             u.Bio,
+		Theme:           u.Theme,
+		DuplicatePolicy: u.DuplicatePolicy,
+		Plan:            u.Plan,
+		StorageUsed:     u.StorageUsed,
+		StorageQuota:    u.StorageQuota,
+	}
+}
+
+type apiGallery struct {
+	ID                uint      `json:"id"`
+	Title             string    `json:"title"`
+	Description       string    `json:"description"`
+	Slug              string    `json:"slug"`
+	Visibility        string    `json:"visibility"`
+	DownloadsDisabled bool      `json:"downloads_disabled"`
+	ShowMetadata      bool      `json:"show_metadata"`
+	StripMetadata     bool      `json:"strip_metadata"`
+	AllowOriginals    bool      `json:"allow_originals"`
+	CoverImageID      uint      `json:"cover_image_id"`
+	ImageCount        int       `json:"image_count"`
+	URL               string    `json:"url"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func newAPIGallery(g *models.Gallery) *apiGallery {
+	ag := apiGallery{
+		ID:                g.ID,
+		Title:             g.Title,
+		Description:       g.Description,
+		Slug:              g.Slug,
+		Visibility:        g.Visibility,
+		DownloadsDisabled: g.DownloadsDisabled,
+		ShowMetadata:      g.ShowMetadata,
+		StripMetadata:     g.StripMetadata,
+		AllowOriginals:    g.AllowOriginals,
+		ImageCount:        len(g.Images),
+		URL:               fmt.Sprintf("/galleries/%v", g.ID),
+		CreatedAt:         g.CreatedAt,
+		UpdatedAt:         g.UpdatedAt,
+	}
+	if cover := g.Cover(); cover != nil {
+		ag.CoverImageID = cover.ID
+	}
+	return &ag
+}
+
+// apiGalleryInput creates or updates a gallery. Fields left out
+// of an update keep their value.
+type apiGalleryInput struct {
+	Title             *string `json:"title"`
+	Description       *string `json:"description"`
+	Slug              *string `json:"slug"`
+	Visibility        *string `json:"visibility"`
+	DownloadsDisabled *bool   `json:"downloads_disabled"`
+	ShowMetadata      *bool   `json:"show_metadata"`
+	StripMetadata     *bool   `json:"strip_metadata"`
+	AllowOriginals    *bool   `json:"allow_originals"`
+	CoverImageID      *uint   `json:"cover_image_id"`
+}
+
+func (in *apiGalleryInput) apply(g *models.Gallery) {
+	if in.Title != nil {
+		g.Title = *in.Title
+	}
+	if in.Description != nil {
+		g.Description = *in.Description
+	}
+	if in.Slug != nil {
+		g.Slug = *in.Slug
+	}
+	if in.Visibility != nil {
+		g.Visibility = *in.Visibility
+	}
+	if in.DownloadsDisabled != nil {
+		g.DownloadsDisabled = *in.DownloadsDisabled
+	}
+	if in.ShowMetadata != nil {
+		g.ShowMetadata = *in.ShowMetadata
+	}
+	if in.StripMetadata != nil {
+		g.StripMetadata = *in.StripMetadata
+	}
+	if in.AllowOriginals != nil {
+		g.AllowOriginals = *in.AllowOriginals
+	}
+}
+
+type apiImage struct {
+	ID          uint       `json:"id"`
+	GalleryID   uint       `json:"gallery_id"`
+	Filename    string     `json:"filename"`
+	Position    int        `json:"position"`
+	Caption     string     `json:"caption"`
+	AltText     string     `json:"alt_text"`
+	Size        int64      `json:"size"`
+	Checksum    string     `json:"checksum"`
+	Camera      string     `json:"camera,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	URL         string     `json:"url"`
+	OriginalURL string     `json:"original_url"`
+	// FileURL serves the original file through the API, so it
+	// can be downloaded with a token.
+	FileURL   string    `json:"file_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAPIImage(i *models.Image) *apiImage {
+	return &apiImage{
+		ID:          i.ID,
+		GalleryID:   i.GalleryID,
+		Filename:    i.Filename,
+		Position:    i.Position,
+		Caption:     i.Caption,
+		AltText:     i.AltText,
+		Size:        i.Size,
+		Checksum:    i.Checksum,
+		Camera:      i.Camera(),
+		TakenAt:     i.TakenAt,
+		URL:         i.Path(),
+		OriginalURL: i.Path() + "?size=" + models.SizeOriginal,
+		FileURL:     fmt.Sprintf("/api/v1/galleries/%v/images/%v/file", i.GalleryID, i.ID),
+		CreatedAt:   i.CreatedAt,
+		UpdatedAt:   i.UpdatedAt,
+	}
+}
+
+// apiImageInput updates the caption and alt text of an image.
+type apiImageInput struct {
+	Caption *string `json:"caption"`
+	AltText *string `json:"alt_text"`
+}
+
+type apiDuplicate struct {
+	Filename        string `json:"filename"`
+	ExistingImageID uint   `json:"existing_image_id"`
+	Skipped         bool   `json:"skipped"`
+}
+
+// apiUpload is the response to an upload. Duplicates lists the
+// files identical to images the user already had.
+type apiUpload struct {
+	Data       []*apiImage    `json:"data"`
+	Duplicates []apiDuplicate `json:"duplicates"`
+}
+
+// apiList is a page of a list.
+type apiList struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+}
+
+// apiErrorBody is written for every error, with Message taken
+// from the public error of the models when there is one.
+type apiErrorBody struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Me returns the current user. The CSRF token is sent along in
+// the X-CSRF-Token header, as requests changing anything need
+// it when they are authenticated by the session cookie.
+//
+// GET /api/v1/me
+func (a *API) Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-CSRF-Token", csrf.Token(r))
+	writeJSON(w, r, http.StatusOK, newAPIUser(context.User(r.Context())))
+}
+
+// GET /api/v1/galleries
+func (a *API) Galleries(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := apiPagination(w, r)
+	if !ok {
+		return
+	}
+	userID := context.User(r.Context()).ID
+	galleries, total, err := a.gs.PageByUserID(userID, (page-1)*perPage, perPage)
+	if err != nil {
+		apiServerError(w, r, err)
+		return
+	}
+	ids := make([]uint, len(galleries))
+	for i, gallery := range galleries {
+		ids[i] = gallery.ID
+	}
+	byGallery, err := a.is.ByGalleryIDs(ids)
+	if err != nil {
+		apiServerError(w, r, err)
+		return
+	}
+	data := make([]*apiGallery, len(galleries))
+	for i := range galleries {
+		gallery := &galleries[i]
+		gallery.Images = byGallery[gallery.ID]
+		data[i] = newAPIGallery(gallery)
+	}
+	writeJSON(w, r, http.StatusOK, &apiList{
+		Data:    data,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	})
+}
+
+// POST /api/v1/galleries
+func (a *API) CreateGallery(w http.ResponseWriter, r *http.Request) {
+	var in apiGalleryInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+	gallery := models.Gallery{
+		UserID: context.User(r.Context()).ID,
+	}
+	in.apply(&gallery)
+	if err := a.gs.Create(&gallery); err != nil {
+		apiModelError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, newAPIGallery(&gallery))
+}
+
+// GET /api/v1/galleries/:id
+func (a *API) Gallery(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newAPIGallery(gallery))
+}
+
+// PATCH /api/v1/galleries/:id
+func (a *API) UpdateGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return
+	}
+	var in apiGalleryInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+	in.apply(gallery)
+	if in.CoverImageID != nil {
+		if _, ok := imageByID(gallery.Images, *in.CoverImageID); !ok {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, "The cover must be one of the images of the gallery.")
+			return
+		}
+		gallery.CoverImageID = *in.CoverImageID
+	}
+	if err := a.gs.Update(gallery); err != nil {
+		apiModelError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newAPIGallery(gallery))
+}
+
+// DeleteGallery moves the gallery to the trash.
+//
+// DELETE /api/v1/galleries/:id
+func (a *API) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return
+	}
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		apiModelError(w, r, err)
+		return
+	}
+	recordAudit(r, a.as, galleryAuditEvent(r, models.AuditGalleryDelete, gallery))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/galleries/:id/images
+func (a *API) Images(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := apiPagination(w, r)
+	if !ok {
+		return
+	}
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return
+	}
+	start, end := pageBounds(page, perPage, len(gallery.Images))
+	data := make([]*apiImage, 0, end-start)
+	for i := start; i < end; i++ {
+		data = append(data, newAPIImage(&gallery.Images[i]))
+	}
+	writeJSON(w, r, http.StatusOK, &apiList{
+		Data:    data,
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(gallery.Images),
+	})
+}
+
+// UploadImages adds the files sent as "images" in a multipart
+// form to the gallery.
+//
+// POST /api/v1/galleries/:id/images
+func (a *API) UploadImages(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(maxMultiPartMem); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "The images must be sent as a multipart form.")
+		return
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		writeAPIError(w, r, http.StatusBadRequest, "No images were sent.")
+		return
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	if !context.User(r.Context()).CanStore(size) {
+		apiModelError(w, r, models.ErrQuotaExceeded)
+		return
+	}
+
+	res := apiUpload{
+		Data:       []*apiImage{},
+		Duplicates: []apiDuplicate{},
+	}
+	for _, f := range files {
+		file, err := f.Open()
+		if err != nil {
+			apiServerError(w, r, err)
+			return
+		}
+		err = a.is.Create(gallery.ID, file, f.Filename)
+		if dup, ok := err.(*models.DuplicateError); ok {
+			res.Duplicates = append(res.Duplicates, apiDuplicate{
+				Filename:        dup.Filename,
+				ExistingImageID: dup.Existing.ID,
+				Skipped:         dup.Skipped,
+			})
+			if dup.Skipped {
+				continue
+			}
+		} else if err != nil {
+			apiModelError(w, r, err)
+			return
+		}
+		image, err := a.is.ByFilename(gallery.ID, f.Filename)
+		if err != nil {
+			apiServerError(w, r, err)
+			return
+		}
+		res.Data = append(res.Data, newAPIImage(image))
+	}
+	writeJSON(w, r, http.StatusCreated, &res)
+}
+
+// GET /api/v1/galleries/:id/images/:imageID
+func (a *API) Image(w http.ResponseWriter, r *http.Request) {
+	image, ok := a.image(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newAPIImage(image))
+}
+
+// PATCH /api/v1/galleries/:id/images/:imageID
+func (a *API) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := a.image(w, r)
+	if !ok {
+		return
+	}
+	var in apiImageInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+	if in.Caption != nil {
+		image.Caption = *in.Caption
+	}
+	if in.AltText != nil {
+		image.AltText = *in.AltText
+	}
+	if err := a.is.Update(image); err != nil {
+		apiModelError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newAPIImage(image))
+}
+
+// DeleteImage moves the image to the trash.
+//
+// DELETE /api/v1/galleries/:id/images/:imageID
+func (a *API) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := a.image(w, r)
+	if !ok {
+		return
+	}
+	if err := a.is.Delete(image); err != nil {
+		apiModelError(w, r, err)
+		return
+	}
+	recordAudit(r, a.as, imageAuditEvent(r, models.AuditImageDelete, context.User(r.Context()).ID, image))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImageFile serves the original file of the image as it was
+// uploaded.
+//
+// GET /api/v1/galleries/:id/images/:imageID/file
+func (a *API) ImageFile(w http.ResponseWriter, r *http.Request) {
+	image, ok := a.image(w, r)
+	if !ok {
+		return
+	}
+	rc, err := a.is.Open(image, models.SizeOriginal)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeAPIError(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		apiServerError(w, r, err)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", image.Filename))
+	serveImage(w, r, rc)
+}
+
+// OpenAPI serves the OpenAPI document describing the API.
+//
+// GET /api/v1/openapi.yaml
+func (a *API) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	http.ServeFile(w, r, "docs/openapi.yaml")
+}
+
+// CSRFFailure responds to requests that fail the CSRF check,
+// with an error body for the API and as gorilla/csrf does
+// otherwise.
+func CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	reason := csrf.FailureReason(r)
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		msg := "The X-CSRF-Token header is missing or invalid."
+		if reason != nil {
+			msg += " " + reason.Error()
+		}
+		writeAPIError(w, r, http.StatusForbidden, msg)
+		return
+	}
+	msg := http.StatusText(http.StatusForbidden)
+	if reason != nil {
+		msg += " - " + reason.Error()
+	}
+	http.Error(w, msg, http.StatusForbidden)
+}
+
+// gallery returns the gallery with the ID in the URL, along
+// with its images, when it belongs to the current user.
+// Otherwise it writes the error and returns false.
+func (a *API) gallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, "Gallery not found")
+		return nil, false
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err == nil && gallery.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err == models.ErrNotFound {
+		writeAPIError(w, r, http.StatusNotFound, "Gallery not found")
+		return nil, false
+	}
+	if err != nil {
+		apiServerError(w, r, err)
+		return nil, false
+	}
+	gallery.Images, err = a.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		apiServerError(w, r, err)
+		return nil, false
+	}
+	return gallery, true
+}
+
+// image returns the image with the ID in the URL from the
+// gallery of the current user with the ID in the URL.
+func (a *API) image(w http.ResponseWriter, r *http.Request) (*models.Image, bool) {
+	gallery, ok := a.gallery(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["imageID"], 10, 64)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, "Image not found")
+		return nil, false
+	}
+	image, ok := imageByID(gallery.Images, uint(id))
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, "Image not found")
+		return nil, false
+	}
+	return image, true
+}
+
+func imageByID(images []models.Image, id uint) (*models.Image, bool) {
+	for i := range images {
+		if images[i].ID == id {
+			return &images[i], true
+		}
+	}
+	return nil, false
+}
+
+// apiPagination reads the page and per_page query params.
+func apiPagination(w http.ResponseWriter, r *http.Request) (page, perPage int, ok bool) {
+	page, perPage = 1, apiPerPage
+	query := r.URL.Query()
+	if s := query.Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeAPIError(w, r, http.StatusBadRequest, "page must be a number greater than 0.")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if s := query.Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeAPIError(w, r, http.StatusBadRequest, fmt.Sprintf("per_page must be a number from 1 to %d.", apiMaxPerPage))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+// pageBounds returns the indexes of the first item of the page,
+// and of the one after its last, in a list of total items.
+func pageBounds(page, perPage, total int) (start, end int) {
+	start = (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end = start + perPage
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// readJSON decodes the body of the request into dst, writing
+// an error and returning false when it is not valid.
+func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "The body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logError(r, err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	var body apiErrorBody
+	body.Error.Status = status
+	body.Error.Message = message
+	writeJSON(w, r, status, &body)
+}
+
+// apiModelError writes the public message of errors from the
+// models, which are the client's to fix.
+func apiModelError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrNotFound:
+		writeAPIError(w, r, http.StatusNotFound, "Not found")
+		return
+	case models.ErrQuotaExceeded:
+		writeAPIError(w, r, http.StatusRequestEntityTooLarge, models.ErrQuotaExceeded.Public())
+		return
+	}
+	if pErr, ok := err.(views.PublicError); ok {
+		writeAPIError(w, r, http.StatusUnprocessableEntity, pErr.Public())
+		return
+	}
+	apiServerError(w, r, err)
+}
+
+func apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	logError(r, err)
+	writeAPIError(w, r, http.StatusInternalServerError, "Something went wrong. Please try again, and contact us if the problem persists.")
+}
@@ -0,0 +1,502 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+const (
+	EditCollection          = "edit_collection"
+	ShowCollectionBySlug    = "show_collection_by_slug"
+	ShowCollectionBySharing = "show_collection_by_sharing"
+)
+
+func NewCollections(cs models.CollectionService, gs models.GalleryService, is models.ImageService, us models.UserService, router *mux.Router) *Collections {
+	return &Collections{
+		New:       views.NewView("bootstrap", "collections/new"),
+		ShowView:  views.NewView("bootstrap", "collections/show"),
+		EditView:  views.NewView("bootstrap", "collections/edit"),
+		IndexView: views.NewView("bootstrap", "collections/index"),
+		cs:        cs,
+		gs:        gs,
+		is:        is,
+		us:        us,
+		router:    router,
+	}
+}
+
+// Collections represents the controller used to manage and
+// publish collections of galleries.
+type Collections struct {
+	New       *views.View
+	ShowView  *views.View
+	EditView  *views.View
+	IndexView *views.View
+	cs        models.CollectionService
+	gs        models.GalleryService
+	is        models.ImageService
+	us        models.UserService
+	router    *mux.Router
+}
+
+type CollectionForm struct {
+	Title       string `schema:"title"`
+	Description string `schema:"description"`
+	Slug        string `schema:"slug"`
+	Visibility  string `schema:"visibility"`
+}
+
+// CollectionGalleryForm is used to add one of the user's
+// galleries to a collection.
+type CollectionGalleryForm struct {
+	GalleryID uint `schema:"gallery_id"`
+}
+
+// CollectionOrderForm holds the gallery IDs of a collection in
+// the order they should be displayed.
+type CollectionOrderForm struct {
+	Order []uint `schema:"order"`
+}
+
+// collectionEditData is what the collection edit page expects
+// as its Yield.
+type collectionEditData struct {
+	*models.Collection
+	// Available are the user's galleries that are not part of
+	// the collection yet.
+	Available []models.Gallery
+	ShareURL  string
+}
+
+// GET /collections
+func (c *Collections) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	collections, err := c.cs.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = collections
+	c.IndexView.Render(w, r, vd)
+}
+
+// POST /collections
+func (c *Collections) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form CollectionForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.New.Render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	collection := models.Collection{
+		UserID:      user.ID,
+		Title:       form.Title,
+		Description: form.Description,
+		Visibility:  form.Visibility,
+	}
+	if err := c.cs.Create(&collection); err != nil {
+		vd.SetAlert(err)
+		c.New.Render(w, r, vd)
+		return
+	}
+	c.redirectToEdit(w, r, &collection)
+}
+
+// GET /collections/:id/edit
+func (c *Collections) Edit(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = c.editData(r, collection)
+	c.EditView.Render(w, r, vd)
+}
+
+// POST /collections/:id/update
+func (c *Collections) Update(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = c.editData(r, collection)
+
+	var form CollectionForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	collection.Title = form.Title
+	collection.Description = form.Description
+	collection.Slug = form.Slug
+	collection.Visibility = form.Visibility
+	if err := c.cs.Update(collection); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Collection successfully updated!",
+	}
+	c.EditView.Render(w, r, vd)
+}
+
+// POST /collections/:id/share
+//
+// Share replaces the share token of the collection, so the
+// previous share link stops working.
+func (c *Collections) Share(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	token, err := rand.ShareToken()
+	if err == nil {
+		collection.ShareToken = token
+		err = c.cs.Update(collection)
+	}
+	if err != nil {
+		var vd views.Data
+		vd.Yield = c.editData(r, collection)
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, c.editPath(r, collection), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "A new share link was created. The previous one no longer works.",
+	})
+}
+
+// POST /collections/:id/delete
+func (c *Collections) Delete(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	if err := c.cs.Delete(collection.ID); err != nil {
+		var vd views.Data
+		vd.Yield = c.editData(r, collection)
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, "/collections", http.StatusFound)
+}
+
+// POST /collections/:id/galleries
+func (c *Collections) AddGallery(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = c.editData(r, collection)
+
+	var form CollectionGalleryForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	gallery, err := c.gs.ByID(form.GalleryID)
+	if err != nil || gallery.UserID != collection.UserID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !collection.HasGallery(gallery.ID) {
+		if err := c.cs.AddGallery(collection.ID, gallery.ID); err != nil {
+			vd.SetAlert(err)
+			c.EditView.Render(w, r, vd)
+			return
+		}
+	}
+	c.redirectToEdit(w, r, collection)
+}
+
+// POST /collections/:id/galleries/:galleryID/remove
+func (c *Collections) RemoveGallery(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	galleryID, err := strconv.Atoi(mux.Vars(r)["galleryID"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return
+	}
+	if err := c.cs.RemoveGallery(collection.ID, uint(galleryID)); err != nil {
+		var vd views.Data
+		vd.Yield = c.editData(r, collection)
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	c.redirectToEdit(w, r, collection)
+}
+
+// POST /collections/:id/galleries/order
+func (c *Collections) OrderGalleries(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownedCollection(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = c.editData(r, collection)
+
+	var form CollectionOrderForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	if err := c.cs.ReorderGalleries(collection.ID, form.Order); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	c.redirectToEdit(w, r, collection)
+}
+
+// Show redirects to the public URL of the collection when its
+// owner has a username, and renders the collection otherwise.
+// The redirect is temporary, as the username and the slug may
+// change.
+//
+// GET /collections/:id
+func (c *Collections) Show(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if !collection.CanView(user) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	owner, err := c.us.ByID(collection.UserID)
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return
+	}
+	if !owner.PublicTo(user) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if owner.Username != "" {
+		url, err := c.router.Get(ShowCollectionBySlug).URL(
+			"username", owner.Username,
+			"slug", collection.Slug)
+		if err == nil {
+			http.Redirect(w, r, url.Path, http.StatusFound)
+			return
+		}
+	}
+	c.renderShow(w, r, collection, r.URL.Path)
+}
+
+// GET /u/:username/c/:slug
+func (c *Collections) ShowBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner, err := c.us.ByUsername(vars["username"])
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return
+	}
+	user := context.User(r.Context())
+	if !owner.PublicTo(user) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	collection, err := c.cs.BySlug(owner.ID, vars["slug"])
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return
+	}
+	if !collection.CanView(user) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	c.renderShow(w, r, collection, r.URL.Path)
+}
+
+// ShowShared renders the collection behind a share link, which
+// works whatever the visibility of the collection is, unless
+// an admin disabled the account of its owner.
+//
+// GET /c/:token
+func (c *Collections) ShowShared(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.cs.ByShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return
+	}
+	owner, err := c.us.ByID(collection.UserID)
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return
+	}
+	if !owner.PublicTo(context.User(r.Context())) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	c.renderShow(w, r, collection, r.URL.Path)
+}
+
+// renderShow loads the galleries the current user may see and
+// renders the collection, path being its canonical path.
+func (c *Collections) renderShow(w http.ResponseWriter, r *http.Request, collection *models.Collection, path string) {
+	user := context.User(r.Context())
+	galleries, err := c.galleries(collection)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for _, gallery := range galleries {
+		if gallery.CanView(user) {
+			collection.Galleries = append(collection.Galleries, gallery)
+		}
+	}
+
+	var vd views.Data
+	vd.Yield = collection
+	vd.Meta = &views.Meta{
+		Title:       collection.Title,
+		Description: excerpt(collection.Description, 200),
+		URL:         absoluteURL(r, path),
+	}
+	for _, gallery := range collection.Galleries {
+		if cover := gallery.Cover(); cover != nil {
+			vd.Meta.Image = absoluteURL(r, cover.Path())
+			break
+		}
+	}
+	c.ShowView.Render(w, r, vd)
+}
+
+// galleries returns the galleries of the collection, in order,
+// with their images loaded. Galleries deleted since they were
+// added are skipped.
+func (c *Collections) galleries(collection *models.Collection) ([]models.Gallery, error) {
+	ids, err := c.cs.GalleryIDs(collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	found, err := c.gs.ByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Gallery, len(found))
+	for _, gallery := range found {
+		byID[gallery.ID] = gallery
+	}
+	byGallery, err := c.is.ByGalleryIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	galleries := make([]models.Gallery, 0, len(found))
+	for _, id := range ids {
+		gallery, ok := byID[id]
+		if !ok {
+			continue
+		}
+		gallery.Images = byGallery[id]
+		galleries = append(galleries, gallery)
+	}
+	return galleries, nil
+}
+
+// editData builds the data rendered by the edit page.
+func (c *Collections) editData(r *http.Request, collection *models.Collection) *collectionEditData {
+	data := collectionEditData{
+		Collection: collection,
+	}
+	galleries, err := c.galleries(collection)
+	if err != nil {
+		logError(r, err)
+	}
+	collection.Galleries = galleries
+
+	owned, err := c.gs.ByUserID(collection.UserID)
+	if err != nil {
+		logError(r, err)
+	}
+	for _, gallery := range owned {
+		if !collection.HasGallery(gallery.ID) {
+			data.Available = append(data.Available, gallery)
+		}
+	}
+	if url, err := c.router.Get(ShowCollectionBySharing).URL("token", collection.ShareToken); err == nil {
+		data.ShareURL = absoluteURL(r, url.Path)
+	}
+	return &data
+}
+
+func (c *Collections) editPath(r *http.Request, collection *models.Collection) string {
+	url, err := c.router.Get(EditCollection).URL("id", fmt.Sprintf("%v", collection.ID))
+	if err != nil {
+		logError(r, err)
+		return "/collections"
+	}
+	return url.Path
+}
+
+func (c *Collections) redirectToEdit(w http.ResponseWriter, r *http.Request, collection *models.Collection) {
+	http.Redirect(w, r, c.editPath(r, collection), http.StatusFound)
+}
+
+// ownedCollection looks up the collection from the id route
+// variable and makes sure it belongs to the current user.
+func (c *Collections) ownedCollection(w http.ResponseWriter, r *http.Request) (*models.Collection, error) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return nil, err
+	}
+	user := context.User(r.Context())
+	if collection.UserID != user.ID {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return nil, models.ErrNotFound
+	}
+	return collection, nil
+}
+
+func (c *Collections) collectionByID(w http.ResponseWriter, r *http.Request) (*models.Collection, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Invalid collection ID", http.StatusNotFound)
+		return nil, err
+	}
+	collection, err := c.cs.ByID(uint(id))
+	if err != nil {
+		c.notFoundOrError(w, r, err)
+		return nil, err
+	}
+	return collection, nil
+}
+
+func (c *Collections) notFoundOrError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrNotFound:
+		http.Error(w, "Collection not found", http.StatusNotFound)
+	default:
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+	}
+}
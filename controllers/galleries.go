@@ -1,51 +1,164 @@
 package controllers
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/gorilla/mux"
 
 	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/extract"
+	"github.com/samueldaviddelacruz/lenslocked.com/imaging"
 	"github.com/samueldaviddelacruz/lenslocked.com/models"
 	"github.com/samueldaviddelacruz/lenslocked.com/views"
 )
 
 const (
-	ShowGallery = "show_gallery"
-	EditGallery = "edit_gallery"
+	ShowGallery       = "show_gallery"
+	ShowGalleryBySlug = "show_gallery_by_slug"
+	EditGallery       = "edit_gallery"
 
 	maxMultiPartMem = 1 << 20 // 1 megabyte
 )
 
-func NewGalleries(gs models.GalleryService, is models.ImageService, router *mux.Router) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService, us models.UserService, as models.AuditService, router *mux.Router) *Galleries {
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
-		ShowView:  views.NewView("bootstrap", "galleries/show"),
-		EditView:  views.NewView("bootstrap", "galleries/edit"),
-		IndexView: views.NewView("bootstrap", "galleries/index"),
-		gs:        gs,
-		is:        is,
-		router:    router,
+		New:         views.NewView("bootstrap", "galleries/new"),
+		ShowView:    views.NewView("bootstrap", "galleries/show"),
+		EditView:    views.NewView("bootstrap", "galleries/edit"),
+		IndexView:   views.NewView("bootstrap", "galleries/index"),
+		ImageView:   views.NewView("bootstrap", "galleries/image"),
+		SimilarView: views.NewView("bootstrap", "galleries/similar"),
+		gs:          gs,
+		is:          is,
+		us:          us,
+		as:          as,
+		router:      router,
 	}
 }
 
 type Galleries struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	gs        models.GalleryService
-	is        models.ImageService
-	router    *mux.Router
+	New         *views.View
+	ShowView    *views.View
+	EditView    *views.View
+	IndexView   *views.View
+	ImageView   *views.View
+	SimilarView *views.View
+	gs          models.GalleryService
+	is          models.ImageService
+	us          models.UserService
+	as          models.AuditService
+	router      *mux.Router
 }
 
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title       string `schema:"title"`
+	Description string `schema:"description"`
+	Slug        string `schema:"slug"`
+	Visibility  string `schema:"visibility"`
+	// DisableDownloads is sent by a checkbox, so it is false
+	// whenever the box is left unchecked.
+	DisableDownloads bool `schema:"disable_downloads"`
+	ShowMetadata     bool `schema:"show_metadata"`
+	StripMetadata    bool `schema:"strip_metadata"`
+	AllowOriginals   bool `schema:"allow_originals"`
+}
+
+// DownloadForm holds the query params of a gallery download.
+// When Images is empty the whole gallery is downloaded.
+type DownloadForm struct {
+	Size   string `schema:"size"`
+	Images []uint `schema:"images"`
+}
+
+// ImageForm is used to update the caption and alt text of
+// a single image.
+type ImageForm struct {
+	Caption string `schema:"caption"`
+	AltText string `schema:"alt_text"`
+}
+
+// BulkImageForm is used to apply one action to several images
+// selected on the edit page. Action is one of "delete", "move",
+// "copy" or "caption".
+type BulkImageForm struct {
+	Action          string `schema:"action"`
+	Images          []uint `schema:"images"`
+	TargetGalleryID uint   `schema:"target_gallery_id"`
+	Caption         string `schema:"caption"`
+}
+
+// sortTaken is the value of the sort query param showing the
+// images of a gallery in the order they were taken.
+const sortTaken = "taken"
+
+// galleryShowData is what the gallery page expects as its Yield.
+type galleryShowData struct {
+	*models.Gallery
+	// Sort is the order the images are shown in, either
+	// sortTaken or empty for the order chosen by the owner.
+	Sort string
+	// Originals tells whether the original files may be viewed
+	// and downloaded.
+	Originals bool
+	// Reportable tells whether the gallery may be reported,
+	// which anyone but its owner can do.
+	Reportable bool
+}
+
+// ImageURL returns the path of the lightbox page of the image,
+// keeping the order the images are shown in.
+func (d *galleryShowData) ImageURL(image models.Image) string {
+	path := fmt.Sprintf("/galleries/%d/images/%s", image.GalleryID, url.PathEscape(image.Filename))
+	if d.Sort != "" {
+		path += "?sort=" + url.QueryEscape(d.Sort)
+	}
+	return path
+}
+
+// lightbox is what the image page expects as its Yield.
+type lightbox struct {
+	galleryShowData
+	Image      *models.Image
+	Prev, Next *models.Image
+	// ShowMetadata and ShowLocation tell whether the EXIF data
+	// and GPS coordinates of the image may be shown.
+	ShowMetadata bool
+	ShowLocation bool
+}
+
+// similarData is what the similar images page expects as its
+// Yield.
+type similarData struct {
+	Groups []models.ImageGroup
+	// Galleries are the galleries of the user by ID.
+	Galleries map[uint]*models.Gallery
+}
+
+// galleryEditData is what the gallery edit page expects as its
+// Yield.
+type galleryEditData struct {
+	*models.Gallery
+	// Targets are the other galleries of the owner, which images
+	// can be moved or copied to.
+	Targets []models.Gallery
+}
+
+// ImageOrderForm holds the image IDs of a gallery in the order
+// they should be displayed.
+type ImageOrderForm struct {
+	Order []uint `schema:"order"`
 }
 
 // GET /galleries
@@ -54,10 +167,23 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	galleries, err := g.gs.ByUserID(user.ID)
 
 	if err != nil {
-		log.Println(err)
+		logError(r, err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
+	ids := make([]uint, len(galleries))
+	for i, gallery := range galleries {
+		ids[i] = gallery.ID
+	}
+	byGallery, err := g.is.ByGalleryIDs(ids)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for i := range galleries {
+		galleries[i].Images = byGallery[galleries[i].ID]
+	}
 	var vd views.Data
 	vd.Yield = galleries
 	g.IndexView.Render(w, r, vd)
@@ -65,6 +191,11 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Show redirects to the public URL of the gallery when its
+// owner has a username, and renders the gallery otherwise. The
+// redirect is temporary, as the username and the slug may
+// change and a cached one would then lead nowhere.
+//
 // GET /galleries/:id
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
 
@@ -72,11 +203,262 @@ func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	user := context.User(r.Context())
+	if !gallery.CanView(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	owner, err := g.us.ByID(gallery.UserID)
+	if err != nil {
+		g.notFoundOrError(w, r, err)
+		return
+	}
+	if !owner.PublicTo(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if url, err := g.publicURL(owner, gallery); err == nil {
+		if r.URL.RawQuery != "" {
+			url += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	g.renderShow(w, r, gallery, r.URL.Path)
+}
+
+// GET /u/:username/:slug
+func (g *Galleries) ShowBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner, err := g.us.ByUsername(vars["username"])
+	if err != nil {
+		g.notFoundOrError(w, r, err)
+		return
+	}
+	user := context.User(r.Context())
+	if !owner.PublicTo(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	gallery, err := g.gs.BySlug(owner.ID, vars["slug"])
+	if err != nil {
+		g.notFoundOrError(w, r, err)
+		return
+	}
+	if !gallery.CanView(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	images, _ := g.is.ByGalleryID(gallery.ID)
+	gallery.Images = images
+
+	g.renderShow(w, r, gallery, r.URL.Path)
+}
+
+// renderShow renders the gallery along with the tags used when
+// it is shared, path being the canonical path of the gallery.
+func (g *Galleries) renderShow(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, path string) {
+	data := showData(r, gallery)
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = &data
+	vd.Meta = &views.Meta{
+		Title:       gallery.Title,
+		Description: excerpt(gallery.Description, 200),
+		URL:         absoluteURL(r, path),
+	}
+	if cover := gallery.Cover(); cover != nil {
+		vd.Meta.Image = absoluteURL(r, cover.Path())
+	}
 
 	g.ShowView.Render(w, r, vd)
+}
+
+// showData sorts the images of the gallery in the order asked
+// for by the sort query param.
+func showData(r *http.Request, gallery *models.Gallery) galleryShowData {
+	user := context.User(r.Context())
+	data := galleryShowData{
+		Gallery:    gallery,
+		Originals:  gallery.CanAccessOriginals(user),
+		Reportable: user == nil || user.ID != gallery.UserID,
+	}
+	if r.URL.Query().Get("sort") == sortTaken {
+		data.Sort = sortTaken
+		models.SortByTakenAt(gallery.Images)
+	}
+	return data
+}
+
+// ImageShow renders a single image of the gallery, with links
+// to the previous and next ones, and the details read from its
+// EXIF data when the owner chose to show them.
+//
+// GET /galleries/:id/images/:filename
+func (g *Galleries) ImageShow(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if !gallery.CanView(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !g.ownerPublic(w, r, gallery) {
+		return
+	}
+	data := lightbox{galleryShowData: showData(r, gallery)}
+	filename := mux.Vars(r)["filename"]
+	for i := range gallery.Images {
+		if gallery.Images[i].Filename != filename {
+			continue
+		}
+		data.Image = &gallery.Images[i]
+		if i > 0 {
+			data.Prev = &gallery.Images[i-1]
+		}
+		if i < len(gallery.Images)-1 {
+			data.Next = &gallery.Images[i+1]
+		}
+	}
+	if data.Image == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	isOwner := user != nil && user.ID == gallery.UserID
+	data.ShowMetadata = isOwner || gallery.ShowMetadata
+	data.ShowLocation = isOwner || (gallery.ShowMetadata && !gallery.StripMetadata)
 
+	var vd views.Data
+	vd.Yield = &data
+	vd.Meta = &views.Meta{
+		Title:       gallery.Title,
+		Description: excerpt(data.Image.Caption, 200),
+		URL:         absoluteURL(r, r.URL.Path),
+		Image:       absoluteURL(r, data.Image.Path()),
+	}
+	g.ImageView.Render(w, r, vd)
+}
+
+// ImageFile serves an image to those who can view its gallery.
+// The web size is served, in the most compact format the
+// browser accepts, unless the original is asked for by someone
+// allowed to get it. Originals have their metadata removed when
+// the gallery strips it, and only the owner gets the file as it
+// was uploaded.
+//
+// GET /images/galleries/:id/:filename?size=original
+func (g *Galleries) ImageFile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return
+	}
+	gallery, err := g.gs.ByID(uint(id))
+	if err != nil {
+		g.notFoundOrError(w, r, err)
+		return
+	}
+	user := context.User(r.Context())
+	if !gallery.CanView(user) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if !g.ownerPublic(w, r, gallery) {
+		return
+	}
+	image, err := g.imageByFilename(w, r, gallery)
+	if err != nil {
+		return
+	}
+	isOwner := user != nil && user.ID == gallery.UserID
+
+	var rc io.ReadCloser
+	switch {
+	case r.URL.Query().Get("size") != models.SizeOriginal || !gallery.CanAccessOriginals(user):
+		w.Header().Set("Vary", "Accept")
+		rc, err = g.openWeb(r, image)
+	case gallery.StripMetadata && !isOwner:
+		rc, err = g.is.OpenStripped(image)
+	default:
+		rc, err = g.is.Open(image, models.SizeOriginal)
+	}
+	if err != nil {
+		if os.IsNotExist(err) || err == models.ErrImageUndecodable {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	if gallery.Visibility == models.VisibilityPrivate {
+		w.Header().Set("Cache-Control", "private")
+	}
+	serveImage(w, r, rc)
+}
+
+// openWeb opens the web size of the image in AVIF or WebP when
+// the Accept header allows it. The variant every browser can
+// display is used when it does not, or when the conversion
+// fails.
+func (g *Galleries) openWeb(r *http.Request, image *models.Image) (io.ReadCloser, error) {
+	for _, format := range []string{imaging.FormatAVIF, imaging.FormatWebP} {
+		if !accepts(r.Header.Get("Accept"), "image/"+format) {
+			continue
+		}
+		rc, err := g.is.OpenWebAs(image, format)
+		if err == nil || err == models.ErrImageUndecodable {
+			return rc, err
+		}
+		logError(r, err)
+	}
+	return g.is.Open(image, models.SizeWeb)
+}
+
+// Similar lists the images of the current user that are
+// identical or look alike, so duplicates can be cleaned up.
+//
+// GET /galleries/similar
+func (g *Galleries) Similar(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	groups, err := g.is.Similar(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data := similarData{
+		Groups:    groups,
+		Galleries: make(map[uint]*models.Gallery),
+	}
+	for i := range galleries {
+		data.Galleries[galleries[i].ID] = &galleries[i]
+	}
+	var vd views.Data
+	vd.Yield = &data
+	g.SimilarView.Render(w, r, vd)
+}
+
+// publicURL returns the /u/:username/:slug path of the gallery.
+func (g *Galleries) publicURL(owner *models.User, gallery *models.Gallery) (string, error) {
+	if owner.Username == "" || gallery.Slug == "" {
+		return "", models.ErrNotFound
+	}
+	url, err := g.router.Get(ShowGalleryBySlug).URL(
+		"username", owner.Username,
+		"slug", gallery.Slug)
+	if err != nil {
+		return "", err
+	}
+	return url.Path, nil
 }
 
 // GET /galleries/:id/edit
@@ -92,7 +474,7 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(r, gallery)
 
 	g.EditView.Render(w, r, vd)
 
@@ -111,7 +493,7 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(r, gallery)
 
 	var form GalleryForm
 	if err := parseForm(r, &form); err != nil {
@@ -121,6 +503,13 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	gallery.Title = form.Title
+	gallery.Description = form.Description
+	gallery.Slug = form.Slug
+	gallery.Visibility = form.Visibility
+	gallery.DownloadsDisabled = form.DisableDownloads
+	gallery.ShowMetadata = form.ShowMetadata
+	gallery.StripMetadata = form.StripMetadata
+	gallery.AllowOriginals = form.AllowOriginals
 	err = g.gs.Update(gallery)
 	if err != nil {
 		vd.SetAlert(err)
@@ -152,11 +541,15 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		var vd views.Data
 		vd.SetAlert(err)
-		vd.Yield = gallery
+		vd.Yield = g.editData(r, gallery)
 		g.EditView.Render(w, r, vd)
 		return
 	}
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	recordAudit(r, g.as, galleryAuditEvent(r, models.AuditGalleryDelete, gallery))
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " was moved to the trash, where you can restore it.",
+	})
 
 }
 
@@ -172,8 +565,10 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	user := context.User(r.Context())
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:       form.Title,
+		Description: form.Description,
+		Visibility:  form.Visibility,
+		UserID:      user.ID,
 	}
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
@@ -183,7 +578,7 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 
 	if err != nil {
-		log.Println(err)
+		logError(r, err)
 		http.Redirect(w, r, "/galleries", http.StatusFound)
 		return
 	}
@@ -203,7 +598,7 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(r, gallery)
 	// TODO: Parse a multipart form
 	err = r.ParseMultipartForm(maxMultiPartMem)
 	if err != nil {
@@ -213,7 +608,21 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	files := r.MultipartForm.File["images"]
+	// The images are checked against the quota as they are
+	// created, but most uploads that do not fit can be turned
+	// down before any of them is.
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	if !user.CanStore(size) {
+		vd.SetAlert(models.ErrQuotaExceeded)
+		g.EditView.Render(w, r, vd)
+		return
+	}
 
+	var archives []archiveResult
+	var duplicates []*models.DuplicateError
 	for _, f := range files {
 		// Open the uploaded file
 		file, err := f.Open()
@@ -223,7 +632,21 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer file.Close()
+		if extract.IsArchive(f.Filename) {
+			res, err := g.importArchive(gallery, file, f, &duplicates)
+			if err != nil {
+				setArchiveAlert(&vd, f.Filename, err)
+				g.EditView.Render(w, r, vd)
+				return
+			}
+			archives = append(archives, archiveResult{f.Filename, res})
+			continue
+		}
 		err = g.is.Create(gallery.ID, file, f.Filename)
+		if dup, ok := err.(*models.DuplicateError); ok {
+			duplicates = append(duplicates, dup)
+			continue
+		}
 		if err != nil {
 			vd.SetAlert(err)
 			g.EditView.Render(w, r, vd)
@@ -232,11 +655,132 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 	if err != nil {
-		log.Println(err)
+		logError(r, err)
 		http.Redirect(w, r, "/galleries", http.StatusNotFound)
 		return
 	}
-	http.Redirect(w, r, url.Path, http.StatusFound)
+	alert, ok := uploadAlert(archives, duplicates)
+	if !ok {
+		http.Redirect(w, r, url.Path, http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// maxSkippedInAlert is how many skipped archive entries, or
+// duplicate images, are named in the alert, as alerts are
+// stored in cookies.
+const maxSkippedInAlert = 5
+
+type archiveResult struct {
+	filename string
+	*extract.Result
+}
+
+// importArchive imports every image found in an uploaded ZIP or
+// tar archive into the gallery. Images identical to ones the
+// user already has are added to duplicates, and are counted as
+// skipped entries when they were left out.
+func (g *Galleries) importArchive(gallery *models.Gallery, file multipart.File, header *multipart.FileHeader, duplicates *[]*models.DuplicateError) (*extract.Result, error) {
+	skipped := make(map[string]*models.DuplicateError)
+	res, err := extract.File(header.Filename, file, header.Size, extract.DefaultLimits,
+		func(filename string, r io.Reader) error {
+			err := g.is.Create(gallery.ID, ioutil.NopCloser(r), filename)
+			if dup, ok := err.(*models.DuplicateError); ok {
+				*duplicates = append(*duplicates, dup)
+				if dup.Skipped {
+					skipped[filename] = dup
+				}
+				return nil
+			}
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	imported := res.Imported[:0]
+	for _, filename := range res.Imported {
+		if _, ok := skipped[filename]; !ok {
+			imported = append(imported, filename)
+		}
+	}
+	res.Imported = imported
+	return res, nil
+}
+
+// setArchiveAlert explains why an uploaded archive could not be
+// imported.
+func setArchiveAlert(vd *views.Data, filename string, err error) {
+	switch err {
+	case extract.ErrTooManyEntries:
+		vd.AlertError(fmt.Sprintf("%s has too many files. Please upload at most %d files per archive.",
+			filename, extract.DefaultLimits.MaxEntries))
+	case extract.ErrTooLarge:
+		vd.AlertError(filename + " is too large once extracted. Please split it into smaller archives.")
+	case zip.ErrFormat, gzip.ErrHeader, tar.ErrHeader, io.ErrUnexpectedEOF:
+		vd.AlertError(filename + " could not be extracted. Please check it is a valid zip or tar archive.")
+	default:
+		vd.SetAlert(err)
+	}
+}
+
+// archiveAlert summarizes the imported archives, naming the
+// first few entries that were skipped and why.
+func archiveAlert(archives []archiveResult) views.Alert {
+	var imported int
+	var skipped []string
+	for _, a := range archives {
+		imported += len(a.Imported)
+		for _, s := range a.Skipped {
+			skipped = append(skipped, fmt.Sprintf("%s in %s (%s)", s.Name, a.filename, s.Reason))
+		}
+	}
+	msg := fmt.Sprintf("Imported %d images from the uploaded archives.", imported)
+	if len(skipped) == 0 {
+		return views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: msg,
+		}
+	}
+	msg += fmt.Sprintf(" Skipped %d files: ", len(skipped))
+	if len(skipped) > maxSkippedInAlert {
+		msg += strings.Join(skipped[:maxSkippedInAlert], ", ") +
+			fmt.Sprintf(" and %d more.", len(skipped)-maxSkippedInAlert)
+	} else {
+		msg += strings.Join(skipped, ", ") + "."
+	}
+	return views.Alert{
+		Level:   views.AlertLvlWarning,
+		Message: msg,
+	}
+}
+
+// uploadAlert summarizes the uploaded archives and warns about
+// the first few duplicate images. It returns false when there
+// is nothing to tell.
+func uploadAlert(archives []archiveResult, duplicates []*models.DuplicateError) (views.Alert, bool) {
+	if len(archives) == 0 && len(duplicates) == 0 {
+		return views.Alert{}, false
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Images uploaded.",
+	}
+	if len(archives) > 0 {
+		alert = archiveAlert(archives)
+	}
+	if len(duplicates) == 0 {
+		return alert, true
+	}
+	alert.Level = views.AlertLvlWarning
+	for i, dup := range duplicates {
+		if i == maxSkippedInAlert {
+			alert.Message += fmt.Sprintf(" %d more images were duplicates too.", len(duplicates)-i)
+			break
+		}
+		alert.Message += " " + dup.Public()
+	}
+	return alert, true
 }
 
 // POST /galleries/:id/images/link
@@ -252,42 +796,71 @@ func (g *Galleries) ImageViaLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
+	vd.Yield = g.editData(r, gallery)
 
 	if err := r.ParseForm(); err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
-	files := r.PostForm["files"]
-	var wg sync.WaitGroup
-	wg.Add(len(files))
-	for _, fileURL := range files {
-
-		go func(url string) {
-			defer wg.Done()
-			resp, err := http.Get(url)
-			if err != nil {
-				log.Println("failed to download the image from: ", url)
-				return
-			}
-			defer resp.Body.Close()
-			pieces := strings.Split(url, "/")
-			filename := pieces[len(pieces)-1]
-			if err := g.is.Create(gallery.ID, resp.Body, filename); err != nil {
-				log.Println("failed to create the image from: ", url)
-			}
-		}(fileURL)
-
+	// Images are imported one at a time, as each one has to be
+	// counted against the quota, and given its position, before
+	// the next one is checked.
+	var duplicates []*models.DuplicateError
+	var failed error
+	for _, fileURL := range r.PostForm["files"] {
+		err := g.imageFromLink(gallery, fileURL)
+		if dup, ok := err.(*models.DuplicateError); ok {
+			duplicates = append(duplicates, dup)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		logError(r, err, "url", fileURL)
+		if _, ok := failed.(views.PublicError); !ok {
+			failed = err
+		}
+		if err == models.ErrQuotaExceeded {
+			break
+		}
 	}
-	wg.Wait()
 	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 	if err != nil {
-		log.Println(err)
+		logError(r, err)
 		http.Redirect(w, r, "/galleries", http.StatusNotFound)
 		return
 	}
-	http.Redirect(w, r, url.Path, http.StatusFound)
+	if failed != nil {
+		alert := views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		}
+		if pErr, ok := failed.(views.PublicError); ok {
+			alert.Message = pErr.Public()
+		}
+		views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+		return
+	}
+	alert, ok := uploadAlert(nil, duplicates)
+	if !ok {
+		http.Redirect(w, r, url.Path, http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// imageFromLink downloads the file at fileURL into the gallery,
+// named after the last segment of its path.
+func (g *Galleries) imageFromLink(gallery *models.Gallery, fileURL string) error {
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	pieces := strings.Split(fileURL, "/")
+	filename := pieces[len(pieces)-1]
+	return g.is.Create(gallery.ID, resp.Body, filename)
 }
 
 // POST /galleries/:id/images/:filename/delete
@@ -301,31 +874,390 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Gallery not found", http.StatusNotFound)
 		return
 	}
-	filename := mux.Vars(r)["filename"]
+	i, err := g.imageByFilename(w, r, gallery)
+	if err != nil {
+		return
+	}
 
-	i := models.Image{
-		Filename:  filename,
-		GalleryID: gallery.ID,
+	err = g.is.Delete(i)
+	if err != nil {
+		var vd views.Data
+		vd.Yield = g.editData(r, gallery)
+
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	recordAudit(r, g.as, imageAuditEvent(r, models.AuditImageDelete, user.ID, i))
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: i.Filename + " was moved to the trash.",
+	}
+	// Images deleted from the similar images page go back there.
+	if r.PostFormValue("from") == "similar" {
+		views.RedirectAlert(w, r, "/galleries/similar", http.StatusFound, alert)
+		return
+	}
+	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+
+	if err != nil {
+		logError(r, err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+
+}
+
+// POST /galleries/:id/images/:filename/update
+func (g *Galleries) ImageUpdate(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByFilename(w, r, gallery)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(r, gallery)
+
+	var form ImageForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	image.Caption = form.Caption
+	image.AltText = form.AltText
+	if err := g.is.Update(image); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
+// POST /galleries/:id/images/:filename/cover
+func (g *Galleries) ImageCover(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
 	}
+	image, err := g.imageByFilename(w, r, gallery)
+	if err != nil {
+		return
+	}
+	gallery.CoverImageID = image.ID
+	if err := g.gs.Update(gallery); err != nil {
+		var vd views.Data
+		vd.Yield = g.editData(r, gallery)
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
+// POST /galleries/:id/images/order
+func (g *Galleries) ImageOrder(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(r, gallery)
+
+	var form ImageOrderForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	if err := g.is.Reorder(gallery.ID, form.Order); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
 
-	err = g.is.Delete(&i)
+// ImageOrderByTaken saves the order the images were taken in as
+// the order of the gallery.
+//
+// POST /galleries/:id/images/order/taken
+func (g *Galleries) ImageOrderByTaken(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
 	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	models.SortByTakenAt(gallery.Images)
+	ids := make([]uint, len(gallery.Images))
+	for i, image := range gallery.Images {
+		ids[i] = image.ID
+	}
+	if err := g.is.Reorder(gallery.ID, ids); err != nil {
 		var vd views.Data
-		vd.Yield = gallery
+		vd.Yield = g.editData(r, gallery)
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	g.redirectToEdit(w, r, gallery)
+}
+
+// POST /galleries/:id/images/bulk
+func (g *Galleries) ImageBulk(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = g.editData(r, gallery)
 
+	var form BulkImageForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	var message string
+	switch form.Action {
+	case "delete":
+		err = g.is.DeleteMany(gallery.ID, form.Images)
+		message = "The selected images were moved to the trash."
+	case "move", "copy":
+		target, terr := g.gs.ByID(form.TargetGalleryID)
+		if terr != nil || target.UserID != user.ID {
+			vd.AlertError("Please choose one of your galleries to " + form.Action + " the images to.")
+			g.EditView.Render(w, r, vd)
+			return
+		}
+		if form.Action == "move" {
+			err = g.is.MoveMany(gallery.ID, form.Images, target.ID)
+			message = "The selected images were moved to " + target.Title + "."
+		} else {
+			err = g.is.CopyMany(gallery.ID, form.Images, target.ID)
+			message = "The selected images were copied to " + target.Title + "."
+		}
+	case "caption":
+		err = g.is.SetCaptions(gallery.ID, form.Images, form.Caption)
+		message = "The captions of the selected images were updated."
+	default:
+		vd.AlertError("Please choose what to do with the selected images.")
+		g.EditView.Render(w, r, vd)
+		return
+	}
+	if err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
 	}
+	if form.Action == "delete" {
+		event := galleryAuditEvent(r, models.AuditImageDelete, gallery)
+		event.Detail = fmt.Sprintf("%d images of %s", len(form.Images), gallery.Title)
+		recordAudit(r, g.as, event)
+	}
 	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+	if err != nil {
+		logError(r, err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+// editData builds the data rendered by the edit page.
+func (g *Galleries) editData(r *http.Request, gallery *models.Gallery) *galleryEditData {
+	data := galleryEditData{
+		Gallery: gallery,
+	}
+	galleries, err := g.gs.ByUserID(gallery.UserID)
+	if err != nil {
+		logError(r, err)
+	}
+	for _, other := range galleries {
+		if other.ID != gallery.ID {
+			data.Targets = append(data.Targets, other)
+		}
+	}
+	return &data
+}
+
+// Download streams a ZIP archive with the images of the gallery
+// straight to the response, so nothing is buffered to disk.
+//
+// GET /galleries/:id/download?size=web&images=1&images=2
+func (g *Galleries) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	isOwner := user != nil && user.ID == gallery.UserID
+	if !gallery.CanView(user) || (gallery.DownloadsDisabled && !isOwner) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !g.ownerPublic(w, r, gallery) {
+		return
+	}
+	form := DownloadForm{Size: models.SizeWeb}
+	if err := parseURLParams(r, &form); err != nil {
+		http.Error(w, "Invalid download options", http.StatusBadRequest)
+		return
+	}
+	if form.Size != models.SizeWeb && form.Size != models.SizeOriginal {
+		http.Error(w, "Invalid image size", http.StatusBadRequest)
+		return
+	}
+	if form.Size == models.SizeOriginal && !gallery.CanAccessOriginals(user) {
+		http.Error(w, "Originals are not available for this gallery", http.StatusForbidden)
+		return
+	}
+	images := gallery.Images
+	if len(form.Images) > 0 {
+		images = selectImages(gallery.Images, form.Images)
+	}
+	if len(images) == 0 {
+		http.Error(w, "No images to download", http.StatusNotFound)
+		return
+	}
 
+	name := gallery.Slug
+	if name == "" {
+		name = fmt.Sprintf("gallery-%d", gallery.ID)
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+
+	// Visitors never get the metadata of a gallery stripping it.
+	strip := gallery.StripMetadata && !isOwner && form.Size == models.SizeOriginal
+	zw := zip.NewWriter(w)
+	names := make(map[string]bool, len(images))
+	for i := range images {
+		err := g.writeZipEntry(zw, &images[i], form.Size, strip, names)
+		if err == models.ErrImageUndecodable {
+			// There is no web size of files we cannot decode,
+			// and their original is not ours to hand out here.
+			continue
+		}
+		if err != nil {
+			// The response has already started, all we can do is
+			// stop and leave the client with a truncated archive.
+			logError(r, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logError(r, err)
+	}
+}
+
+// writeZipEntry copies one image into the archive. Images are
+// already compressed, so they are stored as is. names holds the
+// entry names used so far, so no two entries share one.
+func (g *Galleries) writeZipEntry(zw *zip.Writer, image *models.Image, size string, strip bool, names map[string]bool) error {
+	var rc io.ReadCloser
+	var err error
+	if strip {
+		rc, err = g.is.OpenStripped(image)
+	} else {
+		rc, err = g.is.Open(image, size)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// Variants may be converted, eg from HEIC to JPEG, so the
+	// name follows their actual format.
+	br := bufio.NewReader(rc)
+	head, _ := br.Peek(512)
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     uniqueName(names, filenameFor(image.Filename, imaging.ContentType(head))),
+		Method:   zip.Store,
+		Modified: image.CreatedAt,
+	})
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	_, err = io.Copy(fw, br)
+	return err
+}
+
+// selectImages returns the images whose IDs are in ids, keeping
+// the gallery order.
+func selectImages(images []models.Image, ids []uint) []models.Image {
+	wanted := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var selected []models.Image
+	for _, image := range images {
+		if wanted[image.ID] {
+			selected = append(selected, image)
+		}
+	}
+	return selected
+}
+
+// redirectToEdit sends the user back to the edit page of the
+// provided gallery.
+func (g *Galleries) redirectToEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) {
+	url, err := g.router.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+	if err != nil {
+		logError(r, err)
 		http.Redirect(w, r, "/galleries", http.StatusFound)
 		return
 	}
 	http.Redirect(w, r, url.Path, http.StatusFound)
+}
 
+// imageByFilename looks up the image named by the filename
+// route variable within the provided gallery.
+func (g *Galleries) imageByFilename(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Image, error) {
+	filename := mux.Vars(r)["filename"]
+	image, err := g.is.ByFilename(gallery.ID, filename)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return image, nil
 }
 
 func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
@@ -333,21 +1265,14 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println(err)
+		logError(r, err)
 		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
 		return nil, err
 	}
 
 	gallery, err := g.gs.ByID(uint(id))
 	if err != nil {
-		switch err {
-		case models.ErrNotFound:
-			http.Error(w, "Gallery not found", http.StatusNotFound)
-
-		default:
-			log.Println(err)
-			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
-		}
+		g.notFoundOrError(w, r, err)
 		return nil, err
 
 	}
@@ -356,3 +1281,38 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 
 	return gallery, nil
 }
+
+// ownerPublic reports whether the owner of the gallery lets
+// the current user see it, which they do unless an admin
+// disabled their account, and responds with a 404 when not.
+// Owners are not looked up for their own galleries, as they
+// cannot be logged in while disabled.
+func (g *Galleries) ownerPublic(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) bool {
+	user := context.User(r.Context())
+	if user != nil && user.ID == gallery.UserID {
+		return true
+	}
+	owner, err := g.us.ByID(gallery.UserID)
+	if err != nil {
+		g.notFoundOrError(w, r, err)
+		return false
+	}
+	if !owner.PublicTo(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// notFoundOrError responds with a 404 when err is
+// models.ErrNotFound and with a 500 otherwise.
+func (g *Galleries) notFoundOrError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrNotFound:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+
+	default:
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+	}
+}
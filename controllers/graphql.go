@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	llctx "github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+)
+
+const (
+	// graphQLMaxDepth is how deeply selections may be nested,
+	// so a query cannot walk from galleries to their owner and
+	// back without end.
+	graphQLMaxDepth = 10
+	// graphQLMaxParallelism is how many resolvers of a query may
+	// run at once.
+	graphQLMaxParallelism = 10
+)
+
+// graphQLSchema is the schema served at /api/graphql. Nested
+// lists are resolved in batches, so asking for the images of
+// every gallery of a user costs a single query for the images.
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	# The current user.
+	me: User!
+	# The user with the username, or null when there is none.
+	user(username: String!): User
+	# The gallery with the ID, or null when there is none or it
+	# is private to another user.
+	gallery(id: ID!): Gallery
+}
+
+type User {
+	id: ID!
+	name: String!
+	username: String!
+	bio: String!
+	# The email of the current user, and null for everyone else.
+	email: String
+	# Every gallery for the current user, and only the public
+	# ones for everyone else.
+	galleries: [Gallery!]!
+}
+
+type Gallery {
+	id: ID!
+	title: String!
+	description: String!
+	slug: String!
+	visibility: String!
+	url: String!
+	createdAt: Time!
+	updatedAt: Time!
+	owner: User!
+	imageCount: Int!
+	cover: Image
+	# The images in their order in the gallery, only the first
+	# ones when first is set.
+	images(first: Int): [Image!]!
+}
+
+type Image {
+	id: ID!
+	filename: String!
+	position: Int!
+	caption: String!
+	altText: String!
+	camera: String
+	takenAt: Time
+	# The web size of the image, also served as WebP or AVIF to
+	# clients that accept them.
+	url: String!
+	variants: [ImageVariant!]!
+}
+
+# A size of an image. The original is only listed when the
+# gallery lets the current user download it.
+type ImageVariant {
+	name: String!
+	url: String!
+}
+`
+
+// errGraphQLServer replaces the errors the models return that
+// are not the client's to fix, which are logged instead.
+var errGraphQLServer = errors.New("Something went wrong. Please try again, and contact us if the problem persists.")
+
+func NewGraphQL(us models.UserService, gs models.GalleryService, is models.ImageService) *GraphQL {
+	root := &gqlQuery{us: us, gs: gs, is: is}
+	return &GraphQL{
+		schema: graphql.MustParseSchema(graphQLSchema, root,
+			graphql.MaxDepth(graphQLMaxDepth),
+			graphql.MaxParallelism(graphQLMaxParallelism)),
+	}
+}
+
+// GraphQL serves a read only GraphQL schema over users,
+// galleries and images, as the current user sees them.
+type GraphQL struct {
+	schema *graphql.Schema
+}
+
+// graphQLRequest is the body of a GraphQL request.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// Extensions is sent by some clients and ignored.
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// Serve runs the query sent as a JSON body, or in the query,
+// operationName and variables params of a GET request.
+//
+// GET /api/graphql
+// POST /api/graphql
+func (g *GraphQL) Serve(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeAPIError(w, r, http.StatusBadRequest, "variables is not valid JSON: "+err.Error())
+				return
+			}
+		}
+	} else if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Query == "" {
+		writeAPIError(w, r, http.StatusBadRequest, "No query was sent.")
+		return
+	}
+	res := g.schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+type gqlQuery struct {
+	us models.UserService
+	gs models.GalleryService
+	is models.ImageService
+}
+
+func (q *gqlQuery) Me(ctx context.Context) *gqlUser {
+	return &gqlUser{q: q, user: llctx.User(ctx)}
+}
+
+func (q *gqlQuery) User(ctx context.Context, args struct{ Username string }) (*gqlUser, error) {
+	user, err := q.us.ByUsername(args.Username)
+	if err != nil {
+		return nil, gqlError(ctx, err)
+	}
+	if !user.PublicTo(llctx.User(ctx)) {
+		return nil, nil
+	}
+	return &gqlUser{q: q, user: user}, nil
+}
+
+func (q *gqlQuery) Gallery(ctx context.Context, args struct{ ID graphql.ID }) (*gqlGallery, error) {
+	id, err := strconv.ParseUint(string(args.ID), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	gallery, err := q.gs.ByID(uint(id))
+	if err != nil {
+		return nil, gqlError(ctx, err)
+	}
+	viewer := llctx.User(ctx)
+	if !gallery.CanView(viewer) {
+		return nil, nil
+	}
+	if viewer.ID != gallery.UserID {
+		owner, err := q.us.ByID(gallery.UserID)
+		if err != nil {
+			return nil, gqlError(ctx, err)
+		}
+		if !owner.PublicTo(viewer) {
+			return nil, nil
+		}
+	}
+	return q.newGalleries([]models.Gallery{*gallery}, nil)[0], nil
+}
+
+// newGalleries wraps the galleries so their images are loaded
+// together the first time any of them is asked for. owner is
+// nil when it is looked up only if asked for.
+func (q *gqlQuery) newGalleries(galleries []models.Gallery, owner *gqlUser) []*gqlGallery {
+	batch := &imageBatch{is: q.is}
+	res := make([]*gqlGallery, len(galleries))
+	for i := range galleries {
+		batch.ids = append(batch.ids, galleries[i].ID)
+		res[i] = &gqlGallery{q: q, gallery: &galleries[i], owner: owner, batch: batch}
+	}
+	return res
+}
+
+// imageBatch loads the images of a list of galleries with a
+// single query. Resolvers run concurrently, so it is loaded
+// once by whichever asks first.
+type imageBatch struct {
+	is     models.ImageService
+	ids    []uint
+	once   sync.Once
+	images map[uint][]models.Image
+	err    error
+}
+
+func (b *imageBatch) load(ctx context.Context, galleryID uint) ([]models.Image, error) {
+	b.once.Do(func() {
+		b.images, b.err = b.is.ByGalleryIDs(b.ids)
+	})
+	if b.err != nil {
+		return nil, gqlError(ctx, b.err)
+	}
+	return b.images[galleryID], nil
+}
+
+type gqlUser struct {
+	q    *gqlQuery
+	user *models.User
+}
+
+func (u *gqlUser) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(u.user.ID), 10))
+}
+
+func (u *gqlUser) Name() string     { return u.user.Name }
+func (u *gqlUser) Username() string { return u.user.Username }
+func (u *gqlUser) Bio() string      { return u.user.Bio }
+
+func (u *gqlUser) Email(ctx context.Context) *string {
+	if !u.isViewer(ctx) {
+		return nil
+	}
+	return &u.user.Email
+}
+
+func (u *gqlUser) Galleries(ctx context.Context) ([]*gqlGallery, error) {
+	galleries, err := u.q.gs.ByUserID(u.user.ID)
+	if err != nil {
+		return nil, gqlError(ctx, err)
+	}
+	if !u.isViewer(ctx) {
+		listed := galleries[:0]
+		for _, g := range galleries {
+			if g.Listed() {
+				listed = append(listed, g)
+			}
+		}
+		galleries = listed
+	}
+	return u.q.newGalleries(galleries, u), nil
+}
+
+func (u *gqlUser) isViewer(ctx context.Context) bool {
+	viewer := llctx.User(ctx)
+	return viewer != nil && viewer.ID == u.user.ID
+}
+
+type gqlGallery struct {
+	q       *gqlQuery
+	gallery *models.Gallery
+	owner   *gqlUser
+	batch   *imageBatch
+}
+
+func (g *gqlGallery) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(g.gallery.ID), 10))
+}
+
+func (g *gqlGallery) Title() string       { return g.gallery.Title }
+func (g *gqlGallery) Description() string { return g.gallery.Description }
+func (g *gqlGallery) Slug() string        { return g.gallery.Slug }
+func (g *gqlGallery) Visibility() string  { return g.gallery.Visibility }
+
+func (g *gqlGallery) URL() string {
+	return fmt.Sprintf("/galleries/%v", g.gallery.ID)
+}
+
+func (g *gqlGallery) CreatedAt() graphql.Time { return graphql.Time{Time: g.gallery.CreatedAt} }
+func (g *gqlGallery) UpdatedAt() graphql.Time { return graphql.Time{Time: g.gallery.UpdatedAt} }
+
+func (g *gqlGallery) Owner(ctx context.Context) (*gqlUser, error) {
+	if g.owner != nil {
+		return g.owner, nil
+	}
+	user, err := g.q.us.ByID(g.gallery.UserID)
+	if err != nil {
+		return nil, gqlError(ctx, err)
+	}
+	return &gqlUser{q: g.q, user: user}, nil
+}
+
+func (g *gqlGallery) ImageCount(ctx context.Context) (int32, error) {
+	images, err := g.batch.load(ctx, g.gallery.ID)
+	if err != nil {
+		return 0, err
+	}
+	return int32(len(images)), nil
+}
+
+func (g *gqlGallery) Cover(ctx context.Context) (*gqlImage, error) {
+	images, err := g.batch.load(ctx, g.gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	gallery := *g.gallery
+	gallery.Images = images
+	cover := gallery.Cover()
+	if cover == nil {
+		return nil, nil
+	}
+	return &gqlImage{gallery: g.gallery, image: cover}, nil
+}
+
+func (g *gqlGallery) Images(ctx context.Context, args struct{ First *int32 }) ([]*gqlImage, error) {
+	images, err := g.batch.load(ctx, g.gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	if args.First != nil && *args.First >= 0 && int(*args.First) < len(images) {
+		images = images[:*args.First]
+	}
+	res := make([]*gqlImage, len(images))
+	for i := range images {
+		res[i] = &gqlImage{gallery: g.gallery, image: &images[i]}
+	}
+	return res, nil
+}
+
+type gqlImage struct {
+	gallery *models.Gallery
+	image   *models.Image
+}
+
+func (i *gqlImage) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(i.image.ID), 10))
+}
+
+func (i *gqlImage) Filename() string { return i.image.Filename }
+func (i *gqlImage) Position() int32  { return int32(i.image.Position) }
+func (i *gqlImage) Caption() string  { return i.image.Caption }
+func (i *gqlImage) AltText() string  { return i.image.AltText }
+func (i *gqlImage) URL() string      { return i.image.Path() }
+
+// Camera and TakenAt are only shown to visitors when the gallery
+// shows the metadata of its images.
+func (i *gqlImage) Camera(ctx context.Context) *string {
+	camera := i.image.Camera()
+	if camera == "" || !i.showMetadata(ctx) {
+		return nil
+	}
+	return &camera
+}
+
+func (i *gqlImage) TakenAt(ctx context.Context) *graphql.Time {
+	if i.image.TakenAt == nil || !i.showMetadata(ctx) {
+		return nil
+	}
+	return &graphql.Time{Time: *i.image.TakenAt}
+}
+
+func (i *gqlImage) Variants(ctx context.Context) []*gqlVariant {
+	variants := []*gqlVariant{
+		{name: models.SizeWeb, url: i.image.Path()},
+	}
+	if i.gallery.CanAccessOriginals(llctx.User(ctx)) {
+		variants = append(variants, &gqlVariant{
+			name: models.SizeOriginal,
+			url:  i.image.Path() + "?size=" + models.SizeOriginal,
+		})
+	}
+	return variants
+}
+
+func (i *gqlImage) showMetadata(ctx context.Context) bool {
+	user := llctx.User(ctx)
+	return i.gallery.ShowMetadata || (user != nil && user.ID == i.gallery.UserID)
+}
+
+type gqlVariant struct {
+	name string
+	url  string
+}
+
+func (v *gqlVariant) Name() string { return v.name }
+func (v *gqlVariant) URL() string  { return v.url }
+
+// gqlError turns ErrNotFound into a null result and hides the
+// other errors of the models behind errGraphQLServer.
+func gqlError(ctx context.Context, err error) error {
+	if err == models.ErrNotFound {
+		return nil
+	}
+	llctx.Logger(ctx).Error("request failed", "err", err)
+	return errGraphQLServer
+}
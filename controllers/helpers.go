@@ -1,10 +1,22 @@
 package controllers
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/schema"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/imaging"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
 )
 
 func parseForm(r *http.Request, dst interface{}) error {
@@ -29,3 +41,181 @@ func parseValues(values url.Values, dst interface{}) error {
 	}
 	return nil
 }
+
+// absoluteURL turns the provided path into an absolute URL
+// using the host the request was made to. The scheme set by
+// our proxy in X-Forwarded-Proto is used when present.
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   r.Host,
+		Path:   path,
+	}
+	return u.String()
+}
+
+// newAuditEvent returns an event about the account of the user
+// with the provided ID, made by the current user from the
+// address and browser of the request. While an admin
+// impersonates someone, the admin is the actor.
+func newAuditEvent(r *http.Request, action string, userID uint) *models.AuditEvent {
+	event := models.AuditEvent{
+		UserID:    userID,
+		Action:    action,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if actor := context.Impersonator(r.Context()); actor != nil {
+		event.ActorID = actor.ID
+	} else if actor := context.User(r.Context()); actor != nil {
+		event.ActorID = actor.ID
+	}
+	return &event
+}
+
+// logError logs err with the logger of the request, which
+// carries its ID and the ID of its user, along with the
+// key-value pairs in args.
+func logError(r *http.Request, err error, args ...any) {
+	context.Logger(r.Context()).Error("request failed", append([]any{"err", err}, args...)...)
+}
+
+// recordAudit adds the event to the audit log, logging rather
+// than failing when it cannot, since what it records already
+// happened.
+func recordAudit(r *http.Request, as models.AuditService, event *models.AuditEvent) {
+	if err := as.Record(event); err != nil {
+		logError(r, err)
+	}
+}
+
+// galleryAuditEvent returns an event about the gallery, made by
+// the current user.
+func galleryAuditEvent(r *http.Request, action string, gallery *models.Gallery) *models.AuditEvent {
+	event := newAuditEvent(r, action, gallery.UserID)
+	event.Target = fmt.Sprintf("gallery %v", gallery.ID)
+	event.Detail = gallery.Title
+	return event
+}
+
+// imageAuditEvent returns an event about the image, which
+// belongs to the user with the provided ID, made by the current
+// user.
+func imageAuditEvent(r *http.Request, action string, userID uint, image *models.Image) *models.AuditEvent {
+	event := newAuditEvent(r, action, userID)
+	event.Target = fmt.Sprintf("image %v of gallery %v", image.ID, image.GalleryID)
+	event.Detail = image.Filename
+	return event
+}
+
+// clientIP returns the address the request came from, as found
+// by middleware.ClientIP, or the address of the connection when
+// it did not run.
+func clientIP(r *http.Request) string {
+	if ip := context.ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// excerpt collapses the whitespace in s and shortens it to at
+// most n runes, so it can be used in meta descriptions.
+func excerpt(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// accepts reports whether the Accept header lists the media
+// type with a non-zero quality. Wildcards are ignored, as
+// browsers send "image/*" even for formats they cannot display.
+func accepts(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveImage writes the image to the response with its content
+// type sniffed from its first bytes, as variants may not be in
+// the format the extension of the filename says. Files support
+// range and conditional requests.
+func serveImage(w http.ResponseWriter, r *http.Request, rc io.Reader) {
+	br := bufio.NewReader(rc)
+	head, _ := br.Peek(512)
+	w.Header().Set("Content-Type", imaging.ContentType(head))
+	if f, ok := rc.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			http.ServeContent(w, r, filepath.Base(f.Name()), fi.ModTime(), f)
+			return
+		}
+	}
+	io.Copy(w, br)
+}
+
+// imageExts are the extensions used for the image formats we
+// serve, the first one being the one we name files with.
+var imageExts = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+	"image/avif": {".avif"},
+	"image/heic": {".heic", ".heif"},
+}
+
+// filenameFor changes the extension of filename to match the
+// content type, unless it already does.
+func filenameFor(filename, contentType string) string {
+	exts, ok := imageExts[contentType]
+	if !ok {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return filename
+		}
+	}
+	return strings.TrimSuffix(filename, ext) + exts[0]
+}
+
+// uniqueName returns name, with a numeric suffix before its
+// extension when it was already used, and adds it to used.
+// Names only differing in case clash too, as they would when
+// extracted on most desktops.
+func uniqueName(used map[string]bool, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for n := 2; used[strings.ToLower(unique)]; n++ {
+		unique = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
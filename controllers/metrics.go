@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/metrics"
+)
+
+// NewMetrics returns the controller serving the metrics to the
+// networks in allow, which when empty keeps them from everyone.
+func NewMetrics(allow []*net.IPNet) *Metrics {
+	return &Metrics{
+		allow:   allow,
+		handler: metrics.Handler(),
+	}
+}
+
+// Metrics serves the Prometheus metrics of the app to the
+// scrapers on its allow list.
+type Metrics struct {
+	allow   []*net.IPNet
+	handler http.Handler
+}
+
+// GET /metrics
+//
+// Requests from anywhere else get a 404, so the endpoint is not
+// even known to exist.
+func (m *Metrics) Serve(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(net.ParseIP(clientIP(r))) {
+		http.NotFound(w, r)
+		return
+	}
+	m.handler.ServeHTTP(w, r)
+}
+
+func (m *Metrics) allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range m.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -16,9 +16,10 @@ import (
 	"golang.org/x/oauth2"
 )
 
-func NewAuths(os models.OAuthService, configs map[string]*oauth2.Config) *Oauths {
+func NewAuths(os models.OAuthService, as models.AuditService, configs map[string]*oauth2.Config) *Oauths {
 	return &Oauths{
 		os: os,
+		as: as,
 
 		configs: configs,
 	}
@@ -27,6 +28,7 @@ func NewAuths(os models.OAuthService, configs map[string]*oauth2.Config) *Oauths
 // Oauths Represents a Oauths controller
 type Oauths struct {
 	os      models.OAuthService
+	as      models.AuditService
 	configs map[string]*oauth2.Config
 }
 
@@ -98,6 +100,9 @@ func (o *Oauths) Callback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	event := newAuditEvent(r, models.AuditOAuthConnect, user.ID)
+	event.Target = service
+	recordAudit(r, o.as, event)
 	fmt.Fprintf(w, "%+v", token)
 }
 
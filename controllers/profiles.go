@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+// NewProfiles is used to create the controller rendering the
+// public profiles of users. Every portfolio theme is a layout,
+// so the profile template is parsed once per theme.
+func NewProfiles(us models.UserService, gs models.GalleryService, cs models.CollectionService, is models.ImageService) *Profiles {
+	return &Profiles{
+		ThemeViews: map[string]*views.View{
+			models.ThemeClassic: views.NewView("bootstrap", "users/profile"),
+			models.ThemeDark:    views.NewView("portfolio_dark", "users/profile"),
+			models.ThemeMinimal: views.NewView("portfolio_minimal", "users/profile"),
+		},
+		AccountView: views.NewView("bootstrap", "users/account"),
+		us:          us,
+		gs:          gs,
+		cs:          cs,
+		is:          is,
+	}
+}
+
+// Profiles represents the public profile controller, along
+// with the account page where users edit their profile.
+type Profiles struct {
+	ThemeViews  map[string]*views.View
+	AccountView *views.View
+	us          models.UserService
+	gs          models.GalleryService
+	cs          models.CollectionService
+	is          models.ImageService
+}
+
+// Profile is what the profile template expects as its Yield.
+type Profile struct {
+	Owner       *models.User
+	Galleries   []models.Gallery
+	Collections []models.Collection
+}
+
+// AccountForm is used to update the public profile of the
+// current user.
+type AccountForm struct {
+	Name            string `schema:"name"`
+	Username        string `schema:"username"`
+	Bio             string `schema:"bio"`
+	Theme           string `schema:"theme"`
+	DuplicatePolicy string `schema:"duplicate_policy"`
+}
+
+// Show renders the public profile of a user with the galleries
+// and collections they chose to list.
+//
+// GET /u/:username
+func (p *Profiles) Show(w http.ResponseWriter, r *http.Request) {
+	owner, err := p.us.ByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !owner.PublicTo(context.User(r.Context())) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	profile, err := p.profile(owner)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = profile
+	vd.Meta = &views.Meta{
+		Title:       owner.Username,
+		Description: excerpt(owner.Bio, 200),
+		URL:         absoluteURL(r, r.URL.Path),
+	}
+	if owner.Name != "" {
+		vd.Meta.Title = owner.Name
+	}
+	for _, gallery := range profile.Galleries {
+		if cover := gallery.Cover(); cover != nil {
+			vd.Meta.Image = absoluteURL(r, cover.Path())
+			break
+		}
+	}
+
+	view, ok := p.ThemeViews[owner.Theme]
+	if !ok {
+		view = p.ThemeViews[models.ThemeClassic]
+	}
+	view.Render(w, r, vd)
+}
+
+// GET /account
+func (p *Profiles) Account(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	form := AccountForm{
+		Name:            user.Name,
+		Username:        user.Username,
+		Bio:             user.Bio,
+		Theme:           user.Theme,
+		DuplicatePolicy: user.DuplicatePolicy,
+	}
+	p.AccountView.Render(w, r, &form)
+}
+
+// POST /account
+func (p *Profiles) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form AccountForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		p.AccountView.Render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	user.Name = form.Name
+	user.Username = form.Username
+	user.Bio = form.Bio
+	user.Theme = form.Theme
+	user.DuplicatePolicy = form.DuplicatePolicy
+	if err := p.us.UpdateProfile(user); err != nil {
+		vd.SetAlert(err)
+		p.AccountView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your profile was updated!",
+	})
+}
+
+// profile loads the listed galleries, with their images, and
+// the listed collections of the owner.
+func (p *Profiles) profile(owner *models.User) (*Profile, error) {
+	profile := Profile{
+		Owner: owner,
+	}
+	galleries, err := p.gs.ByUserID(owner.ID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, gallery := range galleries {
+		if gallery.Listed() {
+			profile.Galleries = append(profile.Galleries, gallery)
+			ids = append(ids, gallery.ID)
+		}
+	}
+	byGallery, err := p.is.ByGalleryIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range profile.Galleries {
+		profile.Galleries[i].Images = byGallery[profile.Galleries[i].ID]
+	}
+
+	collections, err := p.cs.ByUserID(owner.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range collections {
+		if collection.Listed() {
+			profile.Collections = append(profile.Collections, collection)
+		}
+	}
+	return &profile, nil
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+func NewReports(rs models.ReportService, gs models.GalleryService) *Reports {
+	return &Reports{
+		NewView: views.NewView("bootstrap", "reports/new"),
+		rs:      rs,
+		gs:      gs,
+	}
+}
+
+// Reports lets visitors flag galleries for the admins to
+// review in the moderation queue.
+type Reports struct {
+	NewView *views.View
+	rs      models.ReportService
+	gs      models.GalleryService
+}
+
+// ReportForm is used to report a gallery.
+type ReportForm struct {
+	Reason  string `schema:"reason"`
+	Comment string `schema:"comment"`
+}
+
+// reportData is what the report template expects as its Yield.
+type reportData struct {
+	Gallery *models.Gallery
+	Reasons []models.ReportReason
+	Form    ReportForm
+}
+
+// GET /galleries/:id/report
+func (rc *Reports) New(w http.ResponseWriter, r *http.Request) {
+	gallery, err := rc.gallery(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = &reportData{Gallery: gallery, Reasons: models.ReportReasons}
+	rc.NewView.Render(w, r, vd)
+}
+
+// POST /galleries/:id/report
+func (rc *Reports) Create(w http.ResponseWriter, r *http.Request) {
+	gallery, err := rc.gallery(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	data := reportData{Gallery: gallery, Reasons: models.ReportReasons}
+	vd.Yield = &data
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		rc.NewView.Render(w, r, vd)
+		return
+	}
+	report := models.Report{
+		GalleryID: gallery.ID,
+		IP:        clientIP(r),
+		Reason:    data.Form.Reason,
+		Comment:   data.Form.Comment,
+	}
+	if user := context.User(r.Context()); user != nil {
+		report.ReporterID = user.ID
+	}
+	if err := rc.rs.Create(&report); err != nil {
+		vd.SetAlert(err)
+		rc.NewView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v", gallery.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Thanks for letting us know. We will look into it.",
+	})
+}
+
+// gallery returns the gallery with the ID in the URL, when the
+// current user can see it and is not its owner.
+func (rc *Reports) gallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := rc.gs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	user := context.User(r.Context())
+	if !gallery.CanView(user) || (user != nil && user.ID == gallery.UserID) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, models.ErrNotFound
+	}
+	return gallery, nil
+}
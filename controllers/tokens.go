@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+func NewTokens(ats models.APITokenService) *Tokens {
+	return &Tokens{
+		IndexView: views.NewView("bootstrap", "users/tokens"),
+		ats:       ats,
+	}
+}
+
+// Tokens lets users create and revoke the personal access
+// tokens they use with the API.
+type Tokens struct {
+	IndexView *views.View
+	ats       models.APITokenService
+}
+
+// TokenForm is used to create an API token.
+type TokenForm struct {
+	Name  string `schema:"name"`
+	Scope string `schema:"scope"`
+}
+
+// tokensData is what the tokens template expects as its Yield.
+// Created is only set right after a token is created, as that is
+// the only time its value can be shown.
+type tokensData struct {
+	Form    TokenForm
+	Tokens  []models.APIToken
+	Created *models.APIToken
+}
+
+// GET /account/tokens
+func (t *Tokens) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	data := tokensData{Form: TokenForm{Scope: models.ScopeRead}}
+	if err := t.list(r, &data); err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = &data
+	t.IndexView.Render(w, r, vd)
+}
+
+// Create renders the token list rather than redirecting to it,
+// so the new token is shown without being kept anywhere.
+//
+// POST /account/tokens
+func (t *Tokens) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var data tokensData
+	vd.Yield = &data
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd, &data)
+		return
+	}
+	token := models.APIToken{
+		UserID: context.User(r.Context()).ID,
+		Name:   data.Form.Name,
+		Scope:  data.Form.Scope,
+	}
+	if err := t.ats.Create(&token); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd, &data)
+		return
+	}
+	data.Created = &token
+	w.Header().Set("Cache-Control", "no-store")
+	data.Form = TokenForm{Scope: models.ScopeRead}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your token was created. Copy it now, as it will not be shown again.",
+	}
+	t.render(w, r, vd, &data)
+}
+
+// POST /account/tokens/:id/delete
+func (t *Tokens) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	token, err := t.ats.ByID(uint(id))
+	if err == nil && token.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if err := t.ats.Delete(token.ID); err != nil {
+		logError(r, err)
+		views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: token.Name + " was revoked.",
+	})
+}
+
+// render renders the token list along with the alert of vd,
+// falling back to a generic error if the tokens cannot be
+// looked up.
+func (t *Tokens) render(w http.ResponseWriter, r *http.Request, vd views.Data, data *tokensData) {
+	if err := t.list(r, data); err != nil {
+		logError(r, err)
+		vd.AlertError(views.AlertMsgGeneric)
+	}
+	t.IndexView.Render(w, r, vd)
+}
+
+func (t *Tokens) list(r *http.Request, data *tokensData) error {
+	tokens, err := t.ats.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		return err
+	}
+	data.Tokens = tokens
+	return nil
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+func NewTrash(ts models.TrashService, gs models.GalleryService, as models.AuditService) *Trash {
+	return &Trash{
+		IndexView: views.NewView("bootstrap", "trash/index"),
+		ts:        ts,
+		gs:        gs,
+		as:        as,
+	}
+}
+
+// Trash lets users restore the galleries and images they
+// deleted, or delete them for good, before they are purged.
+type Trash struct {
+	IndexView *views.View
+	ts        models.TrashService
+	gs        models.GalleryService
+	as        models.AuditService
+}
+
+// trashData is what the trash template expects as its Yield.
+// Galleries holds the galleries the deleted images are from.
+type trashData struct {
+	Trash         *models.Trash
+	Galleries     map[uint]*models.Gallery
+	RetentionDays int
+}
+
+// GET /trash
+func (t *Trash) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	trash, err := t.ts.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	galleries, err := t.gs.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data := trashData{
+		Trash:         trash,
+		Galleries:     make(map[uint]*models.Gallery),
+		RetentionDays: int(models.TrashRetention.Hours() / 24),
+	}
+	for i := range galleries {
+		data.Galleries[galleries[i].ID] = &galleries[i]
+	}
+	var vd views.Data
+	vd.Yield = &data
+	t.IndexView.Render(w, r, vd)
+}
+
+// POST /trash/galleries/:id/restore
+func (t *Trash) RestoreGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.deletedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.RestoreGallery(gallery); err != nil {
+		t.redirectError(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v/edit", gallery.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " was restored.",
+	})
+}
+
+// POST /trash/galleries/:id/delete
+func (t *Trash) PurgeGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.deletedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.PurgeGallery(gallery); err != nil {
+		t.redirectError(w, r, err)
+		return
+	}
+	recordAudit(r, t.as, galleryAuditEvent(r, models.AuditGalleryPurge, gallery))
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: gallery.Title + " was deleted for good.",
+	})
+}
+
+// ImageFile serves the file of a deleted image, so its owner
+// can tell which one it is.
+//
+// GET /trash/images/:id
+func (t *Trash) ImageFile(w http.ResponseWriter, r *http.Request) {
+	image, _, err := t.deletedImage(w, r)
+	if err != nil {
+		return
+	}
+	rc, err := t.ts.OpenImage(image)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Cache-Control", "private")
+	serveImage(w, r, rc)
+}
+
+// POST /trash/images/:id/restore
+func (t *Trash) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	image, gallery, err := t.deletedImage(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.RestoreImage(image); err != nil {
+		t.redirectError(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/galleries/%v/edit", gallery.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: image.Filename + " was restored to " + gallery.Title + ".",
+	})
+}
+
+// POST /trash/images/:id/delete
+func (t *Trash) PurgeImage(w http.ResponseWriter, r *http.Request) {
+	image, gallery, err := t.deletedImage(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.PurgeImage(image); err != nil {
+		t.redirectError(w, r, err)
+		return
+	}
+	recordAudit(r, t.as, imageAuditEvent(r, models.AuditImagePurge, gallery.UserID, image))
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: image.Filename + " was deleted for good.",
+	})
+}
+
+// POST /trash/empty
+func (t *Trash) Empty(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := t.ts.Empty(user.ID); err != nil {
+		t.redirectError(w, r, err)
+		return
+	}
+	recordAudit(r, t.as, newAuditEvent(r, models.AuditTrashEmpty, user.ID))
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your trash was emptied.",
+	})
+}
+
+func (t *Trash) redirectError(w http.ResponseWriter, r *http.Request, err error) {
+	logError(r, err)
+	views.RedirectAlert(w, r, "/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlError,
+		Message: views.AlertMsgGeneric,
+	})
+}
+
+// deletedGallery returns the deleted gallery with the ID in the
+// URL when it belongs to the current user.
+func (t *Trash) deletedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := t.ts.GalleryByID(uint(id))
+	if err == nil && gallery.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		t.notFoundOrError(w, r, "Gallery not found", err)
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// deletedImage returns the deleted image with the ID in the URL,
+// and the gallery it is from, when it belongs to the current
+// user.
+func (t *Trash) deletedImage(w http.ResponseWriter, r *http.Request) (*models.Image, *models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil, nil, err
+	}
+	image, err := t.ts.ImageByID(uint(id))
+	if err != nil {
+		t.notFoundOrError(w, r, "Image not found", err)
+		return nil, nil, err
+	}
+	gallery, err := t.gs.ByID(image.GalleryID)
+	if err == nil && gallery.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		t.notFoundOrError(w, r, "Image not found", err)
+		return nil, nil, err
+	}
+	return image, gallery, nil
+}
+
+func (t *Trash) notFoundOrError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	if err == models.ErrNotFound {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	logError(r, err)
+	http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+}
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+const (
+	ShowUpload = "show_upload"
+
+	// tusVersion is the version of the tus resumable upload
+	// protocol we implement, along with its creation,
+	// expiration and termination extensions.
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	// tusContentType is the content type of PATCH requests.
+	tusContentType = "application/offset+octet-stream"
+)
+
+func NewUploads(ups models.UploadService, gs models.GalleryService, is models.ImageService, router *mux.Router) *Uploads {
+	return &Uploads{
+		ups:    ups,
+		gs:     gs,
+		is:     is,
+		router: router,
+	}
+}
+
+// Uploads implements resumable uploads of images to a gallery
+// following the tus protocol (https://tus.io), so a large file
+// sent over a flaky connection can be resumed where it stopped
+// instead of being sent again as a whole.
+type Uploads struct {
+	ups    models.UploadService
+	gs     models.GalleryService
+	is     models.ImageService
+	router *mux.Router
+}
+
+// Options describes what the server supports.
+//
+// OPTIONS /galleries/:id/uploads
+func (u *Uploads) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(models.MaxUploadLength, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create starts a new upload. The client provides the size of
+// the file in Upload-Length and its name in Upload-Metadata.
+//
+// POST /galleries/:id/uploads
+func (u *Uploads) Create(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	gallery, err := u.ownedGallery(w, r)
+	if err != nil {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Length must be the size of the file in bytes", http.StatusBadRequest)
+		return
+	}
+	if length > models.MaxUploadLength {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !context.User(r.Context()).CanStore(length) {
+		uploadError(w, r, models.ErrQuotaExceeded)
+		return
+	}
+	upload := models.Upload{
+		UserID:    gallery.UserID,
+		GalleryID: gallery.ID,
+		Filename:  parseUploadMetadata(r.Header.Get("Upload-Metadata"))["filename"],
+		Length:    length,
+	}
+	if err := u.ups.Create(&upload); err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	url, err := u.router.Get(ShowUpload).URL(
+		"id", fmt.Sprintf("%v", gallery.ID),
+		"token", upload.Token)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", url.Path)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head tells the client how many bytes were received, which is
+// where it should resume from.
+//
+// HEAD /galleries/:id/uploads/:token
+func (u *Uploads) Head(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	upload, err := u.ownedUpload(w, r)
+	if err != nil {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	u.writeOffset(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch appends a chunk to the upload. Once every byte of the
+// file was received it is added to the gallery and the upload
+// is removed.
+//
+// PATCH /galleries/:id/uploads/:token
+func (u *Uploads) Patch(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset must be a number of bytes", http.StatusBadRequest)
+		return
+	}
+	upload, err := u.ownedUpload(w, r)
+	if err != nil {
+		return
+	}
+	if err := u.ups.Write(upload, offset, r.Body); err != nil {
+		switch err {
+		case models.ErrUploadOffsetMismatch:
+			http.Error(w, "Upload-Offset does not match the bytes received", http.StatusConflict)
+		case models.ErrNotFound:
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		default:
+			// The client went away or the connection broke; what
+			// was received is kept and the client can resume.
+			logError(r, err)
+			http.Error(w, "Upload interrupted", http.StatusInternalServerError)
+		}
+		return
+	}
+	if upload.Complete() {
+		if err := u.finish(upload); err != nil {
+			uploadError(w, r, err)
+			return
+		}
+	}
+	u.writeOffset(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete abandons the upload.
+//
+// DELETE /galleries/:id/uploads/:token
+func (u *Uploads) Delete(w http.ResponseWriter, r *http.Request) {
+	if !u.checkVersion(w, r) {
+		return
+	}
+	upload, err := u.ownedUpload(w, r)
+	if err != nil {
+		return
+	}
+	if err := u.ups.Remove(upload); err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish adds the received file to the gallery. The upload is
+// kept when that fails, so a later PATCH can try again. Files
+// identical to an image the user already has are not an error,
+// whether they were added or skipped, as the protocol has no
+// way to tell the client about it.
+func (u *Uploads) finish(upload *models.Upload) error {
+	f, err := u.ups.Open(upload)
+	if err != nil {
+		return err
+	}
+	err = u.is.Create(upload.GalleryID, f, upload.Filename)
+	if _, ok := err.(*models.DuplicateError); !ok && err != nil {
+		return err
+	}
+	return u.ups.Remove(upload)
+}
+
+func (u *Uploads) writeOffset(w http.ResponseWriter, upload *models.Upload) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// checkVersion responds with a 412 when the client speaks a
+// version of the protocol we do not support.
+func (u *Uploads) checkVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+	w.Header().Set("Tus-Version", tusVersion)
+	http.Error(w, "Tus-Resumable must be "+tusVersion, http.StatusPreconditionFailed)
+	return false
+}
+
+// ownedGallery looks up the gallery in the URL, responding with
+// a 404 unless it belongs to the current user.
+func (u *Uploads) ownedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := u.gs.ByID(uint(id))
+	if err == nil && gallery.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// ownedUpload looks up the upload in the URL, responding with a
+// 404 unless it was started by the current user for the gallery
+// in the URL.
+func (u *Uploads) ownedUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, error) {
+	vars := mux.Vars(r)
+	upload, err := u.ups.ByToken(vars["token"])
+	if err == nil {
+		user := context.User(r.Context())
+		if upload.UserID != user.ID || fmt.Sprintf("%v", upload.GalleryID) != vars["id"] {
+			err = models.ErrNotFound
+		}
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		default:
+			logError(r, err)
+			http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+func uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == models.ErrQuotaExceeded {
+		http.Error(w, models.ErrQuotaExceeded.Public(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if pErr, ok := err.(views.PublicError); ok {
+		http.Error(w, pErr.Public(), http.StatusBadRequest)
+		return
+	}
+	logError(r, err)
+	http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, a
+// comma separated list of keys and base64 encoded values.
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			var err error
+			value, err = base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return metadata
+}
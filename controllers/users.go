@@ -13,17 +13,23 @@ import (
 	"github.com/samueldaviddelacruz/lenslocked.com/views"
 )
 
+// activityLimit is how many events the activity page of an
+// account lists.
+const activityLimit = 100
+
 // NewUsers is used to create a new Users controller.
 // This function will panic if the templates are not
 // parsed correctly, and should only be used during
 // initial setup
-func NewUsers(us models.UserService, emailer *email.Client) *Users {
+func NewUsers(us models.UserService, as models.AuditService, emailer *email.Client) *Users {
 	return &Users{
 		LoginView:    views.NewView("bootstrap", "users/login"),
 		NewView:      views.NewView("bootstrap", "users/new"),
 		ForgotPwView: views.NewView("bootstrap", "users/forgot_pw"),
 		ResetPwView:  views.NewView("bootstrap", "users/reset_pw"),
+		ActivityView: views.NewView("bootstrap", "users/activity"),
 		us:           us,
+		as:           as,
 		emailer:      emailer,
 	}
 }
@@ -34,7 +40,9 @@ type Users struct {
 	LoginView    *views.View
 	ForgotPwView *views.View
 	ResetPwView  *views.View
+	ActivityView *views.View
 	us           models.UserService
+	as           models.AuditService
 	emailer      *email.Client
 }
 
@@ -50,6 +58,7 @@ func (u *Users) New(w http.ResponseWriter, r *http.Request) {
 
 type SignupForm struct {
 	Name     string `schema:"name"`
+	Username string `schema:"username"`
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
 }
@@ -69,6 +78,7 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	user := models.User{
 		Name:     form.Name,
+		Username: form.Username,
 		Email:    form.Email,
 		Password: form.Password,
 	}
@@ -79,6 +89,7 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	u.record(r, models.AuditSignup, &user)
 	err := u.signIn(w, &user)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
@@ -113,6 +124,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 
 	user, err := u.us.Authenticate(form.Email, form.Password)
 	if err != nil {
+		u.recordFailedLogin(r, form.Email, err)
 		switch err {
 		case models.ErrNotFound:
 			vd.AlertError("Invalid email address")
@@ -128,6 +140,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		u.LoginView.Render(w, r, vd)
 		return
 	}
+	u.record(r, models.AuditLogin, user)
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
@@ -144,10 +157,16 @@ func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 	http.SetCookie(w, &cookie)
 
+	// An admin impersonating someone is the one logging out.
 	user := context.User(r.Context())
+	if admin := context.Impersonator(r.Context()); admin != nil {
+		clearImpersonation(w)
+		user = admin
+	}
 	token, _ := rand.RememberToken()
 	user.Remember = token
 	u.us.Update(user)
+	u.record(r, models.AuditLogout, user)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
@@ -182,6 +201,9 @@ func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 		u.ForgotPwView.Render(w, r, vd)
 		return
 	}
+	if user, err := u.us.ByEmail(form.Email); err == nil {
+		u.record(r, models.AuditPasswordResetRequest, user)
+	}
 
 	views.RedirectAlert(w, r, "/reset", http.StatusFound, views.Alert{
 		Level:   views.AlertLvlSuccess,
@@ -222,6 +244,7 @@ func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 		u.ResetPwView.Render(w, r, vd)
 		return
 	}
+	u.record(r, models.AuditPasswordReset, user)
 
 	err = u.signIn(w, user)
 	if err != nil {
@@ -235,6 +258,56 @@ func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Activity lists the recent events of the audit log about the
+// account of the current user, such as logins and deletions.
+//
+// GET /account/activity
+func (u *Users) Activity(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	events, err := u.as.ByUserID(user.ID, activityLimit)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ActivityView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = &activityData{UserID: user.ID, Events: events}
+	u.ActivityView.Render(w, r, vd)
+}
+
+// activityData is what the activity template expects as its
+// Yield. UserID tells the events of the user apart from those
+// of an admin.
+type activityData struct {
+	UserID uint
+	Events []models.AuditEvent
+}
+
+// record adds an event about the account of the user to the
+// audit log. The user is the actor, since the events recorded
+// here happen while they are not signed in yet, or no longer.
+func (u *Users) record(r *http.Request, action string, user *models.User) {
+	event := newAuditEvent(r, action, user.ID)
+	if event.ActorID == 0 {
+		event.ActorID = user.ID
+	}
+	recordAudit(r, u.as, event)
+}
+
+// recordFailedLogin records an attempt to log in to an existing
+// account with the wrong password, or to a disabled one. Unknown
+// email addresses have no account to record it against.
+func (u *Users) recordFailedLogin(r *http.Request, email string, err error) {
+	if err != models.ErrPasswordIncorrect && err != models.ErrAccountDisabled {
+		return
+	}
+	user, err := u.us.ByEmail(email)
+	if err != nil {
+		return
+	}
+	recordAudit(r, u.as, newAuditEvent(r, models.AuditLoginFailed, user.ID))
+}
+
 // signIn is used to sign the given user via cookies.
 func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
 	if user.Remember == "" {
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+// maxLogoBytes is the largest logo that can be uploaded.
+const maxLogoBytes = 5 << 20 // 5 megabytes
+
+func NewWatermarks(ws models.WatermarkService, gs models.GalleryService, is models.ImageService) *Watermarks {
+	return &Watermarks{
+		EditView: views.NewView("bootstrap", "users/watermark"),
+		ws:       ws,
+		gs:       gs,
+		is:       is,
+	}
+}
+
+// Watermarks lets users choose the mark drawn over the images
+// of their galleries.
+type Watermarks struct {
+	EditView *views.View
+	ws       models.WatermarkService
+	gs       models.GalleryService
+	is       models.ImageService
+}
+
+// WatermarkForm is used to update the watermark settings. A new
+// logo may be uploaded along with them as the "logo" file.
+type WatermarkForm struct {
+	Kind     string `schema:"kind"`
+	Text     string `schema:"text"`
+	Position string `schema:"position"`
+	Opacity  int    `schema:"opacity"`
+	Scale    int    `schema:"scale"`
+}
+
+// GET /account/watermark
+func (wc *Watermarks) Edit(w http.ResponseWriter, r *http.Request) {
+	wm, err := wc.ws.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = wm
+	wc.EditView.Render(w, r, vd)
+}
+
+// Update saves the watermark settings and removes the web size
+// of the images in every gallery of the user, so they are
+// generated again with the new watermark.
+//
+// POST /account/watermark
+func (wc *Watermarks) Update(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	wm, err := wc.ws.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = wm
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoBytes+maxMultiPartMem)
+	if err := r.ParseMultipartForm(maxMultiPartMem); err != nil {
+		vd.AlertError("Your logo must be smaller than 5MB.")
+		wc.EditView.Render(w, r, vd)
+		return
+	}
+	var form WatermarkForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		wc.EditView.Render(w, r, vd)
+		return
+	}
+	wm.Kind = form.Kind
+	wm.Text = form.Text
+	wm.Position = form.Position
+	wm.Opacity = form.Opacity
+	wm.Scale = form.Scale
+	if err := wc.setLogo(r, wm); err != nil {
+		vd.SetAlert(err)
+		wc.EditView.Render(w, r, vd)
+		return
+	}
+	if err := wc.ws.Save(wm); err != nil {
+		vd.SetAlert(err)
+		wc.EditView.Render(w, r, vd)
+		return
+	}
+
+	galleries, err := wc.gs.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+	}
+	for _, gallery := range galleries {
+		if err := wc.is.RemoveWebVariants(gallery.ID); err != nil {
+			logError(r, err)
+		}
+	}
+	views.RedirectAlert(w, r, "/account/watermark", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your watermark was updated! It may take a moment to show on your images.",
+	})
+}
+
+// setLogo stores the uploaded logo, if any.
+func (wc *Watermarks) setLogo(r *http.Request, wm *models.Watermark) error {
+	file, _, err := r.FormFile("logo")
+	if err == http.ErrMissingFile {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return wc.ws.SetLogo(wm, file)
+}
+
+// Logo shows the uploaded logo of the current user, so they can
+// see which one is used.
+//
+// GET /account/watermark/logo
+func (wc *Watermarks) Logo(w http.ResponseWriter, r *http.Request) {
+	wm, err := wc.ws.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	rc, err := wc.ws.OpenLogo(wm)
+	if err != nil {
+		http.Error(w, "Logo not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	io.Copy(w, rc)
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/views"
+)
+
+// webhookLogSize is how many deliveries the page of a webhook
+// lists.
+const webhookLogSize = 50
+
+func NewWebhooks(ws models.WebhookService) *Webhooks {
+	return &Webhooks{
+		IndexView: views.NewView("bootstrap", "webhooks/index"),
+		EditView:  views.NewView("bootstrap", "webhooks/edit"),
+		ws:        ws,
+	}
+}
+
+// Webhooks lets users manage the endpoints their events are
+// posted to, and see how the deliveries went.
+type Webhooks struct {
+	IndexView *views.View
+	EditView  *views.View
+	ws        models.WebhookService
+}
+
+// WebhookForm is used to create and update a webhook.
+type WebhookForm struct {
+	URL      string   `schema:"url"`
+	Events   []string `schema:"events"`
+	Disabled bool     `schema:"disabled"`
+}
+
+// Has reports whether the event is checked.
+func (f *WebhookForm) Has(event string) bool {
+	for _, e := range f.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhooksData is what the webhooks index template expects as
+// its Yield.
+type webhooksData struct {
+	Hooks  []models.Webhook
+	Form   WebhookForm
+	Events []string
+}
+
+// webhookData is what the webhook edit template expects as its
+// Yield.
+type webhookData struct {
+	Hook       *models.Webhook
+	Form       WebhookForm
+	Events     []string
+	Deliveries []models.WebhookDelivery
+}
+
+// GET /account/webhooks
+func (wh *Webhooks) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	data := webhooksData{Events: models.WebhookEvents}
+	hooks, err := wh.ws.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data.Hooks = hooks
+	vd.Yield = &data
+	wh.IndexView.Render(w, r, vd)
+}
+
+// POST /account/webhooks
+func (wh *Webhooks) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	data := webhooksData{Events: models.WebhookEvents}
+	vd.Yield = &data
+	hooks, err := wh.ws.ByUserID(user.ID)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data.Hooks = hooks
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		wh.IndexView.Render(w, r, vd)
+		return
+	}
+	hook := models.Webhook{
+		UserID: user.ID,
+	}
+	data.Form.apply(&hook)
+	if err := wh.ws.Create(&hook); err != nil {
+		vd.SetAlert(err)
+		wh.IndexView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/account/webhooks/%v", hook.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your webhook was created. Use its secret to check the signature of the events it receives.",
+	})
+}
+
+// GET /account/webhooks/:id
+func (wh *Webhooks) Edit(w http.ResponseWriter, r *http.Request) {
+	hook, err := wh.ownedWebhook(w, r)
+	if err != nil {
+		return
+	}
+	data, err := wh.editData(hook)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = data
+	wh.EditView.Render(w, r, vd)
+}
+
+// POST /account/webhooks/:id/update
+func (wh *Webhooks) Update(w http.ResponseWriter, r *http.Request) {
+	hook, err := wh.ownedWebhook(w, r)
+	if err != nil {
+		return
+	}
+	data, err := wh.editData(hook)
+	if err != nil {
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = data
+	data.Form = WebhookForm{}
+	if err := parseForm(r, &data.Form); err != nil {
+		vd.SetAlert(err)
+		wh.EditView.Render(w, r, vd)
+		return
+	}
+	data.Form.apply(hook)
+	if err := wh.ws.Update(hook); err != nil {
+		vd.SetAlert(err)
+		wh.EditView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, fmt.Sprintf("/account/webhooks/%v", hook.ID), http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Webhook successfully updated!",
+	})
+}
+
+// Test sends a ping to the webhook and reports how it went.
+//
+// POST /account/webhooks/:id/test
+func (wh *Webhooks) Test(w http.ResponseWriter, r *http.Request) {
+	hook, err := wh.ownedWebhook(w, r)
+	if err != nil {
+		return
+	}
+	path := fmt.Sprintf("/account/webhooks/%v", hook.ID)
+	delivery, err := wh.ws.Test(hook)
+	if err != nil {
+		logError(r, err)
+		views.RedirectAlert(w, r, path, http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("The ping was delivered, and your endpoint responded with %d.", delivery.StatusCode),
+	}
+	if delivery.Status != models.DeliveryDelivered {
+		alert.Level = views.AlertLvlError
+		alert.Message = "The ping could not be delivered. See the log below for why."
+	}
+	views.RedirectAlert(w, r, path, http.StatusFound, alert)
+}
+
+// POST /account/webhooks/:id/delete
+func (wh *Webhooks) Delete(w http.ResponseWriter, r *http.Request) {
+	hook, err := wh.ownedWebhook(w, r)
+	if err != nil {
+		return
+	}
+	if err := wh.ws.Delete(hook.ID); err != nil {
+		logError(r, err)
+		views.RedirectAlert(w, r, fmt.Sprintf("/account/webhooks/%v", hook.ID), http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, "/account/webhooks", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The webhook for " + hook.URL + " was deleted.",
+	})
+}
+
+func (f *WebhookForm) apply(hook *models.Webhook) {
+	hook.URL = f.URL
+	hook.Events = strings.Join(f.Events, " ")
+	hook.Disabled = f.Disabled
+}
+
+func (wh *Webhooks) editData(hook *models.Webhook) (*webhookData, error) {
+	deliveries, err := wh.ws.Deliveries(hook.ID, webhookLogSize)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookData{
+		Hook: hook,
+		Form: WebhookForm{
+			URL:      hook.URL,
+			Events:   hook.EventList(),
+			Disabled: hook.Disabled,
+		},
+		Events:     models.WebhookEvents,
+		Deliveries: deliveries,
+	}, nil
+}
+
+// ownedWebhook returns the webhook with the ID in the URL when
+// it belongs to the current user.
+func (wh *Webhooks) ownedWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, err
+	}
+	hook, err := wh.ws.ByID(uint(id))
+	if err == nil && hook.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return nil, err
+		}
+		logError(r, err)
+		http.Error(w, "Oops, something went wrong.", http.StatusInternalServerError)
+		return nil, err
+	}
+	return hook, nil
+}
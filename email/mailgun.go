@@ -3,16 +3,22 @@ package email
 import (
 	"context"
 	"fmt"
+	"html"
 	"net/url"
 	"time"
 
 	"github.com/mailgun/mailgun-go/v3"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/metrics"
 )
 
 const (
 	welcomeSubject = "Welcome to Lenslocked Project Demo"
 	resetSubject   = "Instructions for resetting your password."
 	resetBaseURL   = "https://lenslocked-project-demo.net/reset"
+
+	hiddenSubject  = "Your gallery is hidden while we review it"
+	galleryURLTmpl = "https://lenslocked-project-demo.net/galleries/%d/edit"
 )
 const welcomeText = `
 Hi there!
@@ -73,6 +79,48 @@ const resetHTMLTmpl = `
 	Lenslocked Support<br/>
 `
 
+const hiddenTextTmpl = `
+	Hi there!
+
+	Your gallery "%s" was reported, and is hidden from everyone but
+	you while we review it:
+
+	%s
+
+	The reason given was:
+
+	%s
+
+	If you think this is a mistake, reply to this email and let us
+	know.
+
+	Best,
+
+	Lenslocked Support
+`
+
+const hiddenHTMLTmpl = `
+	Hi there!<br/>
+	<br/>
+	Your gallery "%s" was reported, and is hidden from everyone but
+	you while we review it:
+	<br/>
+	<a href="%s">%s</a>
+	<br/>
+	<br/>
+	The reason given was:
+	<br/>
+	%s
+	<br/>
+	<br/>
+	If you think this is a mistake, reply to this email and let us
+	know.
+	<br/>
+	Best,<br/>
+
+	Lenslocked Support<br/>
+`
+
 type ClientConfig func(*Client)
 
 func WithMailgun(domain, apiKey string) ClientConfig {
@@ -107,11 +155,7 @@ type Client struct {
 func (c *Client) Welcome(toName, toEmail string) error {
 	message := c.mg.NewMessage(c.from, welcomeSubject, welcomeText, buildEmail(toName, toEmail))
 	message.SetHtml(welcomeHTML)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-	_, _, err := c.mg.Send(ctx, message)
-
-	return err
+	return c.send("welcome", message)
 }
 
 func (c *Client) ResetPw(toEmail, token string) error {
@@ -123,10 +167,29 @@ func (c *Client) ResetPw(toEmail, token string) error {
 	resetHTML := fmt.Sprintf(resetHTMLTmpl, resetURL, resetURL, token)
 
 	message.SetHtml(resetHTML)
+	return c.send("reset_password", message)
+}
+
+// GalleryHidden tells the owner of a gallery that an admin hid
+// it pending review, and why.
+func (c *Client) GalleryHidden(toName, toEmail string, galleryID uint, title, reason string) error {
+	galleryURL := fmt.Sprintf(galleryURLTmpl, galleryID)
+	hiddenText := fmt.Sprintf(hiddenTextTmpl, title, galleryURL, reason)
+	message := c.mg.NewMessage(c.from, hiddenSubject, hiddenText, buildEmail(toName, toEmail))
+	hiddenHTML := fmt.Sprintf(hiddenHTMLTmpl,
+		html.EscapeString(title), galleryURL, galleryURL, html.EscapeString(reason))
+
+	message.SetHtml(hiddenHTML)
+	return c.send("gallery_hidden", message)
+}
+
+// send sends the message, recording whether it went out among
+// the emails of its kind.
+func (c *Client) send(kind string, message *mailgun.Message) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 	_, _, err := c.mg.Send(ctx, message)
-
+	metrics.ObserveEmail(kind, err)
 	return err
 }
 
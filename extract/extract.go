@@ -0,0 +1,346 @@
+// Package extract reads the images out of ZIP and tar archives
+// uploaded by users, guarding against archives crafted to
+// exhaust the server (zip bombs) or to write outside of the
+// gallery (path traversal).
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/imaging"
+)
+
+var (
+	// ErrTooManyEntries is returned when an archive holds more
+	// entries than Limits.MaxEntries.
+	ErrTooManyEntries = errors.New("extract: archive has too many entries")
+	// ErrTooLarge is returned when the uncompressed contents of
+	// an archive add up to more than Limits.MaxTotalSize.
+	ErrTooLarge = errors.New("extract: archive is too large once uncompressed")
+)
+
+// Limits bounds how much work extracting a single archive may
+// cause. Sizes are checked against the bytes actually read, not
+// the sizes the archive claims.
+type Limits struct {
+	MaxEntries   int
+	MaxTotalSize int64
+	MaxEntrySize int64
+}
+
+// DefaultLimits are the limits used for archives uploaded to a
+// gallery.
+var DefaultLimits = Limits{
+	MaxEntries:   2000,
+	MaxTotalSize: 4 << 30,   // 4 gigabytes
+	MaxEntrySize: 200 << 20, // 200 megabytes
+}
+
+// Skipped describes an archive entry that was not imported.
+type Skipped struct {
+	Name   string
+	Reason string
+}
+
+// Result lists what happened to the entries of an archive.
+type Result struct {
+	Imported []string
+	Skipped  []Skipped
+}
+
+// ImportFunc is called with the base name and the contents of
+// every image found in an archive. Returning an error stops the
+// extraction.
+type ImportFunc func(filename string, r io.Reader) error
+
+// IsArchive reports whether the filename looks like one of the
+// archive formats we can extract.
+func IsArchive(filename string) bool {
+	return isZip(filename) || isTar(filename)
+}
+
+func isZip(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".zip")
+}
+
+func isTar(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".tar") ||
+		strings.HasSuffix(name, ".tar.gz") ||
+		strings.HasSuffix(name, ".tgz")
+}
+
+// File extracts the archive named filename, choosing the format
+// from its extension. ZIP archives need random access, which is
+// why r is an io.ReaderAt of the provided size. Tar archives are
+// read twice, so an archive with too many entries is rejected
+// before anything is imported.
+func File(filename string, r io.ReaderAt, size int64, limits Limits, fn ImportFunc) (*Result, error) {
+	if isZip(filename) {
+		return Zip(r, size, limits, fn)
+	}
+	gzipped := strings.HasSuffix(strings.ToLower(filename), "gz")
+	open := func() (io.ReadCloser, error) {
+		sr := io.NewSectionReader(r, 0, size)
+		if gzipped {
+			return gzip.NewReader(sr)
+		}
+		return ioutil.NopCloser(sr), nil
+	}
+
+	tr, err := open()
+	if err != nil {
+		return nil, err
+	}
+	n, err := countTar(tr, limits)
+	tr.Close()
+	if err != nil {
+		return nil, err
+	}
+	if n > limits.MaxEntries {
+		return nil, ErrTooManyEntries
+	}
+
+	tr, err = open()
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+	return Tar(tr, limits, fn)
+}
+
+// countTar counts the entries of a tar archive, stopping once
+// there are more than limits.MaxEntries. Skipping over entries
+// still decompresses them, so the bytes read are bounded by the
+// total size limit plus room for the headers and padding.
+func countTar(r io.Reader, limits Limits) (int, error) {
+	lr := &io.LimitedReader{
+		R: r,
+		N: limits.MaxTotalSize + int64(limits.MaxEntries+2)*1024,
+	}
+	tr := tar.NewReader(lr)
+	n := 0
+	for n <= limits.MaxEntries {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if lr.N <= 0 {
+				return n, ErrTooLarge
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Zip imports the images of a ZIP archive.
+func Zip(r io.ReaderAt, size int64, limits Limits, fn ImportFunc) (*Result, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > limits.MaxEntries {
+		return nil, ErrTooManyEntries
+	}
+	x := newExtractor(limits, fn)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			x.skip(f.Name, "not a regular file")
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			x.skip(f.Name, "could not be read")
+			continue
+		}
+		err = x.entry(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return &x.result, err
+		}
+	}
+	return &x.result, nil
+}
+
+// Tar imports the images of an uncompressed tar archive. As the
+// archive is streamed, the entries before the one going over a
+// limit are imported.
+func Tar(r io.Reader, limits Limits, fn ImportFunc) (*Result, error) {
+	tr := tar.NewReader(r)
+	x := newExtractor(limits, fn)
+	for n := 1; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return &x.result, nil
+		}
+		if err != nil {
+			return &x.result, err
+		}
+		if n > limits.MaxEntries {
+			return &x.result, ErrTooManyEntries
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			x.skip(hdr.Name, "not a regular file")
+			continue
+		}
+		if err := x.entry(hdr.Name, tr); err != nil {
+			return &x.result, err
+		}
+	}
+}
+
+type extractor struct {
+	limits Limits
+	fn     ImportFunc
+	// remaining is how many more uncompressed bytes the archive
+	// may produce.
+	remaining int64
+	seen      map[string]bool
+	result    Result
+}
+
+func newExtractor(limits Limits, fn ImportFunc) *extractor {
+	return &extractor{
+		limits:    limits,
+		fn:        fn,
+		remaining: limits.MaxTotalSize,
+		seen:      make(map[string]bool),
+	}
+}
+
+func (x *extractor) skip(name, reason string) {
+	x.result.Skipped = append(x.result.Skipped, Skipped{
+		Name:   name,
+		Reason: reason,
+	})
+}
+
+// entry validates one archive entry and passes it on to the
+// import function when it is an image. Entries are staged in a
+// temporary file first, so an entry going over the limits is
+// never partially imported. Only errors that should stop the
+// whole extraction are returned.
+func (x *extractor) entry(name string, r io.Reader) error {
+	filename, reason := safeName(name)
+	if reason != "" {
+		x.skip(name, reason)
+		return nil
+	}
+	if x.seen[filename] {
+		x.skip(name, "another file with the same name was imported")
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile("", "extract-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	lr := &limitedReader{r: r, entry: x.limits.MaxEntrySize, total: &x.remaining}
+	_, err = io.Copy(tmp, lr)
+	switch err {
+	case nil:
+	case ErrTooLarge:
+		return err
+	case errEntryTooLarge:
+		x.skip(name, "file is too large")
+		return nil
+	default:
+		x.skip(name, "could not be read")
+		return nil
+	}
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !isImage(head[:n]) {
+		x.skip(name, "not a jpg, png, gif, webp, heic or avif image")
+		return nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := x.fn(filename, tmp); err != nil {
+		return err
+	}
+	x.seen[filename] = true
+	x.result.Imported = append(x.result.Imported, filename)
+	return nil
+}
+
+// safeName returns the base name an entry is imported under,
+// or the reason it is skipped.
+func safeName(name string) (string, string) {
+	if strings.Contains(name, "\\") || strings.HasPrefix(name, "/") {
+		return "", "unsafe path"
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", "unsafe path"
+		}
+		// Resource forks and metadata added by macOS.
+		if part == "__MACOSX" {
+			return "", "not an image"
+		}
+	}
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || base == "" {
+		return "", "hidden file"
+	}
+	return base, ""
+}
+
+func isImage(head []byte) bool {
+	switch imaging.ContentType(head) {
+	case "image/jpeg", "image/png", "image/gif",
+		"image/webp", "image/heic", "image/avif":
+		return true
+	}
+	return false
+}
+
+var errEntryTooLarge = errors.New("extract: entry is too large")
+
+// limitedReader fails once more than entry bytes are read from
+// a single entry, or more than *total bytes overall.
+type limitedReader struct {
+	r     io.Reader
+	entry int64
+	read  int64
+	total *int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	*l.total -= int64(n)
+	if *l.total < 0 {
+		return n, ErrTooLarge
+	}
+	if l.read > l.entry {
+		return n, errEntryTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,141 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type entry struct {
+	name string
+	body []byte
+}
+
+func pngBytes(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries []entry) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func tarArchive(t *testing.T, entries []entry) *bytes.Reader {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(e.body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func collect(imported map[string][]byte) ImportFunc {
+	return func(filename string, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		imported[filename] = b
+		return err
+	}
+}
+
+func TestFile(t *testing.T) {
+	img := pngBytes(t)
+	entries := []entry{
+		{"photos/a.png", img},
+		{"photos/notes.txt", []byte("not an image")},
+		{"../../etc/b.png", img},
+		{"photos/.c.png", img},
+		{"__MACOSX/photos/._a.png", img},
+		{"other/a.png", img},
+	}
+	archives := map[string]*bytes.Reader{
+		"photos.zip": zipArchive(t, entries),
+		"photos.tar": tarArchive(t, entries),
+	}
+	for name, r := range archives {
+		imported := make(map[string][]byte)
+		res, err := File(name, r, r.Size(), DefaultLimits, collect(imported))
+		if err != nil {
+			t.Fatalf("%s: File() err = %v", name, err)
+		}
+		if len(res.Imported) != 1 || res.Imported[0] != "a.png" {
+			t.Errorf("%s: Imported = %v, want [a.png]", name, res.Imported)
+		}
+		if !bytes.Equal(imported["a.png"], img) {
+			t.Errorf("%s: a.png was not imported intact", name)
+		}
+		if len(res.Skipped) != 5 {
+			t.Errorf("%s: Skipped = %v, want 5 entries", name, res.Skipped)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	img := pngBytes(t)
+	entries := []entry{
+		{"a.png", img},
+		{"b.png", img},
+		{"c.png", img},
+	}
+	size := int64(len(img))
+
+	tests := []struct {
+		name     string
+		limits   Limits
+		err      error
+		imported int
+	}{
+		{"entries", Limits{MaxEntries: 2, MaxTotalSize: 1 << 20, MaxEntrySize: 1 << 20}, ErrTooManyEntries, 0},
+		{"total", Limits{MaxEntries: 10, MaxTotalSize: 2 * size, MaxEntrySize: 1 << 20}, ErrTooLarge, 2},
+		{"entry", Limits{MaxEntries: 10, MaxTotalSize: 1 << 20, MaxEntrySize: size - 1}, nil, 0},
+	}
+	for _, tc := range tests {
+		for _, archive := range []string{"x.zip", "x.tar"} {
+			var r *bytes.Reader
+			if archive == "x.zip" {
+				r = zipArchive(t, entries)
+			} else {
+				r = tarArchive(t, entries)
+			}
+			imported := make(map[string][]byte)
+			_, err := File(archive, r, r.Size(), tc.limits, collect(imported))
+			if err != tc.err {
+				t.Errorf("%s %s: err = %v, want %v", tc.name, archive, err, tc.err)
+			}
+			if len(imported) != tc.imported {
+				t.Errorf("%s %s: imported %d, want %d", tc.name, archive, len(imported), tc.imported)
+			}
+		}
+	}
+}
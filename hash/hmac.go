@@ -4,29 +4,30 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
 )
 
 // NewHMAC creates and returns a new HMAC object
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: h,
+		key: []byte(key),
 	}
 }
 
 // HMAC is a wrapper around the crypto/hmac package
-// making it a little easier to use in our code.
+// making it a little easier to use in our code. It is safe
+// for concurrent use.
 type HMAC struct {
-	hmac hash.Hash
+	key []byte
 }
 
 // Hash will hash the provided input string using HMAC with
-// the secret key provided when the HMAC object was created
+// the secret key provided when the HMAC object was created.
+// Every call uses its own hash.Hash, as they keep state
+// between writes and are shared by every request.
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 
 	return base64.URLEncoding.EncodeToString(b)
 }
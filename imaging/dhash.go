@@ -0,0 +1,35 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash returns the difference hash of the image, a perceptual
+// hash which stays the same, or close to it, when the image is
+// scaled, re-encoded or slightly edited. Each bit tells whether
+// a pixel of the image shrunk to 9x8 gray pixels is brighter
+// than its right neighbor.
+func DHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance returns how many bits differ between two hashes
+// returned by DHash. Images whose hashes are less than about 10
+// bits apart most likely show the same thing.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
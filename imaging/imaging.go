@@ -0,0 +1,180 @@
+// Package imaging holds the image processing used to turn
+// uploaded originals into the variants we serve.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	// Register the decoders of the other formats we accept.
+	_ "image/gif"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/heic"
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// WebSize is the longest side, in pixels, of the web-size
+	// variant of an image.
+	WebSize = 2048
+
+	// JPEGQuality is used whenever we encode a JPEG.
+	JPEGQuality = 85
+	// WebPQuality and AVIFQuality are used when encoding the
+	// variants served to browsers supporting those formats.
+	WebPQuality = 80
+	AVIFQuality = 60
+	// avifSpeed trades some compression for encoding speed, as
+	// AVIF is by far the slowest format to encode.
+	avifSpeed = 8
+
+	// MaxPixels is the most pixels an image may have for Decode
+	// and DecodeOriented to decode it. Their header is checked
+	// first, so a small file claiming huge dimensions cannot make
+	// us allocate gigabytes for its pixels.
+	MaxPixels = 100_000_000
+)
+
+// ErrTooLarge is returned when decoding an image with more than
+// MaxPixels pixels.
+var ErrTooLarge = errors.New("imaging: image has too many pixels")
+
+// Formats that can be passed to Encode, besides the ones of the
+// standard library.
+const (
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+func init() {
+	// Other brands used by HEIC files, mostly from phones.
+	image.RegisterFormat("heic", "????ftypheix", heic.Decode, heic.DecodeConfig)
+	image.RegisterFormat("heic", "????ftyphevc", heic.Decode, heic.DecodeConfig)
+}
+
+// ContentType returns the MIME type of the image starting with
+// head, which should be its first 512 bytes. Unlike
+// http.DetectContentType it recognizes HEIC and AVIF images.
+func ContentType(head []byte) string {
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		switch string(head[8:12]) {
+		case "heic", "heix", "hevc", "mif1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		}
+	}
+	return http.DetectContentType(head)
+}
+
+// DisplayFormat returns the format every browser can display
+// that an image in the provided format should be converted to:
+// PNG for "png" and "gif", so transparency is kept, and JPEG
+// for anything else.
+func DisplayFormat(format string) string {
+	switch format {
+	case "png", "gif":
+		return "png"
+	}
+	return "jpeg"
+}
+
+// Decode reads an image in any of the registered formats and
+// returns it along with the format name, eg "jpeg" or "png".
+// Images with more than MaxPixels pixels return ErrTooLarge.
+func Decode(r io.Reader) (image.Image, string, error) {
+	var head bytes.Buffer
+	if err := checkSize(io.TeeReader(r, &head)); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(io.MultiReader(&head, r))
+}
+
+// DecodeOriented decodes the image like Decode, then rotates
+// and flips it as its EXIF orientation says it should be
+// displayed.
+func DecodeOriented(r io.ReadSeeker) (image.Image, string, error) {
+	orientation := Orientation(r)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	if err := checkSize(r); err != nil {
+		return nil, "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return Orient(img, orientation), format, nil
+}
+
+// checkSize reads the header of the image from r and returns
+// ErrTooLarge when it has more than MaxPixels pixels.
+func checkSize(r io.Reader) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Fit scales img down, keeping its aspect ratio, so that its
+// longest side is at most max pixels. Smaller images are
+// returned as is.
+func Fit(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// Encode writes img in the provided format. "png" and "gif"
+// are written as PNG, FormatWebP and FormatAVIF in those
+// formats, and anything else as JPEG.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png", "gif":
+		return png.Encode(w, img)
+	case FormatWebP:
+		return webp.Encode(w, img, webp.Options{Quality: WebPQuality, Method: 4})
+	case FormatAVIF:
+		return avif.Encode(w, img, avif.Options{
+			Quality:           AVIFQuality,
+			QualityAlpha:      AVIFQuality,
+			Speed:             avifSpeed,
+			ChromaSubsampling: image.YCbCrSubsampleRatio420,
+		})
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	}
+}
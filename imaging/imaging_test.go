@@ -0,0 +1,188 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		w, h, max    int
+		wantW, wantH int
+	}{
+		{100, 50, 200, 100, 50},
+		{400, 200, 200, 200, 100},
+		{200, 400, 200, 100, 200},
+		{3000, 1, 100, 100, 1},
+	}
+	for _, c := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, c.w, c.h))
+		b := Fit(img, c.max).Bounds()
+		if b.Dx() != c.wantW || b.Dy() != c.wantH {
+			t.Errorf("Fit(%dx%d, %d) = %dx%d, want %dx%d",
+				c.w, c.h, c.max, b.Dx(), b.Dy(), c.wantW, c.wantH)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image where every pixel has its own red value:
+	//   0 1 2
+	//   3 4 5
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Set(i%3, i/3, color.NRGBA{R: uint8(i), A: 255})
+	}
+	cases := map[int][]uint8{
+		1: {0, 1, 2, 3, 4, 5},
+		2: {2, 1, 0, 5, 4, 3},
+		3: {5, 4, 3, 2, 1, 0},
+		4: {3, 4, 5, 0, 1, 2},
+		5: {0, 3, 1, 4, 2, 5},
+		6: {3, 0, 4, 1, 5, 2},
+		7: {5, 2, 4, 1, 3, 0},
+		8: {2, 5, 1, 4, 0, 3},
+	}
+	for orientation, want := range cases {
+		img := Orient(src, orientation)
+		b := img.Bounds()
+		var got []uint8
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				got = append(got, uint8(r>>8))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Orient(%d) = %v, want %v", orientation, got, want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for _, format := range []string{"jpeg", "png", FormatWebP, FormatAVIF} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, format); err != nil {
+			t.Fatalf("Encode(%s) err = %v", format, err)
+		}
+		head := buf.Bytes()
+		if len(head) > 512 {
+			head = head[:512]
+		}
+		if ct := ContentType(head); ct != "image/"+format {
+			t.Errorf("Encode(%s) wrote %s", format, ct)
+		}
+		decoded, _, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decode(%s) err = %v", format, err)
+		}
+		if decoded.Bounds().Dx() != 16 || decoded.Bounds().Dy() != 8 {
+			t.Errorf("Decode(%s) bounds = %v", format, decoded.Bounds())
+		}
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Claim 100000x100000 pixels in the IHDR chunk, which follows
+	// the 8 byte signature, its length and its type.
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[16:], 100000)
+	binary.BigEndian.PutUint32(b[20:], 100000)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+
+	if _, _, err := Decode(bytes.NewReader(b)); err != ErrTooLarge {
+		t.Errorf("Decode() err = %v, want %v", err, ErrTooLarge)
+	}
+	if _, _, err := DecodeOriented(bytes.NewReader(b)); err != ErrTooLarge {
+		t.Errorf("DecodeOriented() err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	black := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(black, black.Bounds(), image.Black, image.Point{}, draw.Src)
+	logo := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.White, image.Point{}, draw.Src)
+
+	cases := map[string]image.Point{
+		PositionTopLeft:     image.Pt(10, 10),
+		PositionTopRight:    image.Pt(390, 10),
+		PositionBottomLeft:  image.Pt(10, 190),
+		PositionBottomRight: image.Pt(390, 190),
+		PositionCenter:      image.Pt(200, 100),
+	}
+	for position, inside := range cases {
+		wm := Watermark{Logo: logo, Position: position, Opacity: 0.5, Scale: 0.1}
+		img, err := wm.Apply(black)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, _, _, _ := img.At(inside.X, inside.Y).RGBA(); r>>8 < 120 || r>>8 > 135 {
+			t.Errorf("%s: pixel at %v has red %d, want about half", position, inside, r>>8)
+		}
+		// The opposite corner is left alone.
+		far := image.Pt(400-inside.X, 200-inside.Y)
+		if position != PositionCenter {
+			if r, _, _, _ := img.At(far.X, far.Y).RGBA(); r != 0 {
+				t.Errorf("%s: pixel at %v was changed", position, far)
+			}
+		}
+	}
+
+	wm := Watermark{Text: "© lenslocked", Opacity: 1, Scale: 0.5}
+	img, err := wm.Apply(black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != black.Bounds() {
+		t.Errorf("bounds = %v, want %v", img.Bounds(), black.Bounds())
+	}
+	var lit int
+	for x := 200; x < 400; x++ {
+		for y := 100; y < 200; y++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r>>8 > 200 {
+				lit++
+			}
+		}
+	}
+	if lit == 0 {
+		t.Error("text was not drawn in the bottom right corner")
+	}
+}
+
+func TestDHash(t *testing.T) {
+	// A horizontal gradient with a dark square in the middle.
+	photo := func(w, h int) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				c := uint8(255 * x / w)
+				if x > w/3 && x < 2*w/3 && y > h/3 && y < 2*h/3 {
+					c = 0
+				}
+				img.Set(x, y, color.RGBA{c, c, c, 255})
+			}
+		}
+		return img
+	}
+	original := DHash(photo(900, 600))
+	if d := Distance(original, DHash(photo(300, 200))); d > 4 {
+		t.Errorf("scaled copy is %d bits away, want at most 4", d)
+	}
+	if d := Distance(original, DHash(Orient(photo(900, 600), 2))); d < 20 {
+		t.Errorf("mirrored copy is %d bits away, want at least 20", d)
+	}
+}
@@ -0,0 +1,218 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Metadata is what we read from the EXIF data embedded in a
+// photo. Fields missing from the photo are left empty.
+type Metadata struct {
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	FocalLength  string // such as "50mm"
+	Aperture     string // such as "f/1.8"
+	ExposureTime string // such as "1/250s"
+	ISO          int
+	TakenAt      time.Time
+	HasLocation  bool
+	Latitude     float64
+	Longitude    float64
+}
+
+// ReadMetadata parses the EXIF data of a JPEG or TIFF image.
+// An error is returned when the image has none.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	x, err := exif.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	var m Metadata
+	m.CameraMake = exifString(x, exif.Make)
+	m.CameraModel = exifString(x, exif.Model)
+	m.LensModel = exifString(x, exif.LensModel)
+	if rat := exifRat(x, exif.FocalLength); rat != nil {
+		m.FocalLength = strings.TrimSuffix(rat.FloatString(1), ".0") + "mm"
+	}
+	if rat := exifRat(x, exif.FNumber); rat != nil {
+		m.Aperture = "f/" + strings.TrimSuffix(rat.FloatString(1), ".0")
+	}
+	if rat := exifRat(x, exif.ExposureTime); rat != nil {
+		m.ExposureTime = exposure(rat)
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		m.ISO, _ = tag.Int(0)
+	}
+	if t, err := x.DateTime(); err == nil {
+		m.TakenAt = t
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		m.HasLocation = true
+		m.Latitude = lat
+		m.Longitude = long
+	}
+	return &m, nil
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(s, "\x00"))
+}
+
+func exifRat(x *exif.Exif, name exif.FieldName) *big.Rat {
+	tag, err := x.Get(name)
+	if err != nil {
+		return nil
+	}
+	rat, err := tag.Rat(0)
+	if err != nil || rat.Sign() <= 0 {
+		return nil
+	}
+	return rat
+}
+
+// exposure formats an exposure time the way cameras show it,
+// as a fraction of a second below one second.
+func exposure(rat *big.Rat) string {
+	if rat.Cmp(big.NewRat(1, 1)) >= 0 {
+		return strings.TrimSuffix(rat.FloatString(1), ".0") + "s"
+	}
+	f, _ := rat.Float64()
+	return fmt.Sprintf("1/%.0fs", 1/f)
+}
+
+var (
+	jpegSOI   = []byte{0xFF, 0xD8}
+	pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+	// ErrUnsupportedFormat is returned by StripMetadata for
+	// images other than JPEG and PNG.
+	ErrUnsupportedFormat = errors.New("imaging: can only strip metadata from jpeg and png images")
+)
+
+// StripMetadata copies the image from r to w without the EXIF,
+// XMP, IPTC and text metadata it may carry, such as the GPS
+// coordinates of where a photo was taken. The image data
+// itself is copied as is, so nothing is lost to re-encoding.
+// Only JPEG and PNG images are supported.
+func StripMetadata(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(pngHeader))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(head, jpegSOI):
+		return stripJPEG(w, br)
+	case bytes.HasPrefix(head, pngHeader):
+		return stripPNG(w, br)
+	}
+	return ErrUnsupportedFormat
+}
+
+// stripJPEG drops the APP1 (EXIF and XMP), APP13 (IPTC) and
+// comment segments found before the image data. Other segments,
+// like the ICC color profile in APP2, are kept.
+func stripJPEG(w io.Writer, r *bufio.Reader) error {
+	if _, err := io.CopyN(w, r, int64(len(jpegSOI))); err != nil {
+		return err
+	}
+	for {
+		marker := make([]byte, 2)
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return err
+		}
+		if marker[0] != 0xFF {
+			return errors.New("imaging: invalid jpeg marker")
+		}
+		// Start of scan: the rest of the file is image data.
+		if marker[1] == 0xDA {
+			if _, err := w.Write(marker); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, r)
+			return err
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return errors.New("imaging: invalid jpeg segment")
+		}
+		switch marker[1] {
+		case 0xE1, 0xED, 0xFE:
+			if _, err := r.Discard(int(length) - 2); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(marker); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, length); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, int64(length)-2); err != nil {
+			return err
+		}
+	}
+}
+
+// strippedPNGChunks are the ancillary chunks that may carry
+// metadata about the photo or its author.
+var strippedPNGChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"iTXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(w io.Writer, r *bufio.Reader) error {
+	if _, err := io.CopyN(w, r, int64(len(pngHeader))); err != nil {
+		return err
+	}
+	for {
+		header := make([]byte, 8)
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		// The chunk data is followed by a 4 byte CRC.
+		if strippedPNGChunks[string(header[4:])] {
+			if _, err := io.CopyN(ioutil.Discard, r, length+4); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return err
+		}
+	}
+}
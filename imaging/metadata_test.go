@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func jpegSegment(marker byte, data string) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(data)+2))
+	return append(seg, data...)
+}
+
+func pngChunk(typ, data string) []byte {
+	chunk := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE([]byte(typ+data)))
+	return append(chunk, crc...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	const secret = "GPS 48.8584 2.2945"
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	withExif := append([]byte{}, jpg.Bytes()[:2]...)
+	withExif = append(withExif, jpegSegment(0xE1, "Exif\x00\x00"+secret)...)
+	withExif = append(withExif, jpegSegment(0xFE, secret)...)
+	withExif = append(withExif, jpg.Bytes()[2:]...)
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	withText := append([]byte{}, pngBuf.Bytes()[:33]...) // signature and IHDR
+	withText = append(withText, pngChunk("tEXt", "Comment\x00"+secret)...)
+	withText = append(withText, pngBuf.Bytes()[33:]...)
+
+	for name, b := range map[string][]byte{"jpeg": withExif, "png": withText} {
+		if _, _, err := image.Decode(bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: test image is invalid: %v", name, err)
+		}
+		var out bytes.Buffer
+		if err := StripMetadata(&out, bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: StripMetadata() err = %v", name, err)
+		}
+		if bytes.Contains(out.Bytes(), []byte(secret)) {
+			t.Errorf("%s: metadata was not stripped", name)
+		}
+		if _, _, err := image.Decode(&out); err != nil {
+			t.Errorf("%s: stripped image cannot be decoded: %v", name, err)
+		}
+	}
+
+	if err := StripMetadata(&bytes.Buffer{}, bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("StripMetadata() of a gif did not fail")
+	}
+}
@@ -0,0 +1,158 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Positions a watermark can be drawn at.
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+// textSize is the size in pixels text is rendered at before
+// being scaled to the width of the watermark.
+const textSize = 96
+
+// textFont is the typeface watermark text is written in.
+var textFont = mustParseFont(goregular.TTF)
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Watermark is a mark drawn over the images we serve, either a
+// logo or a line of text.
+type Watermark struct {
+	// Logo is drawn when set, and Text otherwise.
+	Logo image.Image
+	Text string
+	// Position is where the mark is drawn, eg PositionBottomRight.
+	Position string
+	// Opacity goes from 0, invisible, to 1, opaque.
+	Opacity float64
+	// Scale is the width of the mark as a fraction of the width
+	// of the image.
+	Scale float64
+}
+
+// Apply returns a copy of img with the watermark drawn over it.
+func (wm *Watermark) Apply(img image.Image) (image.Image, error) {
+	mark := wm.Logo
+	if mark == nil {
+		if wm.Text == "" {
+			return img, nil
+		}
+		var err error
+		mark, err = textImage(wm.Text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b, mb := img.Bounds(), mark.Bounds()
+	if mb.Empty() {
+		return img, nil
+	}
+	width := int(float64(b.Dx()) * wm.Scale)
+	height := mb.Dy() * width / mb.Dx()
+	// Tall logos on wide images are kept within the image.
+	if height > b.Dy() {
+		width = width * b.Dy() / height
+		height = b.Dy()
+	}
+	if width < 1 || height < 1 {
+		return img, nil
+	}
+
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+	alpha := color.Alpha{A: uint8(clamp(wm.Opacity)*255 + 0.5)}
+	draw.DrawMask(dst, wm.rect(b, width, height), scaled, image.Point{},
+		image.NewUniform(alpha), image.Point{}, draw.Over)
+	return dst, nil
+}
+
+// rect returns where a mark of the provided size is drawn on an
+// image with bounds b, leaving a small margin to the edges.
+func (wm *Watermark) rect(b image.Rectangle, width, height int) image.Rectangle {
+	margin := b.Dx()
+	if b.Dy() < margin {
+		margin = b.Dy()
+	}
+	margin /= 40
+	left, top := b.Min.X+margin, b.Min.Y+margin
+	right, bottom := b.Max.X-margin-width, b.Max.Y-margin-height
+	var p image.Point
+	switch wm.Position {
+	case PositionTopLeft:
+		p = image.Pt(left, top)
+	case PositionTopRight:
+		p = image.Pt(right, top)
+	case PositionBottomLeft:
+		p = image.Pt(left, bottom)
+	case PositionCenter:
+		p = image.Pt(b.Min.X+(b.Dx()-width)/2, b.Min.Y+(b.Dy()-height)/2)
+	default:
+		p = image.Pt(right, bottom)
+	}
+	return image.Rectangle{Min: p, Max: p.Add(image.Pt(width, height))}.Intersect(b)
+}
+
+// textImage renders the text in white over a transparent
+// background, with a soft shadow so it stays readable on light
+// photos.
+func textImage(text string) (image.Image, error) {
+	// Faces cache glyphs and are not safe for concurrent use, so
+	// every call gets its own.
+	face, err := opentype.NewFace(textFont, &opentype.FaceOptions{
+		Size:    textSize,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	d := font.Drawer{Face: face}
+	metrics := face.Metrics()
+	shadow := textSize / 24
+	width := d.MeasureString(text).Ceil() + shadow
+	height := metrics.Height.Ceil() + shadow
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	d.Dst = img
+
+	d.Src = image.NewUniform(color.NRGBA{A: 128})
+	d.Dot = fixed.P(shadow, metrics.Ascent.Ceil()+shadow)
+	d.DrawString(text)
+	d.Src = image.White
+	d.Dot = fixed.P(0, metrics.Ascent.Ceil())
+	d.DrawString(text)
+	return img, nil
+}
+
+func clamp(f float64) float64 {
+	switch {
+	case f < 0:
+		return 0
+	case f > 1:
+		return 1
+	}
+	return f
+}
@@ -0,0 +1,79 @@
+// Package jobs runs background maintenance tasks, such as
+// removing abandoned uploads, at a fixed interval.
+package jobs
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Func is the work done by a job. Errors are logged and the
+// job runs again at its next interval.
+type Func func() error
+
+type job struct {
+	name     string
+	interval time.Duration
+	fn       Func
+}
+
+// Scheduler runs every job added to it in its own goroutine,
+// once right after Start and then every interval, until Stop
+// is called.
+type Scheduler struct {
+	jobs   []job
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	logger *slog.Logger
+}
+
+// NewScheduler returns a Scheduler logging the errors of its
+// jobs to logger.
+func NewScheduler(logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		stop:   make(chan struct{}),
+		logger: logger,
+	}
+}
+
+// Add registers a job. Jobs must be added before Start is
+// called.
+func (s *Scheduler) Add(name string, interval time.Duration, fn Func) {
+	s.jobs = append(s.jobs, job{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+	})
+}
+
+// Start begins running the jobs in the background.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.run(j)
+	}
+}
+
+// Stop waits for the jobs that are running to finish and stops
+// scheduling them again.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(j job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.fn(); err != nil {
+			s.logger.Error("job failed", "job", j.name, "err", err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
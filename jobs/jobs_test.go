@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	var ok, failing int32
+	var logs bytes.Buffer
+	s := NewScheduler(slog.New(slog.NewTextHandler(&logs, nil)))
+	s.Add("ok", time.Millisecond, func() error {
+		atomic.AddInt32(&ok, 1)
+		return nil
+	})
+	s.Add("failing", time.Millisecond, func() error {
+		atomic.AddInt32(&failing, 1)
+		return errors.New("failed")
+	})
+	s.Start()
+	time.Sleep(20 * time.Millisecond)
+	s.Stop()
+
+	if atomic.LoadInt32(&ok) < 2 {
+		t.Errorf("ok ran %d times, want at least 2", ok)
+	}
+	// A failing job keeps being scheduled.
+	if atomic.LoadInt32(&failing) < 2 {
+		t.Errorf("failing ran %d times, want at least 2", failing)
+	}
+	if !strings.Contains(logs.String(), "job=failing") {
+		t.Errorf("failure was not logged: %q", logs.String())
+	}
+
+	ran := atomic.LoadInt32(&ok)
+	time.Sleep(5 * time.Millisecond)
+	if atomic.LoadInt32(&ok) != ran {
+		t.Error("job ran after Stop returned")
+	}
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"github.com/samueldaviddelacruz/lenslocked.com/controllers"
 	"github.com/samueldaviddelacruz/lenslocked.com/email"
+	"github.com/samueldaviddelacruz/lenslocked.com/jobs"
+	"github.com/samueldaviddelacruz/lenslocked.com/metrics"
 	"github.com/samueldaviddelacruz/lenslocked.com/middleware"
 	"github.com/samueldaviddelacruz/lenslocked.com/models"
 	"github.com/samueldaviddelacruz/lenslocked.com/rand"
@@ -19,25 +24,108 @@ func main() {
 
 	boolPtr := flag.Bool("prod", false,
 		"Provide this flag in production. This ensures that a config.json file is provided before the application starts")
+	gcPtr := flag.Bool("gc", false,
+		"Report orphaned files and dangling image records, then exit")
+	gcRemovePtr := flag.Bool("gc-remove", false,
+		"Along with -gc, remove the orphaned files and dangling image records found")
+	adminPtr := flag.String("admin", "",
+		"Give the user with this email address access to the admin area, then exit")
 	flag.Parse()
 	appCfg := LoadConfig(*boolPtr)
+	logger := newLogger(appCfg.IsProd())
+	slog.SetDefault(logger)
 	postgresConfig := appCfg.Database
+	quotas := appCfg.Plans.Quotas()
 
 	services, err := models.NewServices(
 		models.WithGorm(
 			postgresConfig.Dialect(),
 			postgresConfig.ConnectionInfo()),
 		models.WithLogMode(!appCfg.IsProd()),
-		models.WithUser(appCfg.Pepper, appCfg.HMACKey),
+		models.WithLogger(logger),
+		models.WithUser(appCfg.Pepper, appCfg.HMACKey, quotas),
+		models.WithAPIToken(appCfg.HMACKey),
+		models.WithWebhook(!appCfg.IsProd()),
 		models.WithGallery(),
-		models.WithImage(),
+		models.WithCollection(),
+		models.WithWatermark(),
+		models.WithImage(quotas),
+		models.WithTrash(),
+		models.WithGC(),
+		models.WithUpload(),
 		models.WithOAuth(),
+		models.WithAudit(),
+		models.WithReport(),
 	)
 	must(err)
 
 	defer services.Close()
 	//must(services.DestructiveReset())
 	must(services.AutoMigrate())
+	imported, err := services.Image.ImportFiles()
+	must(err)
+	if imported > 0 {
+		logger.Info("imported images found on disk without a record", "count", imported)
+	}
+
+	if *adminPtr != "" {
+		user, err := services.User.ByEmail(*adminPtr)
+		must(err)
+		user.Admin = true
+		must(services.User.Update(user))
+		fmt.Println(user.Email, "is now an admin")
+		return
+	}
+
+	if *gcPtr {
+		report, err := services.GC.Collect(*gcRemovePtr)
+		must(err)
+		printGCReport(logger, report, *gcRemovePtr)
+		return
+	}
+
+	scheduler := jobs.NewScheduler(logger)
+	scheduler.Add("expire uploads", time.Hour, func() error {
+		n, err := services.Upload.Expire()
+		if n > 0 {
+			logger.Info("removed abandoned uploads", "count", n)
+		}
+		return err
+	})
+	scheduler.Add("purge trash", time.Hour, func() error {
+		n, err := services.Trash.Expire()
+		if n > 0 {
+			logger.Info("purged galleries and images from the trash", "count", n)
+		}
+		return err
+	})
+	if appCfg.GC.IntervalHours > 0 {
+		interval := time.Duration(appCfg.GC.IntervalHours) * time.Hour
+		scheduler.Add("collect garbage", interval, func() error {
+			report, err := services.GC.Collect(appCfg.GC.Remove)
+			if report != nil && !report.Empty() {
+				printGCReport(logger, report, appCfg.GC.Remove)
+			}
+			return err
+		})
+	}
+	scheduler.Add("deliver webhooks", time.Minute, func() error {
+		_, err := services.Webhook.DeliverDue()
+		return err
+	})
+	scheduler.Add("prune webhook deliveries", 24*time.Hour, func() error {
+		n, err := services.Webhook.Prune()
+		if n > 0 {
+			logger.Info("removed old webhook deliveries", "count", n)
+		}
+		return err
+	})
+	scheduler.Add("recount storage usage", 24*time.Hour, services.Image.RecountUsage)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	metrics.RegisterDB(services.DB())
+	metrics.RegisterQueue("webhook_deliveries", services.Webhook.PendingDeliveries)
 
 	mgCfg := appCfg.Mailgun
 	emailer := email.NewClient(
@@ -47,9 +135,23 @@ func main() {
 
 	r := mux.NewRouter()
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User, emailer)
+	usersC := controllers.NewUsers(services.User, services.Audit, emailer)
 
-	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, services.User, services.Audit, r)
+	profilesC := controllers.NewProfiles(services.User, services.Gallery, services.Collection, services.Image)
+	watermarksC := controllers.NewWatermarks(services.Watermark, services.Gallery, services.Image)
+	tokensC := controllers.NewTokens(services.APIToken)
+	webhooksC := controllers.NewWebhooks(services.Webhook)
+	uploadsC := controllers.NewUploads(services.Upload, services.Gallery, services.Image, r)
+	trashC := controllers.NewTrash(services.Trash, services.Gallery, services.Audit)
+	apiC := controllers.NewAPI(services.Gallery, services.Image, services.Audit)
+	graphQLC := controllers.NewGraphQL(services.User, services.Gallery, services.Image)
+	adminC := controllers.NewAdmin(services.User, services.Gallery, services.Image, services.Trash, services.Audit, services.Report, emailer)
+	reportsC := controllers.NewReports(services.Report, services.Gallery)
+	metricsAllow, err := appCfg.Metrics.Networks()
+	must(err)
+	metricsC := controllers.NewMetrics(metricsAllow)
+	collectionsC := controllers.NewCollections(services.Collection, services.Gallery, services.Image, services.User, r)
 	oauthConfigs := make(map[string]*oauth2.Config)
 	oauthConfigs[models.OauthDropbox] = &oauth2.Config{
 		ClientID:     appCfg.Dropbox.ID,
@@ -61,23 +163,46 @@ func main() {
 		RedirectURL: appCfg.Dropbox.RedirectURL,
 	}
 
-	oauthC := controllers.NewAuths(services.OAuth, oauthConfigs)
+	oauthC := controllers.NewAuths(services.OAuth, services.Audit, oauthConfigs)
 	randBytes, err := rand.Bytes(32)
 	must(err)
-	csrfMw := csrf.Protect(randBytes, csrf.Secure(appCfg.IsProd()))
+	csrfMw := csrf.Protect(randBytes, csrf.Secure(appCfg.IsProd()),
+		csrf.ErrorHandler(http.HandlerFunc(controllers.CSRFFailure)))
 
 	userMw := middleware.User{
 		UserService: services.User,
+		APITokens:   services.APIToken,
 	}
 	requireUserMw := middleware.RequireUser{
 		User: userMw,
 	}
+	csrfExemptMw := middleware.CSRFExempt{}
+	loggerMw := middleware.Logger{Logger: logger}
+	trustedProxies, err := appCfg.TrustedProxyNetworks()
+	must(err)
+	clientIPMw := middleware.ClientIP{TrustedProxies: trustedProxies}
+	metricsMw := middleware.Metrics{}
+	requireAPIUserMw := middleware.RequireAPIUser{
+		User: userMw,
+	}
+	requireAdminMw := middleware.RequireAdmin{
+		User: userMw,
+	}
+	requireQueryUserMw := middleware.RequireAPIUser{
+		User:     userMw,
+		ReadOnly: true,
+	}
 	// Ouauth Routes
 
 	r.HandleFunc("/oauth/{service:[a-z]+}/connect", requireUserMw.ApplyFn(oauthC.Connect))
 	r.HandleFunc("/oauth/{service:[a-z]+}/callback", requireUserMw.ApplyFn(oauthC.Callback))
 	r.HandleFunc("/oauth/{service:[a-z]+}/test", requireUserMw.ApplyFn(oauthC.DropboxTest))
 
+	r.Use(func(next http.Handler) http.Handler {
+		return metricsMw.Apply(next)
+	})
+	r.HandleFunc("/metrics", metricsC.Serve).Methods("GET").Name("metrics")
+
 	r.Handle("/", staticC.Home).Methods("GET")
 	r.Handle("/contact", staticC.Contact).Methods("GET")
 
@@ -94,13 +219,39 @@ func main() {
 	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
 	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
 
+	r.HandleFunc("/account", requireUserMw.ApplyFn(profilesC.Account)).Methods("GET")
+	r.HandleFunc("/account", requireUserMw.ApplyFn(profilesC.UpdateAccount)).Methods("POST")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(tokensC.Index)).Methods("GET")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(tokensC.Create)).Methods("POST")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}/delete", requireUserMw.ApplyFn(tokensC.Delete)).Methods("POST")
+	r.HandleFunc("/account/webhooks", requireUserMw.ApplyFn(webhooksC.Index)).Methods("GET")
+	r.HandleFunc("/account/webhooks", requireUserMw.ApplyFn(webhooksC.Create)).Methods("POST")
+	r.HandleFunc("/account/webhooks/{id:[0-9]+}", requireUserMw.ApplyFn(webhooksC.Edit)).Methods("GET")
+	r.HandleFunc("/account/webhooks/{id:[0-9]+}/update", requireUserMw.ApplyFn(webhooksC.Update)).Methods("POST")
+	r.HandleFunc("/account/webhooks/{id:[0-9]+}/test", requireUserMw.ApplyFn(webhooksC.Test)).Methods("POST")
+	r.HandleFunc("/account/webhooks/{id:[0-9]+}/delete", requireUserMw.ApplyFn(webhooksC.Delete)).Methods("POST")
+	r.HandleFunc("/account/activity", requireUserMw.ApplyFn(usersC.Activity)).Methods("GET")
+	r.HandleFunc("/account/watermark", requireUserMw.ApplyFn(watermarksC.Edit)).Methods("GET")
+	r.HandleFunc("/account/watermark", requireUserMw.ApplyFn(watermarksC.Update)).Methods("POST")
+	r.HandleFunc("/account/watermark/logo", requireUserMw.ApplyFn(watermarksC.Logo)).Methods("GET")
+	r.HandleFunc("/u/{username:[a-z0-9-]+}", profilesC.Show).Methods("GET")
+
 	//assets
 	assetsHandler := http.FileServer(http.Dir("./assets"))
 	assetsHandler = http.StripPrefix("/assets/", assetsHandler)
 	r.PathPrefix("/assets/").Handler(assetsHandler)
-	// Image routes
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
+	// Image routes. Files are served by the galleries controller
+	// so gallery visibility and metadata stripping apply to them.
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/{filename}", galleriesC.ImageFile).Methods("GET", "HEAD")
+
+	// Trash routes
+	r.HandleFunc("/trash", requireUserMw.ApplyFn(trashC.Index)).Methods("GET")
+	r.HandleFunc("/trash/empty", requireUserMw.ApplyFn(trashC.Empty)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreGallery)).Methods("POST")
+	r.HandleFunc("/trash/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(trashC.PurgeGallery)).Methods("POST")
+	r.HandleFunc("/trash/images/{id:[0-9]+}", requireUserMw.ApplyFn(trashC.ImageFile)).Methods("GET", "HEAD")
+	r.HandleFunc("/trash/images/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreImage)).Methods("POST")
+	r.HandleFunc("/trash/images/{id:[0-9]+}/delete", requireUserMw.ApplyFn(trashC.PurgeImage)).Methods("POST")
 
 	// Gallery routes
 
@@ -109,22 +260,97 @@ func main() {
 	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
 
 	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/similar", requireUserMw.ApplyFn(galleriesC.Similar)).Methods("GET")
 	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/download", galleriesC.Download).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/report", reportsC.New).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/report", reportsC.Create).Methods("POST")
+	r.HandleFunc("/u/{username:[a-z0-9-]+}/{slug:[a-z0-9-]+}", galleriesC.ShowBySlug).Methods("GET").Name(controllers.ShowGalleryBySlug)
 
 	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(controllers.EditGallery)
 	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
 
 	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	// POST /galleries/:id/images/bulk
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/bulk", requireUserMw.ApplyFn(galleriesC.ImageBulk)).Methods("POST")
+	// POST /galleries/:id/images/order
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(galleriesC.ImageOrder)).Methods("POST")
+	// Resumable uploads, see controllers.Uploads
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads", uploadsC.Options).Methods("OPTIONS")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads", requireUserMw.ApplyFn(uploadsC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{token:[A-Za-z0-9_-]+}", requireUserMw.ApplyFn(uploadsC.Head)).Methods("HEAD").Name(controllers.ShowUpload)
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{token:[A-Za-z0-9_-]+}", requireUserMw.ApplyFn(uploadsC.Patch)).Methods("PATCH")
+	r.HandleFunc("/galleries/{id:[0-9]+}/uploads/{token:[A-Za-z0-9_-]+}", requireUserMw.ApplyFn(uploadsC.Delete)).Methods("DELETE")
+	// POST /galleries/:id/images/order/taken
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order/taken", requireUserMw.ApplyFn(galleriesC.ImageOrderByTaken)).Methods("POST")
+	// GET /galleries/:id/images/:filename
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}", galleriesC.ImageShow).Methods("GET")
 	//galleries/:id/images/link
 	r.HandleFunc("/galleries/{id:[0-9]+}/images/link", requireUserMw.ApplyFn(galleriesC.ImageViaLink)).Methods("POST")
 
 	// POST /galleries/:id/images/:filename/delete
 	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
+	// POST /galleries/:id/images/:filename/update
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/update", requireUserMw.ApplyFn(galleriesC.ImageUpdate)).Methods("POST")
+	// POST /galleries/:id/images/:filename/cover
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/cover", requireUserMw.ApplyFn(galleriesC.ImageCover)).Methods("POST")
 
 	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
-	fmt.Printf("Starting the server on port :%d\n", appCfg.Port)
 
-	http.ListenAndServe(fmt.Sprintf(":%d", appCfg.Port), csrfMw(userMw.Apply(r)))
+	// Admin routes
+	r.HandleFunc("/admin/users", requireAdminMw.ApplyFn(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", requireAdminMw.ApplyFn(adminC.User)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/disable", requireAdminMw.ApplyFn(adminC.Disable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/enable", requireAdminMw.ApplyFn(adminC.Enable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/impersonate", requireAdminMw.ApplyFn(adminC.Impersonate)).Methods("POST")
+	r.HandleFunc("/admin/audit", requireAdminMw.ApplyFn(adminC.Audit)).Methods("GET")
+	r.HandleFunc("/admin/reports", requireAdminMw.ApplyFn(adminC.Reports)).Methods("GET")
+	r.HandleFunc("/admin/reports/{id:[0-9]+}", requireAdminMw.ApplyFn(adminC.Report)).Methods("GET")
+	r.HandleFunc("/admin/reports/{id:[0-9]+}/hide", requireAdminMw.ApplyFn(adminC.HideGallery)).Methods("POST")
+	r.HandleFunc("/admin/reports/{id:[0-9]+}/unhide", requireAdminMw.ApplyFn(adminC.UnhideGallery)).Methods("POST")
+	r.HandleFunc("/admin/reports/{id:[0-9]+}/resolve", requireAdminMw.ApplyFn(adminC.Resolve)).Methods("POST")
+	r.HandleFunc("/admin/impersonate/stop", requireUserMw.ApplyFn(adminC.StopImpersonating)).Methods("POST")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}", requireAdminMw.ApplyFn(adminC.Gallery)).Methods("GET")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/remove", requireAdminMw.ApplyFn(adminC.RemoveGallery)).Methods("POST")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/remove", requireAdminMw.ApplyFn(adminC.RemoveImage)).Methods("POST")
+
+	// API routes
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.yaml", apiC.OpenAPI).Methods("GET")
+	api.HandleFunc("/me", requireAPIUserMw.ApplyFn(apiC.Me)).Methods("GET")
+	api.HandleFunc("/galleries", requireAPIUserMw.ApplyFn(apiC.Galleries)).Methods("GET")
+	api.HandleFunc("/galleries", requireAPIUserMw.ApplyFn(apiC.CreateGallery)).Methods("POST")
+	api.HandleFunc("/galleries/{id:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.Gallery)).Methods("GET")
+	api.HandleFunc("/galleries/{id:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.UpdateGallery)).Methods("PATCH")
+	api.HandleFunc("/galleries/{id:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.DeleteGallery)).Methods("DELETE")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", requireAPIUserMw.ApplyFn(apiC.Images)).Methods("GET")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", requireAPIUserMw.ApplyFn(apiC.UploadImages)).Methods("POST")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.Image)).Methods("GET")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.UpdateImage)).Methods("PATCH")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", requireAPIUserMw.ApplyFn(apiC.DeleteImage)).Methods("DELETE")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/file", requireAPIUserMw.ApplyFn(apiC.ImageFile)).Methods("GET", "HEAD")
+	r.HandleFunc("/api/graphql", requireQueryUserMw.ApplyFn(graphQLC.Serve)).Methods("GET", "POST")
+
+	// Collection routes
+
+	r.Handle("/collections/new", requireUserMw.Apply(collectionsC.New)).Methods("GET")
+	r.HandleFunc("/collections", requireUserMw.ApplyFn(collectionsC.Index)).Methods("GET")
+	r.HandleFunc("/collections", requireUserMw.ApplyFn(collectionsC.Create)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}", collectionsC.Show).Methods("GET")
+	r.HandleFunc("/u/{username:[a-z0-9-]+}/c/{slug:[a-z0-9-]+}", collectionsC.ShowBySlug).Methods("GET").Name(controllers.ShowCollectionBySlug)
+	r.HandleFunc("/c/{token:[A-Za-z0-9_-]+}", collectionsC.ShowShared).Methods("GET").Name(controllers.ShowCollectionBySharing)
+	r.HandleFunc("/collections/{id:[0-9]+}/edit", requireUserMw.ApplyFn(collectionsC.Edit)).Methods("GET").Name(controllers.EditCollection)
+	r.HandleFunc("/collections/{id:[0-9]+}/update", requireUserMw.ApplyFn(collectionsC.Update)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/share", requireUserMw.ApplyFn(collectionsC.Share)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/delete", requireUserMw.ApplyFn(collectionsC.Delete)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/galleries", requireUserMw.ApplyFn(collectionsC.AddGallery)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/galleries/order", requireUserMw.ApplyFn(collectionsC.OrderGalleries)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/galleries/{galleryID:[0-9]+}/remove", requireUserMw.ApplyFn(collectionsC.RemoveGallery)).Methods("POST")
+
+	logger.Info("starting the server", "port", appCfg.Port)
+
+	err = http.ListenAndServe(fmt.Sprintf(":%d", appCfg.Port), loggerMw.Apply(clientIPMw.Apply(csrfExemptMw.Apply(csrfMw(userMw.Apply(r))))))
+	logger.Error("server stopped", "err", err)
 }
 
 func must(err error) {
@@ -132,3 +358,30 @@ func must(err error) {
 		panic(err)
 	}
 }
+
+// newLogger returns the logger of the app, which writes JSON in
+// production for log collectors and text otherwise.
+func newLogger(prod bool) *slog.Logger {
+	if prod {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
+
+// printGCReport logs what the garbage collector found, and
+// whether it was removed.
+func printGCReport(logger *slog.Logger, report *models.GCReport, removed bool) {
+	action := "found"
+	if removed {
+		action = "removed"
+	}
+	logger = logger.With("job", "gc", "action", action)
+	for _, path := range report.Paths {
+		logger.Info("orphaned file", "path", path)
+	}
+	for _, image := range report.Images {
+		logger.Info("dangling image record", "image_id", image.ID,
+			"filename", image.Filename, "gallery_id", image.GalleryID)
+	}
+	logger.Info("garbage collection done", "files", len(report.Paths), "records", len(report.Images))
+}
@@ -0,0 +1,132 @@
+// Package metrics holds the Prometheus metrics of the app and
+// the helpers the rest of it records them with. They are kept
+// in their own registry, served by Handler.
+package metrics
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lenslocked"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	uploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Image files stored in galleries.",
+	})
+
+	uploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of the image files stored in galleries.",
+	})
+
+	imageProcessing = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_processing_duration_seconds",
+		Help:      "Time taken to process images, by step.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"step"})
+
+	emails = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails sent, by kind and outcome.",
+	}, []string{"kind", "outcome"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		uploads,
+		uploadBytes,
+		imageProcessing,
+		emails,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a request served by the route, which
+// is its mux route name or path template.
+func ObserveRequest(route, method string, status int, d time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// ObserveUpload records an image file of size bytes being
+// stored.
+func ObserveUpload(size int64) {
+	uploads.Inc()
+	uploadBytes.Add(float64(size))
+}
+
+// ObserveImageProcessing records how long a step of image
+// processing, such as generating a variant, took since start.
+func ObserveImageProcessing(step string, start time.Time) {
+	imageProcessing.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// ObserveEmail records an email of the kind being sent, which
+// failed when err is not nil.
+func ObserveEmail(kind string, err error) {
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	emails.WithLabelValues(kind, outcome).Inc()
+}
+
+// RegisterDB reports the stats of the connection pool.
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// RegisterQueue reports how many items wait in the named
+// queue, which depth counts each time the metrics are served.
+// Errors are logged and reported as a depth of 0.
+func RegisterQueue(name string, depth func() (int, error)) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "job_queue_depth",
+		Help:        "Items waiting to be processed by background jobs, by queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		n, err := depth()
+		if err != nil {
+			slog.Error("counting queue failed", "queue", name, "err", err)
+			return 0
+		}
+		return float64(n)
+	}))
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+)
+
+// ClientIP finds the address each request came from and records
+// it in the request context. X-Forwarded-For is only trusted
+// when the connection comes from one of TrustedProxies, as
+// anyone else can send whatever they like in it. Behind those,
+// the address is the last one in the header that is not a
+// trusted proxy itself.
+type ClientIP struct {
+	TrustedProxies []*net.IPNet
+}
+
+func (mw *ClientIP) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *ClientIP) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithClientIP(r.Context(), mw.clientIP(r))
+		next(w, r.WithContext(ctx))
+	})
+}
+
+func (mw *ClientIP) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !mw.trusted(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !mw.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (mw *ClientIP) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range mw.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+)
+
+func TestClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"127.0.0.1/32", "10.0.0.0/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"untrusted peer", "203.0.113.5:1234", []string{"127.0.0.1"}, "203.0.113.5"},
+		{"trusted proxy", "127.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"spoofed first hop", "127.0.0.1:1234", []string{"127.0.0.1, 198.51.100.7"}, "198.51.100.7"},
+		{"trusted hops", "127.0.0.1:1234", []string{"198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"several headers", "127.0.0.1:1234", []string{"127.0.0.1", "198.51.100.7"}, "198.51.100.7"},
+		{"no header", "127.0.0.1:1234", nil, "127.0.0.1"},
+		{"invalid hop", "127.0.0.1:1234", []string{"198.51.100.7, nonsense"}, "127.0.0.1"},
+		{"only trusted hops", "127.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"untrusted ipv6 peer", "[2001:db8::1]:1234", []string{"198.51.100.7"}, "2001:db8::1"},
+	}
+	for _, test := range tests {
+		mw := ClientIP{TrustedProxies: trusted}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		var got string
+		mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+			got = context.ClientIP(r.Context())
+		})(httptest.NewRecorder(), r)
+		if got != test.want {
+			t.Errorf("%s: client IP = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
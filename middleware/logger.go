@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	llctx "github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+)
+
+// requestIDPattern is what an X-Request-ID set by our proxy must
+// look like to be used rather than a new one.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type accessKey struct{}
+
+// access is what the access log entry of a request learns while
+// it is served.
+type access struct {
+	userID uint
+}
+
+// Logger gives every request an ID, sent back in the
+// X-Request-ID header, and a logger carrying it in its context.
+// Once the request is served, it is logged with its status and
+// latency. It must come before User, which adds the user ID to
+// both.
+type Logger struct {
+	Logger *slog.Logger
+}
+
+func (mw *Logger) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Logger) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			var err error
+			if id, err = rand.RequestID(); err != nil {
+				mw.Logger.Error("generating request ID failed", "err", err)
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		logger := mw.Logger.With("request_id", id)
+		var entry access
+		ctx := llctx.WithLogger(r.Context(), logger)
+		ctx = context.WithValue(ctx, accessKey{}, &entry)
+		sw := &statusWriter{ResponseWriter: w}
+
+		next(sw, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.Status()),
+			slog.Int64("bytes", sw.bytes),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(entry.userID)))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// withLogUser returns r with the ID of the user, and of the
+// admin impersonating them if any, added to its logger and to
+// its access log entry.
+func withLogUser(r *http.Request, user, impersonator *models.User) *http.Request {
+	ctx := r.Context()
+	if entry, ok := ctx.Value(accessKey{}).(*access); ok {
+		entry.userID = user.ID
+	}
+	logger := llctx.Logger(ctx).With("user_id", user.ID)
+	if impersonator != nil {
+		logger = logger.With("impersonator_id", impersonator.ID)
+	}
+	return r.WithContext(llctx.WithLogger(ctx, logger))
+}
+
+// statusWriter records the status and the size of the response
+// written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the writer of the
+// server, to flush it or change its deadlines.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Status returns the status of the response, which is 200 when
+// nothing was written.
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
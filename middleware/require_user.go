@@ -1,15 +1,29 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/gorilla/csrf"
+
 	"github.com/samueldaviddelacruz/lenslocked.com/context"
 	"github.com/samueldaviddelacruz/lenslocked.com/models"
 )
 
+// User looks up the current user by the remember_token cookie
+// or, for API requests with an Authorization: Bearer header, by
+// the personal access token in it. The cookie is ignored when
+// such a header is sent. Disabled users are treated as logged
+// out.
+//
+// When the user is an admin with an impersonate cookie, the user
+// it names becomes the current user instead, and the admin is
+// kept as the impersonator.
 type User struct {
 	models.UserService
+	APITokens models.APITokenService
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -19,6 +33,10 @@ func (mw *User) Apply(next http.Handler) http.HandlerFunc {
 func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r); token != "" {
+			next(w, mw.withAPIToken(r, token))
+			return
+		}
 		cookie, err := r.Cookie("remember_token")
 		if err != nil {
 			next(w, r)
@@ -26,11 +44,19 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		user, err := mw.UserService.ByRemember(cookie.Value)
-		if err != nil {
+		if err != nil || user.Disabled() {
 			next(w, r)
 			return
 		}
+		var impersonator *models.User
+		if target := mw.impersonated(r, user); target != nil {
+			impersonator, user = user, target
+		}
+		r = withLogUser(r, user, impersonator)
 		ctx := r.Context()
+		if impersonator != nil {
+			ctx = context.WithImpersonator(ctx, impersonator)
+		}
 		ctx = context.WithUser(ctx, user)
 		r = r.WithContext(ctx)
 
@@ -39,6 +65,87 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// withAPIToken returns r with the token, and the user it belongs
+// to, in its context when the token is valid.
+func (mw *User) withAPIToken(r *http.Request, token string) *http.Request {
+	if mw.APITokens == nil {
+		return r
+	}
+	apiToken, err := mw.APITokens.ByToken(token)
+	if err != nil {
+		if err != models.ErrNotFound {
+			context.Logger(r.Context()).Error("looking up API token failed", "err", err)
+		}
+		return r
+	}
+	user, err := mw.UserService.ByID(apiToken.UserID)
+	if err != nil || user.Disabled() {
+		return r
+	}
+	r = withLogUser(r, user, nil)
+	if err := mw.APITokens.Touch(apiToken); err != nil {
+		context.Logger(r.Context()).Error("touching API token failed", "err", err)
+	}
+	ctx := context.WithUser(r.Context(), user)
+	ctx = context.WithAPIToken(ctx, apiToken)
+	return r.WithContext(ctx)
+}
+
+// impersonated returns the user named by the impersonate cookie
+// when the user is an admin, or nil. Admins cannot be
+// impersonated.
+func (mw *User) impersonated(r *http.Request, user *models.User) *models.User {
+	if !user.Admin {
+		return nil
+	}
+	cookie, err := r.Cookie("impersonate")
+	if err != nil {
+		return nil
+	}
+	id, err := strconv.ParseUint(cookie.Value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	target, err := mw.UserService.ByID(uint(id))
+	if err != nil || target.Admin {
+		return nil
+	}
+	return target
+}
+
+// bearerToken returns the token of the Authorization: Bearer
+// header of an API request, or "" when there is none.
+func bearerToken(r *http.Request) string {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return ""
+	}
+	const prefix = "bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// CSRFExempt lets API requests authenticated by a bearer token
+// through the CSRF middleware, which it must wrap. Browsers never
+// add the Authorization header on their own, so such requests
+// cannot be forged, and the session cookie is ignored for them.
+type CSRFExempt struct{}
+
+func (mw *CSRFExempt) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *CSRFExempt) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) != "" {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		next(w, r)
+	})
+}
+
 // RequireUser assumes that User middleware has already been run
 // otherwise it will not work correctly.
 type RequireUser struct {
@@ -74,3 +181,65 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 
 	})
 }
+
+// RequireAPIUser is RequireUser for the JSON API. Requests
+// without a user get a 401 with an error body rather than a
+// redirect to the login page, and requests that change anything
+// get a 403 when made with a read only token.
+type RequireAPIUser struct {
+	User
+	// ReadOnly lets read only tokens through whatever the
+	// method, for handlers that never change anything even when
+	// posted to, such as the GraphQL endpoint.
+	ReadOnly bool
+}
+
+func (mw *RequireAPIUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireAPIUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, `{"error":{"status":401,"message":"You must be logged in to use the API."}}`)
+			return
+		}
+		token := context.APIToken(r.Context())
+		if token != nil && !token.CanWrite() && !mw.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"error":{"status":403,"message":"This token may only be used to read."}}`)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdmin assumes that User middleware has already been
+// run. Users who are not admins, including admins while they
+// impersonate someone, get a 404 so the admin area is not
+// advertised.
+type RequireAdmin struct {
+	User
+}
+
+func (mw *RequireAdmin) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireAdmin) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !user.Admin {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/context"
+	"github.com/samueldaviddelacruz/lenslocked.com/models"
+)
+
+// fakeUsers looks users up by ID, and by their remember token
+// when they have one.
+type fakeUsers struct {
+	models.UserService
+	users map[uint]*models.User
+}
+
+func (f *fakeUsers) ByID(id uint) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) ByRemember(token string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Remember == token {
+			return user, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+type fakeTokens struct {
+	models.APITokenService
+	tokens map[string]*models.APIToken
+}
+
+func (f *fakeTokens) ByToken(token string) (*models.APIToken, error) {
+	apiToken, ok := f.tokens[token]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return apiToken, nil
+}
+
+func (f *fakeTokens) Touch(token *models.APIToken) error {
+	return nil
+}
+
+func testingUser() *User {
+	disabledAt := time.Now()
+	users := map[uint]*models.User{
+		1: {Remember: "admin", Admin: true},
+		2: {Remember: "alice"},
+		3: {Remember: "bob"},
+		4: {Remember: "other-admin", Admin: true},
+		5: {Remember: "disabled", DisabledAt: &disabledAt},
+	}
+	for id, user := range users {
+		user.ID = id
+	}
+	return &User{
+		UserService: &fakeUsers{users: users},
+		APITokens: &fakeTokens{tokens: map[string]*models.APIToken{
+			"alice-token":    {UserID: 2, Scope: models.ScopeRead},
+			"disabled-token": {UserID: 5, Scope: models.ScopeWrite},
+		}},
+	}
+}
+
+// serveUser runs the request through the User middleware and
+// returns the IDs of the current user and of the impersonator,
+// which are 0 when there is none.
+func serveUser(mw *User, r *http.Request) (userID, impersonatorID uint) {
+	mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		if user := context.User(r.Context()); user != nil {
+			userID = user.ID
+		}
+		if admin := context.Impersonator(r.Context()); admin != nil {
+			impersonatorID = admin.ID
+		}
+	})(httptest.NewRecorder(), r)
+	return userID, impersonatorID
+}
+
+func TestUserBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		auth   string
+		cookie string
+		want   uint
+	}{
+		{"api token", "/api/v1/me", "Bearer alice-token", "", 2},
+		{"scheme in lower case", "/api/v1/me", "bearer alice-token", "", 2},
+		{"other scheme", "/api/v1/me", "Basic alice-token", "", 0},
+		{"unknown token", "/api/v1/me", "Bearer nope", "", 0},
+		{"disabled user", "/api/v1/me", "Bearer disabled-token", "", 0},
+		{"token outside the api", "/galleries", "Bearer alice-token", "", 0},
+		{"cookie outside the api", "/galleries", "Bearer alice-token", "bob", 3},
+		{"cookie ignored with a token", "/api/v1/me", "Bearer nope", "bob", 0},
+		{"cookie without a token", "/api/v1/me", "", "bob", 3},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "remember_token", Value: test.cookie})
+		}
+		if got, _ := serveUser(testingUser(), r); got != test.want {
+			t.Errorf("%s: user = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUserImpersonate(t *testing.T) {
+	tests := []struct {
+		name            string
+		remember        string
+		impersonate     string
+		want, wantAdmin uint
+	}{
+		{"admin", "admin", "2", 2, 1},
+		{"not an admin", "alice", "3", 2, 0},
+		{"another admin", "admin", "4", 1, 0},
+		{"unknown user", "admin", "99", 1, 0},
+		{"invalid id", "admin", "bob", 1, 0},
+		{"no cookie", "admin", "", 1, 0},
+		{"disabled user", "disabled", "", 0, 0},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/galleries", nil)
+		r.AddCookie(&http.Cookie{Name: "remember_token", Value: test.remember})
+		if test.impersonate != "" {
+			r.AddCookie(&http.Cookie{Name: "impersonate", Value: test.impersonate})
+		}
+		got, gotAdmin := serveUser(testingUser(), r)
+		if got != test.want || gotAdmin != test.wantAdmin {
+			t.Errorf("%s: user = %d, impersonator = %d, want %d and %d",
+				test.name, got, gotAdmin, test.want, test.wantAdmin)
+		}
+	}
+}
+
+func TestRequireAPIUser(t *testing.T) {
+	read := &models.APIToken{Scope: models.ScopeRead}
+	write := &models.APIToken{Scope: models.ScopeWrite}
+	tests := []struct {
+		name     string
+		method   string
+		loggedIn bool
+		token    *models.APIToken
+		readOnly bool
+		want     int
+	}{
+		{"logged out", "GET", false, nil, false, http.StatusUnauthorized},
+		{"session", "POST", true, nil, false, http.StatusOK},
+		{"read token get", "GET", true, read, false, http.StatusOK},
+		{"read token head", "HEAD", true, read, false, http.StatusOK},
+		{"read token post", "POST", true, read, false, http.StatusForbidden},
+		{"read token patch", "PATCH", true, read, false, http.StatusForbidden},
+		{"read token delete", "DELETE", true, read, false, http.StatusForbidden},
+		{"read token read only handler", "POST", true, read, true, http.StatusOK},
+		{"write token post", "POST", true, write, false, http.StatusOK},
+		{"write token delete", "DELETE", true, write, false, http.StatusOK},
+	}
+	for _, test := range tests {
+		mw := RequireAPIUser{ReadOnly: test.readOnly}
+		r := httptest.NewRequest(test.method, "/api/v1/galleries", nil)
+		ctx := r.Context()
+		if test.loggedIn {
+			ctx = context.WithUser(ctx, &models.User{})
+		}
+		if test.token != nil {
+			ctx = context.WithAPIToken(ctx, test.token)
+		}
+		w := httptest.NewRecorder()
+		mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})(w, r.WithContext(ctx))
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/hash"
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+)
+
+const (
+	// ScopeRead lets a token look at everything the user can,
+	// and ScopeWrite lets it change things as well.
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// apiTokenTouchInterval is how stale the last used time of
+	// a token may get before it is saved again, so busy clients
+	// do not write to the database on every request.
+	apiTokenTouchInterval = time.Minute
+	maxAPITokenNameLen    = 100
+)
+
+// APIToken is a personal access token users create to use the
+// API from scripts and the command line. Only the HMAC of the
+// token is stored, like the remember token of a user, so Token
+// is only set right after the token is created.
+type APIToken struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Scope      string `gorm:"not null;default:'read'"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	LastUsedAt *time.Time
+}
+
+// CanWrite reports whether the token may be used for requests
+// that change anything.
+func (t *APIToken) CanWrite() bool {
+	return t.Scope == ScopeWrite
+}
+
+type APITokenService interface {
+	APITokenDB
+
+	// Touch records that the token was just used.
+	Touch(token *APIToken) error
+}
+
+func NewAPITokenService(db *gorm.DB, hmacKey string) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: &apiTokenGorm{db},
+			hmac:       hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ats *apiTokenService) Touch(token *APIToken) error {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < apiTokenTouchInterval {
+		return nil
+	}
+	token.LastUsedAt = &now
+	return ats.SetLastUsed(token.ID, now)
+}
+
+// APITokenDB is used to interact with the API tokens database.
+// Single token queries return ErrNotFound when nothing matches.
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	// ByToken looks up a token by its raw value, which is
+	// hashed before it is compared.
+	ByToken(token string) (*APIToken, error)
+	ByUserID(userID uint) ([]APIToken, error)
+	SetLastUsed(id uint, at time.Time) error
+
+	// Create sets the Token of the API token to a newly
+	// generated one, which cannot be looked up afterwards.
+	Create(token *APIToken) error
+	// Delete revokes the token for good.
+	Delete(id uint) error
+}
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac hash.HMAC
+}
+
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	apiToken := APIToken{Token: token}
+	if err := runAPITokenValFuncs(&apiToken, atv.hmacToken); err != nil {
+		return nil, err
+	}
+	if apiToken.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return atv.APITokenDB.ByToken(apiToken.TokenHash)
+}
+
+func (atv *apiTokenValidator) Create(token *APIToken) error {
+	err := runAPITokenValFuncs(token,
+		atv.userIDRequired,
+		atv.normalizeName,
+		atv.nameRequired,
+		atv.nameLength,
+		atv.scopeValid,
+		atv.setToken,
+		atv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(token)
+}
+
+func (atv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) userIDRequired(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) normalizeName(t *APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	return nil
+}
+
+func (atv *apiTokenValidator) nameRequired(t *APIToken) error {
+	if t.Name == "" {
+		return ErrTokenNameRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) nameLength(t *APIToken) error {
+	if len(t.Name) > maxAPITokenNameLen {
+		return ErrTokenNameTooLong
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) scopeValid(t *APIToken) error {
+	switch t.Scope {
+	case "":
+		t.Scope = ScopeRead
+	case ScopeRead, ScopeWrite:
+	default:
+		return ErrTokenScopeInvalid
+	}
+	return nil
+}
+
+// setToken always generates a new token, so callers cannot
+// pick their own.
+func (atv *apiTokenValidator) setToken(t *APIToken) error {
+	token, err := rand.APIToken()
+	if err != nil {
+		return err
+	}
+	t.Token = token
+	return nil
+}
+
+func (atv *apiTokenValidator) hmacToken(t *APIToken) error {
+	if t.Token == "" {
+		return nil
+	}
+	t.TokenHash = atv.hmac.Hash(t.Token)
+	return nil
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (atg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("id = ?", id), &token)
+	return &token, err
+}
+
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &token)
+	return &token, err
+}
+
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (atg *apiTokenGorm) SetLastUsed(id uint, at time.Time) error {
+	return atg.db.Model(&APIToken{}).Where("id = ?", id).
+		UpdateColumn("last_used_at", at).Error
+}
+
+func (atg *apiTokenGorm) Create(token *APIToken) error {
+	return atg.db.Create(token).Error
+}
+
+// Delete removes the token rather than soft deleting it, as a
+// revoked token is never restored.
+func (atg *apiTokenGorm) Delete(id uint) error {
+	token := APIToken{Model: gorm.Model{ID: id}}
+	return atg.db.Unscoped().Delete(&token).Error
+}
+
+type apiTokenValFunc func(*APIToken) error
+
+func runAPITokenValFuncs(token *APIToken, fns ...apiTokenValFunc) error {
+	for _, fn := range fns {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The actions recorded in the audit log.
+const (
+	AuditSignup               = "user.signup"
+	AuditLogin                = "user.login"
+	AuditLoginFailed          = "user.login.failed"
+	AuditLogout               = "user.logout"
+	AuditPasswordResetRequest = "user.password_reset.request"
+	AuditPasswordReset        = "user.password_reset.complete"
+	AuditOAuthConnect         = "oauth.connect"
+	AuditGalleryDelete        = "gallery.delete"
+	AuditImageDelete          = "image.delete"
+	AuditGalleryPurge         = "gallery.purge"
+	AuditImagePurge           = "image.purge"
+	AuditTrashEmpty           = "trash.empty"
+
+	AuditImpersonateStart = "admin.impersonate.start"
+	AuditImpersonateStop  = "admin.impersonate.stop"
+	AuditUserDisable      = "admin.user.disable"
+	AuditUserEnable       = "admin.user.enable"
+	AuditGalleryRemove    = "admin.gallery.remove"
+	AuditImageRemove      = "admin.image.remove"
+	AuditGalleryHide      = "admin.gallery.hide"
+	AuditGalleryUnhide    = "admin.gallery.unhide"
+	AuditReportResolve    = "admin.report.resolve"
+
+	// maxAuditDetailLen is how much of the free text of an
+	// event, such as the reason given for removing content,
+	// is kept.
+	maxAuditDetailLen = 1000
+)
+
+// AuditActions lists every action, in the order they are
+// offered when filtering the log.
+var AuditActions = []string{
+	AuditSignup,
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+	AuditPasswordResetRequest,
+	AuditPasswordReset,
+	AuditOAuthConnect,
+	AuditGalleryDelete,
+	AuditImageDelete,
+	AuditGalleryPurge,
+	AuditImagePurge,
+	AuditTrashEmpty,
+	AuditImpersonateStart,
+	AuditImpersonateStop,
+	AuditUserDisable,
+	AuditUserEnable,
+	AuditGalleryRemove,
+	AuditImageRemove,
+	AuditGalleryHide,
+	AuditGalleryUnhide,
+	AuditReportResolve,
+}
+
+// AuditFilter narrows down the events Search returns. Empty
+// fields match every event.
+type AuditFilter struct {
+	Action string
+	UserID uint
+	Offset int
+	Limit  int
+}
+
+// AuditEvent records something done to an account, by whom and
+// from where. Events are only ever added, never changed or
+// deleted, so unlike the other models it has no UpdatedAt or
+// DeletedAt.
+type AuditEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	// ActorID is the user who acted, which is an admin for
+	// the admin.* actions, or 0 when nobody was logged in.
+	ActorID uint `gorm:"not null;index"`
+	// UserID is the account the event concerns.
+	UserID uint   `gorm:"not null;index"`
+	Action string `gorm:"not null"`
+	// Target is what was acted on within the account, such as
+	// "gallery 12", and empty when it was the account itself.
+	Target    string
+	Detail    string `gorm:"type:text"`
+	IP        string
+	UserAgent string
+}
+
+type AuditService interface {
+	// Record adds the event to the log.
+	Record(event *AuditEvent) error
+	// ByUserID returns up to limit events about the account of
+	// the user, newest first.
+	ByUserID(userID uint, limit int) ([]AuditEvent, error)
+	// Search returns the events of every account matching the
+	// filter, newest first.
+	Search(filter AuditFilter) ([]AuditEvent, error)
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{db}
+}
+
+type auditService struct {
+	db *gorm.DB
+}
+
+func (as *auditService) Record(event *AuditEvent) error {
+	if event.ID != 0 {
+		return ErrIDInvalid
+	}
+	if event.UserID == 0 {
+		return ErrUserIDRequired
+	}
+	if runes := []rune(event.Detail); len(runes) > maxAuditDetailLen {
+		event.Detail = string(runes[:maxAuditDetailLen])
+	}
+	return as.db.Create(event).Error
+}
+
+func (as *auditService) ByUserID(userID uint, limit int) ([]AuditEvent, error) {
+	return as.Search(AuditFilter{UserID: userID, Limit: limit})
+}
+
+func (as *auditService) Search(filter AuditFilter) ([]AuditEvent, error) {
+	db := as.db.Order("created_at desc, id desc").
+		Offset(filter.Offset).
+		Limit(filter.Limit)
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.UserID != 0 {
+		db = db.Where("user_id = ?", filter.UserID)
+	}
+	var events []AuditEvent
+	if err := db.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,372 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+)
+
+// Collection groups several galleries of a user, in the order
+// chosen by the user, so they can be published together as a
+// single portfolio page.
+type Collection struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index;unique_index:idx_collections_user_id_slug"`
+	Title       string `gorm:"not null"`
+	Description string `gorm:"type:text"`
+	Slug        string `gorm:"unique_index:idx_collections_user_id_slug"`
+	Visibility  string `gorm:"not null;default:'public'"`
+	// ShareToken is used in the share link of the collection,
+	// which works regardless of its visibility.
+	ShareToken string    `gorm:"not null;unique_index"`
+	Galleries  []Gallery `gorm:"-"`
+}
+
+// CanView reports whether the user, which is nil for visitors
+// that are not logged in, is allowed to see the collection
+// through its regular URL.
+func (c *Collection) CanView(user *User) bool {
+	if c.Visibility != VisibilityPrivate {
+		return true
+	}
+	return user != nil && user.ID == c.UserID
+}
+
+// Listed reports whether the collection may be listed on its
+// owner's public pages.
+func (c *Collection) Listed() bool {
+	return c.Visibility == VisibilityPublic
+}
+
+// HasGallery reports whether the gallery with the provided ID
+// is part of the collection.
+func (c *Collection) HasGallery(id uint) bool {
+	for _, g := range c.Galleries {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionGallery is the join table between collections and
+// galleries, keeping the position of each gallery.
+type collectionGallery struct {
+	CollectionID uint `gorm:"primary_key;auto_increment:false"`
+	GalleryID    uint `gorm:"primary_key;auto_increment:false;index"`
+	Position     int  `gorm:"not null;default:0"`
+}
+
+type CollectionService interface {
+	CollectionDB
+}
+
+type collectionService struct {
+	CollectionDB
+}
+
+func NewCollectionService(db *gorm.DB) CollectionService {
+	return &collectionService{
+		CollectionDB: &collectionValidator{
+			&collectionGorm{db},
+		},
+	}
+}
+
+// CollectionDB is used to interact with the collections
+// database. Single collection queries return ErrNotFound when
+// nothing matches.
+type CollectionDB interface {
+	ByID(id uint) (*Collection, error)
+	ByUserID(userID uint) ([]Collection, error)
+	BySlug(userID uint, slug string) (*Collection, error)
+	ByShareToken(token string) (*Collection, error)
+	// SlugTaken reports whether a collection of the user other
+	// than the one with the provided ID already uses the slug.
+	SlugTaken(userID, id uint, slug string) (bool, error)
+
+	// GalleryIDs returns the IDs of the galleries in the
+	// collection, in order.
+	GalleryIDs(collectionID uint) ([]uint, error)
+	// AddGallery appends the gallery to the collection.
+	AddGallery(collectionID, galleryID uint) error
+	RemoveGallery(collectionID, galleryID uint) error
+	// ReorderGalleries sets the position of every gallery in the
+	// collection to its index in galleryIDs.
+	ReorderGalleries(collectionID uint, galleryIDs []uint) error
+
+	Create(collection *Collection) error
+	Update(collection *Collection) error
+	Delete(id uint) error
+}
+
+type collectionValidator struct {
+	CollectionDB
+}
+
+func (cv *collectionValidator) BySlug(userID uint, slug string) (*Collection, error) {
+	return cv.CollectionDB.BySlug(userID, strings.ToLower(strings.TrimSpace(slug)))
+}
+
+func (cv *collectionValidator) Create(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.normalizeSlug,
+		cv.setSlugIfUnset,
+		cv.slugFormat,
+		cv.slugIsAvail,
+		cv.visibilityValid,
+		cv.setShareTokenIfUnset)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Create(collection)
+}
+
+func (cv *collectionValidator) Update(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.normalizeSlug,
+		cv.setSlugIfUnset,
+		cv.slugFormat,
+		cv.slugIsAvail,
+		cv.visibilityValid,
+		cv.setShareTokenIfUnset)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Update(collection)
+}
+
+func (cv *collectionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return cv.CollectionDB.Delete(id)
+}
+
+func (cv *collectionValidator) AddGallery(collectionID, galleryID uint) error {
+	if collectionID <= 0 || galleryID <= 0 {
+		return ErrIDInvalid
+	}
+	return cv.CollectionDB.AddGallery(collectionID, galleryID)
+}
+
+func (cv *collectionValidator) userIDRequired(c *Collection) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (cv *collectionValidator) titleRequired(c *Collection) error {
+	if c.Title == "" {
+		return ErrTitleRequired
+	}
+	return nil
+}
+
+func (cv *collectionValidator) normalizeSlug(c *Collection) error {
+	c.Slug = strings.ToLower(strings.TrimSpace(c.Slug))
+	return nil
+}
+
+func (cv *collectionValidator) setSlugIfUnset(c *Collection) error {
+	if c.Slug != "" {
+		return nil
+	}
+	base := slugify(c.Title)
+	if base == "" {
+		base = "collection"
+	}
+	slug, err := uniqueSlug(base, maxSlugLen, func(slug string) (bool, error) {
+		return cv.SlugTaken(c.UserID, c.ID, slug)
+	})
+	if err != nil {
+		return err
+	}
+	c.Slug = slug
+	return nil
+}
+
+func (cv *collectionValidator) slugFormat(c *Collection) error {
+	if len(c.Slug) > maxSlugLen || !slugRegex.MatchString(c.Slug) {
+		return ErrSlugInvalid
+	}
+	return nil
+}
+
+func (cv *collectionValidator) slugIsAvail(c *Collection) error {
+	taken, err := cv.SlugTaken(c.UserID, c.ID, c.Slug)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+func (cv *collectionValidator) visibilityValid(c *Collection) error {
+	v, err := normalizeVisibility(c.Visibility)
+	if err != nil {
+		return err
+	}
+	c.Visibility = v
+	return nil
+}
+
+func (cv *collectionValidator) setShareTokenIfUnset(c *Collection) error {
+	if c.ShareToken != "" {
+		return nil
+	}
+	token, err := rand.ShareToken()
+	if err != nil {
+		return err
+	}
+	c.ShareToken = token
+	return nil
+}
+
+var _ CollectionDB = &collectionGorm{}
+
+type collectionGorm struct {
+	db *gorm.DB
+}
+
+func (cg *collectionGorm) ByID(id uint) (*Collection, error) {
+	var collection Collection
+	err := first(cg.db.Where("id = ?", id), &collection)
+	return &collection, err
+}
+
+func (cg *collectionGorm) ByUserID(userID uint) ([]Collection, error) {
+	var collections []Collection
+	err := cg.db.Where("user_id = ?", userID).Find(&collections).Error
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func (cg *collectionGorm) BySlug(userID uint, slug string) (*Collection, error) {
+	var collection Collection
+	db := cg.db.Where("user_id = ? AND slug = ?", userID, slug)
+	err := first(db, &collection)
+	return &collection, err
+}
+
+func (cg *collectionGorm) ByShareToken(token string) (*Collection, error) {
+	var collection Collection
+	err := first(cg.db.Where("share_token = ?", token), &collection)
+	return &collection, err
+}
+
+func (cg *collectionGorm) SlugTaken(userID, id uint, slug string) (bool, error) {
+	var count int
+	err := cg.db.Unscoped().Model(&Collection{}).
+		Where("user_id = ? AND slug = ? AND id <> ?", userID, slug, id).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (cg *collectionGorm) GalleryIDs(collectionID uint) ([]uint, error) {
+	var ids []uint
+	err := cg.db.Model(&collectionGallery{}).
+		Where("collection_id = ?", collectionID).
+		Order("position asc").
+		Pluck("gallery_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (cg *collectionGorm) AddGallery(collectionID, galleryID uint) error {
+	var pos int
+	row := cg.db.Model(&collectionGallery{}).
+		Where("collection_id = ?", collectionID).
+		Select("COALESCE(MAX(position), -1) + 1").
+		Row()
+	if err := row.Scan(&pos); err != nil {
+		return err
+	}
+	return cg.db.Create(&collectionGallery{
+		CollectionID: collectionID,
+		GalleryID:    galleryID,
+		Position:     pos,
+	}).Error
+}
+
+func (cg *collectionGorm) RemoveGallery(collectionID, galleryID uint) error {
+	return cg.db.
+		Where("collection_id = ? AND gallery_id = ?", collectionID, galleryID).
+		Delete(&collectionGallery{}).Error
+}
+
+func (cg *collectionGorm) ReorderGalleries(collectionID uint, galleryIDs []uint) error {
+	tx := cg.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for pos, id := range galleryIDs {
+		res := tx.Model(&collectionGallery{}).
+			Where("collection_id = ? AND gallery_id = ?", collectionID, id).
+			Update("position", pos)
+		if res.Error != nil {
+			tx.Rollback()
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			tx.Rollback()
+			return ErrNotFound
+		}
+	}
+	return tx.Commit().Error
+}
+
+func (cg *collectionGorm) Create(collection *Collection) error {
+	return cg.db.Create(collection).Error
+}
+
+func (cg *collectionGorm) Update(collection *Collection) error {
+	return cg.db.Save(collection).Error
+}
+
+// Delete removes the collection along with its gallery
+// memberships. The galleries themselves are left untouched.
+func (cg *collectionGorm) Delete(id uint) error {
+	tx := cg.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := tx.Where("collection_id = ?", id).Delete(&collectionGallery{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	collection := Collection{Model: gorm.Model{ID: id}}
+	if err := tx.Delete(&collection).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+type collectionValFunc func(*Collection) error
+
+func runCollectionValFuncs(collection *Collection, fns ...collectionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,6 +11,10 @@ const (
 	// is used when attempting to authenticate a user.
 	ErrPasswordIncorrect modelError = "models: incorrect password provided"
 
+	// ErrAccountDisabled is returned when a user whose account
+	// was disabled by an admin attempts to log in.
+	ErrAccountDisabled modelError = "models: your account has been disabled. Contact us if you think this is a mistake"
+
 	// ErrEmailRequired is returned when an email address is not
 	// provided when creating an user.
 	ErrEmailRequired modelError = "models: Email address is required"
@@ -31,8 +35,105 @@ const (
 	ErrPasswordRequired modelError = "models: password is required"
 	ErrTitleRequired    modelError = "models: title is required"
 
+	// ErrNoImagesSelected is returned when a bulk image action
+	// is attempted without any image.
+	ErrNoImagesSelected modelError = "models: no images were selected"
+	// ErrOrderInvalid is returned when a new order of the images
+	// of a gallery leaves some out, lists one twice, or lists
+	// images of another gallery.
+	ErrOrderInvalid modelError = "models: the new order must list every image of the gallery once. Reload the page and try again"
+
+	// ErrSizeInvalid is returned when an image is requested in a
+	// size we do not generate.
+	ErrSizeInvalid modelError = "models: size must be original or web"
+	// ErrFormatInvalid is returned when an image is requested in
+	// a format we do not convert to.
+	ErrFormatInvalid modelError = "models: format must be webp or avif"
+	// ErrImageUndecodable is returned when the web size of an
+	// image is requested but its original cannot be decoded, so
+	// it cannot be scaled down, watermarked or stripped.
+	ErrImageUndecodable modelError = "models: image cannot be displayed"
+
+	// ErrQuotaExceeded is returned when an image does not fit in
+	// the storage quota of the user's plan.
+	ErrQuotaExceeded modelError = "models: this would go over your storage quota. Delete some images, or upgrade your plan, to upload more"
+
+	// ErrUploadOffsetMismatch is returned when a chunk of a
+	// resumable upload does not start where the previous one
+	// ended.
+	ErrUploadOffsetMismatch modelError = "models: upload offset does not match the bytes received so far"
+	// ErrUploadLengthInvalid is returned when a resumable upload
+	// is created for an empty file or one over MaxUploadLength.
+	ErrUploadLengthInvalid    modelError = "models: upload length must be between 1 byte and 4 gigabytes"
+	ErrUploadFilenameRequired modelError = "models: filename is required"
+
 	ErrPwResetInvalid modelError = "models: token provided is not valid"
 
+	// ErrSlugInvalid is returned when a gallery slug contains
+	// anything but lowercase letters, numbers and dashes.
+	ErrSlugInvalid modelError = "models: slug may only contain lowercase letters, numbers and dashes"
+	// ErrSlugTaken is returned when the user already has a
+	// gallery with the provided slug.
+	ErrSlugTaken modelError = "models: slug is already used by another of your galleries"
+
+	// ErrVisibilityInvalid is returned when a visibility other
+	// than public, unlisted or private is provided.
+	ErrVisibilityInvalid modelError = "models: visibility must be public, unlisted or private"
+
+	// ErrUsernameInvalid is returned when a username is too
+	// short, too long or contains characters not allowed in URLs.
+	ErrUsernameInvalid modelError = "models: username must be 3 to 30 lowercase letters, numbers or dashes"
+	// ErrUsernameTaken is returned when an update or create is
+	// attempted with a username that is already in use.
+	ErrUsernameTaken modelError = "models: username is already taken"
+
+	// ErrBioTooLong is returned when a user bio is longer than
+	// 2000 characters.
+	ErrBioTooLong   modelError = "models: bio must be at most 2000 characters"
+	ErrThemeInvalid modelError = "models: theme must be classic, dark or minimal"
+	// ErrDuplicatePolicyInvalid is returned when a user is saved
+	// with a duplicate policy other than warn or skip.
+	ErrDuplicatePolicyInvalid modelError = "models: duplicate images must be either warned about or skipped"
+
+	// ErrWatermarkKindInvalid is returned when a watermark is
+	// saved with a kind other than none, text or logo.
+	ErrWatermarkKindInvalid     modelError = "models: watermark must be none, text or logo"
+	ErrWatermarkTextRequired    modelError = "models: watermark text is required"
+	ErrWatermarkTextTooLong     modelError = "models: watermark text must be at most 100 characters"
+	ErrWatermarkLogoRequired    modelError = "models: upload a logo to use it as your watermark"
+	ErrWatermarkLogoInvalid     modelError = "models: logo must be a png, jpg or gif image"
+	ErrWatermarkPositionInvalid modelError = "models: watermark position is not valid"
+	// ErrWatermarkOpacityInvalid and ErrWatermarkScaleInvalid
+	// are returned for percentages outside of 5 to 100.
+	ErrWatermarkOpacityInvalid modelError = "models: watermark opacity must be between 5 and 100 percent"
+	ErrWatermarkScaleInvalid   modelError = "models: watermark size must be between 5 and 100 percent of the image width"
+
+	// ErrTokenNameRequired and ErrTokenNameTooLong are returned
+	// when an API token is created without a name, or with one
+	// over 100 characters.
+	ErrTokenNameRequired modelError = "models: token name is required"
+	ErrTokenNameTooLong  modelError = "models: token name must be at most 100 characters"
+	// ErrTokenScopeInvalid is returned when an API token is
+	// created with a scope other than read or write.
+	ErrTokenScopeInvalid modelError = "models: token scope must be read or write"
+
+	// ErrWebhookURLInvalid is returned when a webhook is saved
+	// with a URL other than an absolute http or https one.
+	ErrWebhookURLInvalid     modelError = "models: webhook URL must start with http:// or https://"
+	ErrWebhookEventsRequired modelError = "models: choose at least one event for the webhook"
+	ErrWebhookEventInvalid   modelError = "models: webhook event is not valid"
+
+	// ErrReportReasonInvalid is returned when a gallery is
+	// reported without one of the reasons offered.
+	ErrReportReasonInvalid  modelError = "models: please choose why you are reporting this gallery"
+	ErrReportCommentTooLong modelError = "models: comment must be at most 2000 characters"
+	// ErrReportDuplicate is returned when the reporter already
+	// has an open report about the gallery.
+	ErrReportDuplicate modelError = "models: you already reported this gallery, and we will look into it"
+	// ErrReportStateInvalid is returned when a report is
+	// resolved as anything but actioned or dismissed.
+	ErrReportStateInvalid modelError = "models: reports can only be actioned or dismissed"
+
 	// ErrRememberTooShort is returned when a remember token is
 	// not at least 32 bytes
 	ErrRememberTooShort privateError = "models: Remember token must be at least 32 bytes"
@@ -42,11 +143,20 @@ const (
 	ErrRememberRequired privateError = "models: remember is required"
 	ErrUserIDRequired   privateError = "models: user ID is required"
 
+	// ErrGalleryIDRequired is returned when an image is created
+	// or updated without the gallery it belongs to.
+	ErrGalleryIDRequired privateError = "models: gallery ID is required"
+	ErrFilenameRequired  privateError = "models: filename is required"
+
 	// ErrIDInvalid is returned when an invalid ID is provided
 	// to a method like Delete.
 	ErrIDInvalid privateError = "models: ID provided was invalid"
 
 	ErrServiceRequired privateError = "models: service is required"
+
+	// ErrWebhookAddressPrivate is returned when a webhook URL
+	// resolves to a loopback or private address.
+	ErrWebhookAddressPrivate privateError = "models: webhook URL must resolve to a public address"
 )
 
 type modelError string
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// fileOps keeps track of the changes made to image files
+// during a bulk operation so they can be undone if the DB
+// transaction they are part of fails. Failures to undo them are
+// logged to logger.
+type fileOps struct {
+	logger *slog.Logger
+	undo   []func() error
+}
+
+// move renames src to dst, creating the directory of dst if
+// needed. Missing source files are skipped.
+func (f *fileOps) move(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	f.undo = append(f.undo, func() error {
+		return os.Rename(dst, src)
+	})
+	return nil
+}
+
+// copy copies the contents of src into a new file at dst.
+// Missing source files are skipped.
+func (f *fileOps) copy(src, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	f.undo = append(f.undo, func() error {
+		return os.Remove(dst)
+	})
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// rollback undoes the recorded changes, most recent first.
+func (f *fileOps) rollback() {
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		if err := f.undo[i](); err != nil {
+			f.logger.Error("undoing file change failed", "err", err)
+		}
+	}
+	f.undo = nil
+}
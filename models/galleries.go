@@ -1,6 +1,9 @@
 package models
 
 import (
+	"strings"
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
@@ -8,11 +11,85 @@ import (
 // see.
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not_null;index"`
-	Title  string  `gorm:"not_null"`
-	Images []Image `gorm:"-"`
+	UserID uint   `gorm:"not_null;index;unique_index:idx_galleries_user_id_slug"`
+	Title  string `gorm:"not_null"`
+	// Description is written in Markdown by the gallery owner.
+	Description string `gorm:"type:text"`
+	// Slug identifies the gallery in public URLs and is unique
+	// among the galleries of its owner.
+	Slug string `gorm:"unique_index:idx_galleries_user_id_slug"`
+	// Visibility is one of VisibilityPublic, VisibilityUnlisted
+	// or VisibilityPrivate.
+	Visibility string `gorm:"not null;default:'public'"`
+	// DownloadsDisabled hides the download as ZIP option from
+	// visitors. The owner can always download their galleries.
+	DownloadsDisabled bool `gorm:"not null;default:false"`
+	// ShowMetadata shows visitors the camera settings and
+	// capture time read from the EXIF data of each image.
+	ShowMetadata bool `gorm:"not null;default:false"`
+	// StripMetadata removes the EXIF data, including the GPS
+	// coordinates, from the files served to visitors, and hides
+	// the location of the images.
+	StripMetadata bool `gorm:"not null;default:false"`
+	// AllowOriginals lets visitors view and download the files
+	// as they were uploaded. Otherwise they only get the web
+	// size, which carries the owner's watermark.
+	AllowOriginals bool `gorm:"not null;default:false"`
+	// CoverImageID is the image shown for the gallery on the
+	// galleries index. When unset the first image is used.
+	CoverImageID uint
+	// HiddenAt is set while an admin reviews reports about the
+	// gallery, which hides it from everyone but its owner.
+	HiddenAt *time.Time
+	Images   []Image `gorm:"-"`
+}
+
+// Cover returns the image chosen as the gallery cover, falling
+// back to the first image. It returns nil for empty galleries.
+func (g *Gallery) Cover() *Image {
+	if len(g.Images) == 0 {
+		return nil
+	}
+	for i := range g.Images {
+		if g.Images[i].ID == g.CoverImageID {
+			return &g.Images[i]
+		}
+	}
+	return &g.Images[0]
 }
 
+// Hidden reports whether the gallery is hidden pending review.
+func (g *Gallery) Hidden() bool {
+	return g.HiddenAt != nil
+}
+
+// CanView reports whether the user, which is nil for visitors
+// that are not logged in, is allowed to see the gallery. Admins
+// can see every gallery, to look into reports of abuse.
+func (g *Gallery) CanView(user *User) bool {
+	if g.Visibility != VisibilityPrivate && !g.Hidden() {
+		return true
+	}
+	return user != nil && (user.ID == g.UserID || user.Admin)
+}
+
+// CanAccessOriginals reports whether the user, which is nil
+// for visitors that are not logged in, may get the original
+// files of the gallery images.
+func (g *Gallery) CanAccessOriginals(user *User) bool {
+	return g.AllowOriginals || (user != nil && user.ID == g.UserID)
+}
+
+// Listed reports whether the gallery may be listed on its
+// owner's public pages.
+func (g *Gallery) Listed() bool {
+	return g.Visibility == VisibilityPublic && !g.Hidden()
+}
+
+// ImageSplitN splits the gallery images into n buckets so they
+// can be rendered as columns. Images are distributed in the
+// order of g.Images, so reading the columns row by row follows
+// it.
 func (g *Gallery) ImageSplitN(n int) [][]Image {
 	result := make([][]Image, n)
 	for i := 0; i < n; i++ {
@@ -41,19 +118,62 @@ type GalleryService interface {
 
 type galleryService struct {
 	GalleryDB
+	hooks WebhookService
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
+func NewGalleryService(db *gorm.DB, hooks WebhookService) GalleryService {
 	return &galleryService{
 		GalleryDB: &galleryValidator{
 			&galleryGorm{db},
 		},
+		hooks: hooks,
 	}
 }
 
+func (gs *galleryService) Create(gallery *Gallery) error {
+	if err := gs.GalleryDB.Create(gallery); err != nil {
+		return err
+	}
+	gs.hooks.Trigger(gallery.UserID, EventGalleryCreated, newWebhookGallery(gallery))
+	return nil
+}
+
+func (gs *galleryService) Update(gallery *Gallery) error {
+	if err := gs.GalleryDB.Update(gallery); err != nil {
+		return err
+	}
+	gs.hooks.Trigger(gallery.UserID, EventGalleryUpdated, newWebhookGallery(gallery))
+	return nil
+}
+
+// Delete moves the gallery to the trash.
+func (gs *galleryService) Delete(id uint) error {
+	gallery, err := gs.ByID(id)
+	if err != nil {
+		return err
+	}
+	if err := gs.GalleryDB.Delete(id); err != nil {
+		return err
+	}
+	gs.hooks.Trigger(gallery.UserID, EventGalleryDeleted, newWebhookGallery(gallery))
+	return nil
+}
+
 type GalleryDB interface {
 	ByUserID(id uint) ([]Gallery, error)
+	// PageByUserID returns up to limit galleries of the user,
+	// ordered by ID and skipping the first offset of them, along
+	// with how many galleries the user has in all.
+	PageByUserID(id uint, offset, limit int) ([]Gallery, int, error)
 	ByID(id uint) (*Gallery, error)
+	// ByIDs returns the galleries with the provided IDs, in no
+	// particular order. IDs without a gallery are left out.
+	ByIDs(ids []uint) ([]Gallery, error)
+	BySlug(userID uint, slug string) (*Gallery, error)
+	// SlugTaken reports whether a gallery of the user other than
+	// the one with the provided ID, deleted or not, already uses
+	// the slug.
+	SlugTaken(userID, id uint, slug string) (bool, error)
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
 	Delete(id uint) error
@@ -63,10 +183,20 @@ type galleryValidator struct {
 	GalleryDB
 }
 
+func (gv *galleryValidator) BySlug(userID uint, slug string) (*Gallery, error) {
+	gallery := Gallery{Slug: slug}
+	if err := runGalleryValFuncs(&gallery, gv.normalizeSlug); err != nil {
+		return nil, err
+	}
+	return gv.GalleryDB.BySlug(userID, gallery.Slug)
+}
+
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 
 	err := runGalleryValFuncs(
-		gallery, gv.userIDRequired, gv.titleRequired)
+		gallery, gv.userIDRequired, gv.titleRequired,
+		gv.normalizeSlug, gv.setSlugIfUnset, gv.slugFormat, gv.slugIsAvail,
+		gv.visibilityValid)
 	if err != nil {
 		return err
 	}
@@ -76,7 +206,9 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 
 	err := runGalleryValFuncs(
-		gallery, gv.userIDRequired, gv.titleRequired)
+		gallery, gv.userIDRequired, gv.titleRequired,
+		gv.normalizeSlug, gv.setSlugIfUnset, gv.slugFormat, gv.slugIsAvail,
+		gv.visibilityValid)
 	if err != nil {
 		return err
 	}
@@ -108,6 +240,58 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+func (gv *galleryValidator) normalizeSlug(g *Gallery) error {
+	g.Slug = strings.ToLower(strings.TrimSpace(g.Slug))
+	return nil
+}
+
+// setSlugIfUnset derives a slug from the gallery title, adding
+// a number to it when the owner already has a gallery with it.
+func (gv *galleryValidator) setSlugIfUnset(g *Gallery) error {
+	if g.Slug != "" {
+		return nil
+	}
+	base := slugify(g.Title)
+	if base == "" {
+		base = "gallery"
+	}
+	slug, err := uniqueSlug(base, maxSlugLen, func(slug string) (bool, error) {
+		return gv.SlugTaken(g.UserID, g.ID, slug)
+	})
+	if err != nil {
+		return err
+	}
+	g.Slug = slug
+	return nil
+}
+
+func (gv *galleryValidator) slugFormat(g *Gallery) error {
+	if len(g.Slug) > maxSlugLen || !slugRegex.MatchString(g.Slug) {
+		return ErrSlugInvalid
+	}
+	return nil
+}
+
+func (gv *galleryValidator) slugIsAvail(g *Gallery) error {
+	taken, err := gv.SlugTaken(g.UserID, g.ID, g.Slug)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	v, err := normalizeVisibility(g.Visibility)
+	if err != nil {
+		return err
+	}
+	g.Visibility = v
+	return nil
+}
+
 var _ GalleryDB = &galleryGorm{}
 
 type galleryGorm struct {
@@ -137,9 +321,40 @@ func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, err
 
 }
+func (gg *galleryGorm) BySlug(userID uint, slug string) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("user_id = ? AND slug = ?", userID, slug)
+	err := first(db, &gallery)
+
+	return &gallery, err
+}
+
+func (gg *galleryGorm) ByIDs(ids []uint) ([]Gallery, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var galleries []Gallery
+	err := gg.db.Where("id in (?)", ids).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) SlugTaken(userID, id uint, slug string) (bool, error) {
+	var count int
+	err := gg.db.Unscoped().Model(&Gallery{}).
+		Where("user_id = ? AND slug = ? AND id <> ?", userID, slug, id).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func (gg *galleryGorm) ByUserID(id uint) ([]Gallery, error) {
 	var galleries []Gallery
-	err := gg.db.Where("user_id = ?", id).Find(&galleries).Error
+	err := gg.db.Where("user_id = ?", id).Order("id").Find(&galleries).Error
 	if err != nil {
 		return nil, err
 	}
@@ -147,6 +362,22 @@ func (gg *galleryGorm) ByUserID(id uint) ([]Gallery, error) {
 
 }
 
+func (gg *galleryGorm) PageByUserID(id uint, offset, limit int) ([]Gallery, int, error) {
+	var total int
+	err := gg.db.Model(&Gallery{}).Where("user_id = ?", id).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var galleries []Gallery
+	err = gg.db.Where("user_id = ?", id).Order("id").
+		Offset(offset).Limit(limit).
+		Find(&galleries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return galleries, total, nil
+}
+
 type galleryValFunc func(*Gallery) error
 
 func runGalleryValFuncs(gallery *Gallery, fns ...galleryValFunc) error {
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// gcMinAge is how old a file, or an image record, must be for
+// the garbage collector to consider it, so uploads in progress
+// are never mistaken for orphans.
+const gcMinAge = time.Hour
+
+// GCReport lists what the garbage collector found. Paths are the
+// files and directories that no record refers to, and Images the
+// records of images whose file, or gallery, is missing.
+type GCReport struct {
+	Paths  []string
+	Images []Image
+}
+
+// Empty reports whether nothing was found.
+func (r *GCReport) Empty() bool {
+	return len(r.Paths) == 0 && len(r.Images) == 0
+}
+
+// GCService reconciles the files in storage with the records in
+// the database. Files in a gallery directory without a record
+// are not orphans, as ImageService.ImportFiles imports them when
+// the server starts.
+type GCService interface {
+	// Collect compares storage with the database, and removes
+	// what it found when remove is true.
+	Collect(remove bool) (*GCReport, error)
+}
+
+func NewGCService(db *gorm.DB, logger *slog.Logger) GCService {
+	return &gcService{
+		db:     &gcGorm{db},
+		logger: logger,
+	}
+}
+
+type gcService struct {
+	db     gcDB
+	logger *slog.Logger
+}
+
+func (gs *gcService) Collect(remove bool) (*GCReport, error) {
+	galleries, err := gs.db.GalleryIDs()
+	if err != nil {
+		return nil, err
+	}
+	images, err := gs.db.Images()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := gs.db.UploadTokens()
+	if err != nil {
+		return nil, err
+	}
+	logos, err := gs.db.LogoUserIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	gc := collector{
+		logger:    gs.logger,
+		galleries: make(map[string]bool),
+		trashed:   make(map[string]bool),
+		files:     make(map[string]bool),
+		uploads:   make(map[string]bool),
+		logos:     make(map[string]bool),
+		before:    time.Now().Add(-gcMinAge),
+	}
+	for _, id := range galleries {
+		gc.galleries[strconv.FormatUint(uint64(id), 10)] = true
+	}
+	for _, token := range tokens {
+		gc.uploads[token] = true
+	}
+	for _, id := range logos {
+		gc.logos[strconv.FormatUint(uint64(id), 10)] = true
+	}
+	// Images are dangling when their gallery is gone, or when
+	// their file is missing from the gallery, or from the trash
+	// if they were deleted.
+	var report GCReport
+	for _, image := range images {
+		galleryID := strconv.FormatUint(uint64(image.GalleryID), 10)
+		path := image.RelativePath()
+		if image.DeletedAt != nil {
+			path = image.trashPath()
+			gc.trashed[strconv.FormatUint(uint64(image.ID), 10)] = true
+		} else {
+			gc.files[filepath.Join(galleryID, image.Filename)] = true
+		}
+		if image.CreatedAt.After(gc.before) {
+			continue
+		}
+		_, err := os.Stat(path)
+		if !gc.galleries[galleryID] || os.IsNotExist(err) {
+			report.Images = append(report.Images, image)
+		}
+	}
+
+	report.Paths = append(report.Paths, gc.dirs("images/galleries/", gc.galleries)...)
+	report.Paths = append(report.Paths, gc.variants()...)
+	report.Paths = append(report.Paths, gc.dirs("images/trash/", gc.trashed)...)
+	report.Paths = append(report.Paths, gc.dirs(uploadsPath, gc.uploads)...)
+	report.Paths = append(report.Paths, gc.dirs(watermarksPath, gc.logos)...)
+	report.Paths = append(report.Paths, gc.dirs("images/staging/", nil)...)
+	if !remove {
+		return &report, nil
+	}
+
+	for _, path := range report.Paths {
+		removeAll(gs.logger, path)
+	}
+	for _, image := range report.Images {
+		if err := gs.db.DeleteImage(&image); err != nil {
+			return &report, err
+		}
+	}
+	return &report, nil
+}
+
+// collector holds the names of the entries that records refer
+// to in each storage directory.
+type collector struct {
+	galleries map[string]bool
+	trashed   map[string]bool
+	// files holds the gallery ID and filename of the images
+	// not in the trash, joined by a slash.
+	files   map[string]bool
+	uploads map[string]bool
+	logos   map[string]bool
+	before  time.Time
+	logger  *slog.Logger
+}
+
+// dirs returns the entries of dir, older than gcMinAge, whose
+// name is not in known.
+func (gc *collector) dirs(dir string, known map[string]bool) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			gc.logger.Error("reading directory failed", "dir", dir, "err", err)
+		}
+		return nil
+	}
+	var orphans []string
+	for _, fi := range entries {
+		if known[fi.Name()] || fi.ModTime().After(gc.before) {
+			continue
+		}
+		orphans = append(orphans, filepath.Join(dir, fi.Name()))
+	}
+	return orphans
+}
+
+// variants returns the variants of images that are gone, and the
+// variant directories of galleries that are.
+func (gc *collector) variants() []string {
+	const dir = "images/variants/"
+	orphans := gc.dirs(dir, gc.galleries)
+	for id := range gc.galleries {
+		sizes, err := filepath.Glob(filepath.Join(dir, id, "*"))
+		if err != nil {
+			gc.logger.Error("listing variants failed", "gallery_id", id, "err", err)
+			continue
+		}
+		for _, size := range sizes {
+			entries, err := ioutil.ReadDir(size)
+			if err != nil {
+				continue
+			}
+			for _, fi := range entries {
+				if fi.ModTime().After(gc.before) {
+					continue
+				}
+				if !gc.files[filepath.Join(id, fi.Name())] {
+					orphans = append(orphans, filepath.Join(size, fi.Name()))
+				}
+			}
+		}
+	}
+	return orphans
+}
+
+// gcDB is used to look up what the files in storage belong to.
+type gcDB interface {
+	// GalleryIDs returns the IDs of every gallery, and Images
+	// every image, whether they are in the trash or not.
+	GalleryIDs() ([]uint, error)
+	Images() ([]Image, error)
+	UploadTokens() ([]string, error)
+	// LogoUserIDs returns the users who uploaded a watermark
+	// logo.
+	LogoUserIDs() ([]uint, error)
+	// DeleteImage deletes the record of the image for good and
+	// frees its size from the storage used by its owner.
+	DeleteImage(image *Image) error
+}
+
+type gcGorm struct {
+	db *gorm.DB
+}
+
+func (gg *gcGorm) GalleryIDs() ([]uint, error) {
+	var ids []uint
+	err := gg.db.Unscoped().Model(&Gallery{}).Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (gg *gcGorm) Images() ([]Image, error) {
+	var images []Image
+	err := gg.db.Unscoped().Order("id asc").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (gg *gcGorm) UploadTokens() ([]string, error) {
+	var tokens []string
+	err := gg.db.Unscoped().Model(&Upload{}).Pluck("token", &tokens).Error
+	return tokens, err
+}
+
+func (gg *gcGorm) LogoUserIDs() ([]uint, error) {
+	var ids []uint
+	err := gg.db.Model(&Watermark{}).Where("has_logo = ?", true).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+func (gg *gcGorm) DeleteImage(image *Image) error {
+	tg := &trashGorm{gg.db}
+	return tg.Transaction(func(db trashDB) error {
+		if err := db.PurgeImage(image.ID); err != nil {
+			return err
+		}
+		owner, err := db.Owner(image.GalleryID)
+		switch err {
+		case nil:
+			return db.AddUsage(owner.ID, -image.Size)
+		case ErrNotFound:
+			return nil
+		default:
+			return err
+		}
+	})
+}
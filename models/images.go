@@ -1,18 +1,130 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/imaging"
+	"github.com/samueldaviddelacruz/lenslocked.com/metrics"
 )
 
-// Image is NOT stored in the DB
+// Image is used to represent images stored in a Gallery.
+// The file itself lives on disk, while the DB record keeps
+// track of its position in the gallery, its caption and
+// its alt text.
 type Image struct {
-	GalleryID uint
-	Filename  string
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	Filename  string `gorm:"not null"`
+	Position  int    `gorm:"not null;default:0"`
+	Caption   string
+	AltText   string
+
+	// The fields below are read from the EXIF data of the photo
+	// when it is uploaded, and left empty when it has none.
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	FocalLength  string
+	Aperture     string
+	ExposureTime string
+	ISO          int
+	TakenAt      *time.Time
+	Latitude     *float64
+	Longitude    *float64
+
+	// Size is the size of the file in bytes, which counts
+	// towards the storage quota of the gallery owner.
+	Size int64 `gorm:"not null;default:0"`
+
+	// Checksum is the SHA-256 of the file, which identifies
+	// exact duplicates, and DHash its perceptual hash in hex,
+	// which identifies images that look alike. DHash is empty
+	// for files that cannot be decoded.
+	Checksum string `gorm:"index"`
+	DHash    string
+}
+
+// Camera returns the make and model of the camera the photo
+// was taken with, leaving out the make when the model already
+// starts with it, as in "Canon Canon EOS R5".
+func (i *Image) Camera() string {
+	if strings.HasPrefix(strings.ToLower(i.CameraModel), strings.ToLower(i.CameraMake)) {
+		return i.CameraModel
+	}
+	return strings.TrimSpace(i.CameraMake + " " + i.CameraModel)
+}
+
+// HasMetadata reports whether anything was read from the EXIF
+// data of the photo, apart from its location.
+func (i *Image) HasMetadata() bool {
+	return i.Camera() != "" || i.LensModel != "" || i.FocalLength != "" ||
+		i.Aperture != "" || i.ExposureTime != "" || i.ISO > 0 || i.TakenAt != nil
+}
+
+// HasLocation reports whether the GPS coordinates of where the
+// photo was taken are known.
+func (i *Image) HasLocation() bool {
+	return i.Latitude != nil && i.Longitude != nil
+}
+
+// Location returns the GPS coordinates of the image, or an
+// empty string when they are not known.
+func (i *Image) Location() string {
+	if !i.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", *i.Latitude, *i.Longitude)
+}
+
+// setMetadata replaces the EXIF fields of the image with the
+// ones of m.
+func (i *Image) setMetadata(m *imaging.Metadata) {
+	i.CameraMake = m.CameraMake
+	i.CameraModel = m.CameraModel
+	i.LensModel = m.LensModel
+	i.FocalLength = m.FocalLength
+	i.Aperture = m.Aperture
+	i.ExposureTime = m.ExposureTime
+	i.ISO = m.ISO
+	i.TakenAt = nil
+	if !m.TakenAt.IsZero() {
+		takenAt := m.TakenAt
+		i.TakenAt = &takenAt
+	}
+	i.Latitude, i.Longitude = nil, nil
+	if m.HasLocation {
+		lat, long := m.Latitude, m.Longitude
+		i.Latitude, i.Longitude = &lat, &long
+	}
+}
+
+// SortByTakenAt sorts the images by the time they were taken,
+// oldest first. Images without a capture time are moved to the
+// end, and otherwise keep their order.
+func SortByTakenAt(images []Image) {
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i].TakenAt, images[j].TakenAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
 }
 
 func (i *Image) Path() string {
@@ -22,70 +134,949 @@ func (i *Image) Path() string {
 	return pathUrl.String()
 }
 func (i *Image) RelativePath() string {
-	return fmt.Sprintf("images/galleries/%v/%v", i.GalleryID, i.Filename)
+	return galleryDir(i.GalleryID) + i.Filename
+}
+
+// galleryDir returns the directory holding the images of a
+// gallery.
+func galleryDir(galleryID uint) string {
+	return fmt.Sprintf("images/galleries/%v/", galleryID)
+}
+
+const (
+	// SizeOriginal is the file exactly as it was uploaded.
+	SizeOriginal = "original"
+	// SizeWeb is a copy scaled down to fit imaging.WebSize,
+	// generated the first time it is requested.
+	SizeWeb = "web"
+
+	// variantStripped is the original without its metadata.
+	variantStripped = "stripped"
+)
+
+// webFormats are the formats the web size is also encoded in,
+// for browsers that support them. Keys are the format given to
+// imaging.Encode and values the names of the variants.
+var webFormats = map[string]string{
+	imaging.FormatWebP: "web-webp",
+	imaging.FormatAVIF: "web-avif",
+}
+
+// variantPath returns where the provided size of the image is
+// cached. Variants are kept outside of the gallery directory
+// so they are never mistaken for uploaded images.
+func (i *Image) variantPath(size string) string {
+	return variantDir(i.GalleryID, size) + i.Filename
+}
+
+// variantDir returns the directory holding the provided size of
+// the images of a gallery.
+func variantDir(galleryID uint, size string) string {
+	return fmt.Sprintf("images/variants/%v/%v/", galleryID, size)
 }
 
 type ImageService interface {
 	Create(galleryID uint, r io.ReadCloser, filename string) error
+	// ByGalleryID returns the images of a gallery sorted by
+	// their stored position.
 	ByGalleryID(galleryID uint) ([]Image, error)
+	// ByGalleryIDs returns the images of every gallery with the
+	// provided IDs, keyed by gallery ID, with a single query.
+	ByGalleryIDs(galleryIDs []uint) (map[uint][]Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
+	// Update persists the caption and alt text of an image.
+	Update(i *Image) error
+	// Reorder sets the position of every image in the gallery
+	// to its index in ids, which must list each of them once.
+	Reorder(galleryID uint, ids []uint) error
 	Delete(i *Image) error
+
+	// DeleteMany, MoveMany, CopyMany and SetCaptions act on the
+	// images of the gallery with the provided IDs. The records
+	// are changed in a single transaction, and the changes made
+	// to the files are undone if anything fails.
+	DeleteMany(galleryID uint, ids []uint) error
+	// MoveMany and CopyMany append the images to the destination
+	// gallery, renaming files that clash with existing ones.
+	MoveMany(galleryID uint, ids []uint, dstGalleryID uint) error
+	CopyMany(galleryID uint, ids []uint, dstGalleryID uint) error
+	SetCaptions(galleryID uint, ids []uint, caption string) error
+
+	// Similar returns the images of the user that are identical
+	// or look alike, in groups of two or more.
+	Similar(userID uint) ([]ImageGroup, error)
+
+	// Open returns the contents of the image in the provided
+	// size, either SizeOriginal or SizeWeb.
+	Open(i *Image, size string) (io.ReadCloser, error)
+	// OpenWebAs returns the web size of the image encoded in
+	// imaging.FormatWebP or imaging.FormatAVIF.
+	OpenWebAs(i *Image, format string) (io.ReadCloser, error)
+	// OpenStripped returns the original file without the EXIF
+	// and other metadata it carries.
+	OpenStripped(i *Image) (io.ReadCloser, error)
+	// RemoveWebVariants deletes the web size of every image of
+	// the gallery, so it is generated again with the current
+	// watermark of its owner.
+	RemoveWebVariants(galleryID uint) error
+	// RecountUsage sets the storage used by every user to the
+	// sum of the sizes of their images, correcting any drift in
+	// the running totals kept as images come and go.
+	RecountUsage() error
+	// ImportFiles creates a record for every file in a gallery
+	// directory that does not have one yet, as images uploaded
+	// before they were stored in the DB only exist on disk. It
+	// returns how many it imported.
+	ImportFiles() (int, error)
 }
 
-func NewImageService() ImageService {
+func NewImageService(db *gorm.DB, ws WatermarkService, quotas Quotas, hooks WebhookService, logger *slog.Logger) ImageService {
 
-	return &imageService{}
+	return &imageService{
+		db:         &imageValidator{&imageGorm{db}},
+		watermarks: ws,
+		quotas:     quotas,
+		hooks:      hooks,
+		logger:     logger,
+		encoding:   make(chan struct{}, runtime.NumCPU()),
+	}
 }
 
 type imageService struct {
+	db         imageDB
+	watermarks WatermarkService
+	quotas     Quotas
+	hooks      WebhookService
+	logger     *slog.Logger
+
+	// variants makes concurrent requests for a missing variant
+	// wait for a single one of them to generate it, and encoding
+	// limits how many variants are generated at once to the
+	// number of CPUs, as encoding AVIF in particular is slow.
+	variants singleflight.Group
+	encoding chan struct{}
+}
+
+// DuplicateError is returned by Create when the uploaded file
+// is identical to an image the owner of the gallery already
+// has. Unless Skipped is true, the image was added anyway.
+type DuplicateError struct {
+	Filename string
+	Existing Image
+	Skipped  bool
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("models: %s is identical to image %d", e.Filename, e.Existing.ID)
 }
 
+// Public is the message shown to the user.
+func (e *DuplicateError) Public() string {
+	msg := fmt.Sprintf("%s is identical to %s, which you already uploaded", e.Filename, e.Existing.Filename)
+	if e.Skipped {
+		return msg + ", so it was skipped."
+	}
+	return msg + "."
+}
+
+// Create adds the file to the gallery. It returns
+// ErrQuotaExceeded, without changing anything, when the file
+// does not fit in the storage quota of the gallery owner, which
+// is checked and reserved in a single update so concurrent
+// uploads cannot go over it together, and
+// a *DuplicateError when the file is identical to an image in
+// one of their galleries, in which case the image is only
+// added if their DuplicatePolicy is DuplicatesWarn.
 func (is *imageService) Create(galleryID uint, r io.ReadCloser, filename string) error {
 	defer r.Close()
 	path, err := is.mkImagePath(galleryID)
 	if err != nil {
 		return err
 	}
+	owner, err := is.db.Owner(galleryID)
+	if err != nil {
+		return err
+	}
+	// Uploading a file with an existing name replaces the file
+	// but keeps its record, so the position and caption stay.
+	existing, err := is.db.ByFilename(galleryID, filename)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		existing = nil
+	default:
+		return err
+	}
 
-	// Create a destination file
-	dst, err := os.Create(path + filename)
+	// The file is written aside while computing its checksum,
+	// so a skipped duplicate, or a file going over the quota,
+	// never replaces anything. Only one byte more than the room
+	// left is read to find out the file does not fit.
+	tmp, err := ioutil.TempFile(is.stagingPath(), "upload")
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
+	defer os.Remove(tmp.Name())
+	src := io.Reader(r)
+	room, limited := is.room(owner, existing)
+	if limited {
+		src = io.LimitReader(r, room+1)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if limited && size > room {
+		return ErrQuotaExceeded
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+	dupErr, err := is.duplicateOf(owner, galleryID, filename, checksum)
+	if err != nil {
+		return err
+	}
+	if dupErr != nil && dupErr.Skipped {
+		return dupErr
+	}
+	usage := size
+	if existing != nil {
+		usage -= existing.Size
+	}
+	if err := is.reserve(owner, usage); err != nil {
+		return err
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			is.release(owner, usage)
+		}
+	}()
+	if err := os.Rename(tmp.Name(), path+filename); err != nil {
+		return err
+	}
+	is.removeVariants(&Image{GalleryID: galleryID, Filename: filename})
+	is.warmWebVariant(&Image{GalleryID: galleryID, Filename: filename})
+
+	image := existing
+	if existing != nil {
+		is.readFile(existing)
+		err = is.db.Update(existing)
+	} else {
+		image, err = is.createRecord(galleryID, filename)
+	}
+	if err != nil {
+		return err
+	}
+	stored = true
+	metrics.ObserveUpload(size)
+	is.hooks.Trigger(owner.ID, EventImageUploaded, newWebhookImage(image))
+	// A nil *DuplicateError must not be returned as a non-nil
+	// error.
+	if dupErr != nil {
+		return dupErr
+	}
+	return nil
+}
+
+// createRecord appends the image with the provided file to the
+// end of the gallery.
+func (is *imageService) createRecord(galleryID uint, filename string) (*Image, error) {
+	pos, err := is.db.NextPosition(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	image := Image{
+		GalleryID: galleryID,
+		Filename:  filename,
+		Position:  pos,
+	}
+	is.readFile(&image)
+	if err := is.db.Create(&image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
 
-	// Copy reader data to the destination file
-	_, err = io.Copy(dst, r)
+// room returns how many more bytes the user may store, counting
+// the space the replaced image, if any, frees. It returns false
+// when the plan of the user has no quota.
+func (is *imageService) room(user *User, replaced *Image) (int64, bool) {
+	quota := is.quotas.For(user)
+	if quota <= 0 {
+		return 0, false
+	}
+	room := quota - user.StorageUsed
+	if replaced != nil {
+		room += replaced.Size
+	}
+	if room < 0 {
+		room = 0
+	}
+	return room, true
+}
+
+// reserve adds usage bytes to the storage used by the user, or
+// returns ErrQuotaExceeded when that would take them over their
+// quota. Checking the quota in the update itself, rather than
+// against the usage we loaded, keeps concurrent uploads from
+// all fitting in the same room.
+func (is *imageService) reserve(user *User, usage int64) error {
+	quota := is.quotas.For(user)
+	if quota <= 0 || usage <= 0 {
+		return is.db.AddUsage(user.ID, usage)
+	}
+	reserved, err := is.db.ReserveUsage(user.ID, usage, quota)
 	if err != nil {
 		return err
 	}
+	if !reserved {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// release gives back the usage reserved for files that were not
+// stored in the end.
+func (is *imageService) release(user *User, usage int64) {
+	if err := is.db.AddUsage(user.ID, -usage); err != nil {
+		is.logger.Error("releasing storage usage failed", "user_id", user.ID, "err", err)
+	}
+}
+
+// duplicateOf looks for an image of the gallery owner with the
+// provided checksum, other than the one the file would replace.
+// It returns nil when there is none.
+func (is *imageService) duplicateOf(owner *User, galleryID uint, filename, checksum string) (*DuplicateError, error) {
+	images, err := is.db.ByChecksum(owner.ID, checksum)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if image.GalleryID == galleryID && image.Filename == filename {
+			continue
+		}
+		return &DuplicateError{
+			Filename: filename,
+			Existing: image,
+			Skipped:  owner.DuplicatePolicy == DuplicatesSkip,
+		}, nil
+	}
+	return nil, nil
+}
+
+// readFile sets the fields of the image read from its file,
+// such as its EXIF data and its hashes.
+func (is *imageService) readFile(image *Image) {
+	is.readMetadata(image)
+	if err := is.fingerprint(image); err != nil {
+		is.logger.Error("fingerprinting image failed", "path", image.RelativePath(), "err", err)
+	}
+}
 
+// fingerprint sets the size, the checksum and the perceptual
+// hash of the image from its file.
+func (is *imageService) fingerprint(image *Image) error {
+	defer metrics.ObserveImageProcessing("fingerprint", time.Now())
+	f, err := os.Open(image.RelativePath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	image.Size = size
+	image.Checksum = hex.EncodeToString(h.Sum(nil))
+	image.DHash = ""
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if img, _, err := imaging.DecodeOriented(f); err == nil {
+		image.DHash = fmt.Sprintf("%016x", imaging.DHash(img))
+	}
 	return nil
 }
+
+// readMetadata sets the EXIF fields of the image from its file.
+// Files without EXIF data leave the fields empty.
+func (is *imageService) readMetadata(image *Image) {
+	defer metrics.ObserveImageProcessing("metadata", time.Now())
+	var m imaging.Metadata
+	f, err := os.Open(image.RelativePath())
+	if err != nil {
+		is.logger.Error("reading image metadata failed", "path", image.RelativePath(), "err", err)
+		return
+	}
+	defer f.Close()
+	if read, err := imaging.ReadMetadata(f); err == nil {
+		m = *read
+	}
+	image.setMetadata(&m)
+}
+
+// Delete moves the image to the trash, where it stays for
+// TrashRetention before it is purged.
 func (is *imageService) Delete(image *Image) error {
+	files := fileOps{logger: is.logger}
+	if err := files.move(image.RelativePath(), image.trashPath()); err != nil {
+		return err
+	}
+	if err := is.db.Delete(image.ID); err != nil {
+		files.rollback()
+		return err
+	}
+	is.removeVariants(image)
+	is.triggerDeleted(image.GalleryID, *image)
+	return nil
+}
 
-	return os.Remove(image.RelativePath())
+// triggerDeleted sends the image.deleted event for the images
+// of the gallery.
+func (is *imageService) triggerDeleted(galleryID uint, images ...Image) {
+	owner, err := is.db.Owner(galleryID)
+	if err != nil {
+		is.logger.Error("loading gallery owner failed", "gallery_id", galleryID, "err", err)
+		return
+	}
+	for i := range images {
+		is.hooks.Trigger(owner.ID, EventImageDeleted, newWebhookImage(&images[i]))
+	}
+}
+
+func (is *imageService) Open(image *Image, size string) (io.ReadCloser, error) {
+	switch size {
+	case SizeOriginal:
+		return os.Open(image.RelativePath())
+	case SizeWeb:
+		return is.openVariant(image, SizeWeb, is.webVariant(image, ""))
+	}
+	return nil, ErrSizeInvalid
+}
+
+func (is *imageService) OpenWebAs(image *Image, format string) (io.ReadCloser, error) {
+	name, ok := webFormats[format]
+	if !ok {
+		return nil, ErrFormatInvalid
+	}
+	return is.openVariant(image, name, is.webVariant(image, format))
+}
+
+// warmWebVariant generates the web size of a new upload right
+// away, so it is upright and in a format browsers can display
+// by the time it is first shown. Failures are only logged as
+// the variant is generated again when requested, and files we
+// cannot decode are not an error until someone views them.
+func (is *imageService) warmWebVariant(image *Image) {
+	rc, err := is.Open(image, SizeWeb)
+	if err == ErrImageUndecodable {
+		return
+	}
+	if err != nil {
+		is.logger.Error("generating web size failed", "path", image.RelativePath(), "err", err)
+		return
+	}
+	rc.Close()
+}
+
+func (is *imageService) OpenStripped(image *Image) (io.ReadCloser, error) {
+	return is.openVariant(image, variantStripped, strippedVariant)
+}
+
+// variantFunc writes a variant of the original image in src
+// to dst.
+type variantFunc func(dst io.Writer, src *os.File) error
+
+// openVariant opens the cached variant of the image, generating
+// it with write the first time it is requested.
+func (is *imageService) openVariant(image *Image, name string, write variantFunc) (io.ReadCloser, error) {
+	path := image.variantPath(name)
+	f, err := os.Open(path)
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	_, err, _ = is.variants.Do(path, func() (interface{}, error) {
+		is.encoding <- struct{}{}
+		defer func() { <-is.encoding }()
+		// The variant may have been written while we waited.
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil
+		}
+		start := time.Now()
+		err := is.writeVariant(image, path, write)
+		metrics.ObserveImageProcessing(name, start)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (is *imageService) writeVariant(image *Image, path string, write variantFunc) error {
+	src, err := os.Open(image.RelativePath())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so concurrent requests
+	// never read a half written variant.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// webVariant returns the variantFunc that scales the original
+// image down, turns it upright and draws the watermark of the
+// gallery owner over it. It is encoded in format, or when that
+// is empty converted to one every browser can display, such as
+// JPEG for HEIC photos. Files that cannot be decoded return
+// ErrImageUndecodable rather than being copied, as that would
+// serve the original with its metadata and no watermark.
+func (is *imageService) webVariant(image *Image, format string) variantFunc {
+	return func(dst io.Writer, src *os.File) error {
+		img, srcFormat, err := imaging.DecodeOriented(src)
+		if err != nil {
+			return ErrImageUndecodable
+		}
+		img = imaging.Fit(img, imaging.WebSize)
+		mark, err := is.watermarks.ForGallery(image.GalleryID)
+		if err != nil {
+			return err
+		}
+		if mark != nil {
+			if img, err = mark.Apply(img); err != nil {
+				return err
+			}
+		}
+		if format == "" {
+			format = imaging.DisplayFormat(srcFormat)
+		}
+		return imaging.Encode(dst, img, format)
+	}
+}
+
+// strippedVariant removes the metadata from the original image.
+// Images that would no longer be upright without their EXIF
+// orientation, and formats we cannot strip, are decoded and
+// encoded again, which drops anything but the pixels.
+func strippedVariant(dst io.Writer, src *os.File) error {
+	orientation := imaging.Orientation(src)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if orientation == 1 {
+		err := imaging.StripMetadata(dst, src)
+		if err != imaging.ErrUnsupportedFormat {
+			return err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	img, format, err := imaging.DecodeOriented(src)
+	if err != nil {
+		return err
+	}
+	return imaging.Encode(dst, img, imaging.DisplayFormat(format))
+}
+
+// removeVariants deletes the cached variants of the image so
+// they are generated again from the current original.
+func (is *imageService) removeVariants(image *Image) {
+	names := []string{SizeWeb, variantStripped}
+	for _, name := range webFormats {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		err := os.Remove(image.variantPath(name))
+		if err != nil && !os.IsNotExist(err) {
+			is.logger.Error("removing variant failed", "path", image.variantPath(name), "err", err)
+		}
+	}
+}
+
+func (is *imageService) RemoveWebVariants(galleryID uint) error {
+	names := []string{SizeWeb}
+	for _, name := range webFormats {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if err := os.RemoveAll(variantDir(galleryID, name)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
-	gallerypath := is.galleryPath(galleryID)
-	imagesPaths, err := filepath.Glob(gallerypath + "*")
+	return is.db.ByGalleryID(galleryID)
+}
+
+func (is *imageService) ByGalleryIDs(galleryIDs []uint) (map[uint][]Image, error) {
+	return is.db.ByGalleryIDs(galleryIDs)
+}
+
+func (is *imageService) ByFilename(galleryID uint, filename string) (*Image, error) {
+	return is.db.ByFilename(galleryID, filename)
+}
+
+func (is *imageService) Update(image *Image) error {
+	return is.db.Update(image)
+}
+
+func (is *imageService) Reorder(galleryID uint, ids []uint) error {
+	images, err := is.db.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(images) {
+		return ErrOrderInvalid
+	}
+	unlisted := make(map[uint]bool, len(images))
+	for _, image := range images {
+		unlisted[image.ID] = true
+	}
+	for _, id := range ids {
+		if !unlisted[id] {
+			return ErrOrderInvalid
+		}
+		delete(unlisted, id)
+	}
+	return is.db.Reorder(galleryID, ids)
+}
+
+func (is *imageService) DeleteMany(galleryID uint, ids []uint) error {
+	images, err := is.byIDs(galleryID, ids)
+	if err != nil {
+		return err
+	}
+	files := fileOps{logger: is.logger}
+	err = is.db.Transaction(func(db imageDB) error {
+		for _, image := range images {
+			if err := db.Delete(image.ID); err != nil {
+				return err
+			}
+			if err := files.move(image.RelativePath(), image.trashPath()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		files.rollback()
+		return err
+	}
+	for i := range images {
+		is.removeVariants(&images[i])
+	}
+	is.triggerDeleted(galleryID, images...)
+	return nil
+}
+
+func (is *imageService) MoveMany(galleryID uint, ids []uint, dstGalleryID uint) error {
+	return is.transfer(galleryID, ids, dstGalleryID, false)
+}
+
+func (is *imageService) CopyMany(galleryID uint, ids []uint, dstGalleryID uint) error {
+	return is.transfer(galleryID, ids, dstGalleryID, true)
+}
+
+// transfer moves, or copies when keep is true, the images to
+// the end of the destination gallery. Copies count towards the
+// storage quota of the owner of the destination gallery.
+func (is *imageService) transfer(galleryID uint, ids []uint, dstGalleryID uint, keep bool) error {
+	if dstGalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	if dstGalleryID == galleryID && !keep {
+		return nil
+	}
+	images, err := is.byIDs(galleryID, ids)
+	if err != nil {
+		return err
+	}
+	if _, err := is.mkImagePath(dstGalleryID); err != nil {
+		return err
+	}
+	owner, err := is.db.Owner(dstGalleryID)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, image := range images {
+		size += image.Size
+	}
+	if keep {
+		if err := is.reserve(owner, size); err != nil {
+			return err
+		}
+	}
+
+	files := fileOps{logger: is.logger}
+	err = is.db.Transaction(func(db imageDB) error {
+		pos, err := db.NextPosition(dstGalleryID)
+		if err != nil {
+			return err
+		}
+		for i, image := range images {
+			src := image.RelativePath()
+			if !keep {
+				is.removeVariants(&image)
+			}
+			image.GalleryID = dstGalleryID
+			image.Filename = freeFilename(dstGalleryID, image.Filename)
+			image.Position = pos + i
+			if keep {
+				image.Model = gorm.Model{}
+				err = db.Create(&image)
+			} else {
+				err = db.Update(&image)
+			}
+			if err != nil {
+				return err
+			}
+			if keep {
+				err = files.copy(src, image.RelativePath())
+			} else {
+				err = files.move(src, image.RelativePath())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		files.rollback()
+		if keep {
+			is.release(owner, size)
+		}
+	}
+	return err
+}
+
+func (is *imageService) SetCaptions(galleryID uint, ids []uint, caption string) error {
+	images, err := is.byIDs(galleryID, ids)
+	if err != nil {
+		return err
+	}
+	return is.db.Transaction(func(db imageDB) error {
+		for _, image := range images {
+			image.Caption = caption
+			if err := db.Update(&image); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// maxSimilarDistance is the most bits the perceptual hashes of
+// two images may differ by for them to be reported as similar.
+const maxSimilarDistance = 10
+
+// ImageGroup is a set of images that are identical or look
+// alike.
+type ImageGroup []Image
+
+// Identical reports whether every image of the group is the
+// same file.
+func (g ImageGroup) Identical() bool {
+	for _, image := range g {
+		if image.Checksum != g[0].Checksum {
+			return false
+		}
+	}
+	return true
+}
+
+// Similar compares every pair of images of the user, which is
+// fine for the few thousand images a user has. The hashes of
+// images uploaded before they were kept are computed first.
+func (is *imageService) Similar(userID uint) ([]ImageGroup, error) {
+	images, err := is.db.ByUserID(userID)
 	if err != nil {
 		return nil, err
 	}
-	result := make([]Image, len(imagesPaths))
-	for i := range imagesPaths {
+	hashes := make([]uint64, len(images))
+	hashed := make([]bool, len(images))
+	for i := range images {
+		image := &images[i]
+		if image.Checksum == "" {
+			if err := is.fingerprint(image); err != nil {
+				is.logger.Error("fingerprinting image failed", "path", image.RelativePath(), "err", err)
+				continue
+			}
+			if err := is.db.Update(image); err != nil {
+				return nil, err
+			}
+		}
+		if hash, err := strconv.ParseUint(image.DHash, 16, 64); err == nil {
+			hashes[i], hashed[i] = hash, true
+		}
+	}
+
+	// Images are grouped with a union-find over the pairs found
+	// to be identical or similar.
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			identical := images[i].Checksum != "" && images[i].Checksum == images[j].Checksum
+			similar := hashed[i] && hashed[j] &&
+				imaging.Distance(hashes[i], hashes[j]) <= maxSimilarDistance
+			if identical || similar {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
 
-		imagesPaths[i] = strings.Replace(imagesPaths[i], gallerypath, "", 1)
-		result[i] = Image{
-			Filename:  imagesPaths[i],
-			GalleryID: galleryID,
+	byRoot := make(map[int]int)
+	var groups []ImageGroup
+	for i, image := range images {
+		r := root(i)
+		g, ok := byRoot[r]
+		if !ok {
+			g = len(groups)
+			byRoot[r] = g
+			groups = append(groups, nil)
 		}
+		groups[g] = append(groups[g], image)
 	}
-	return result, nil
+	similar := groups[:0]
+	for _, g := range groups {
+		if len(g) > 1 {
+			similar = append(similar, g)
+		}
+	}
+	return similar, nil
 }
 
-func (is imageService) galleryPath(galleryID uint) string {
-	return fmt.Sprintf("images/galleries/%v/", galleryID)
+// RecountUsage first reads the size of the images uploaded
+// before sizes were kept.
+func (is *imageService) RecountUsage() error {
+	images, err := is.db.Unsized()
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		image := &images[i]
+		if err := is.fingerprint(image); err != nil {
+			is.logger.Error("fingerprinting image failed", "path", image.RelativePath(), "err", err)
+			continue
+		}
+		if err := is.db.Update(image); err != nil {
+			return err
+		}
+	}
+	return is.db.RecountUsage()
+}
+
+// byIDs returns the images of the gallery with the provided
+// IDs, or ErrNotFound if any of them is not part of it.
+func (is *imageService) byIDs(galleryID uint, ids []uint) ([]Image, error) {
+	if len(ids) == 0 {
+		return nil, ErrNoImagesSelected
+	}
+	images, err := is.db.ByIDs(galleryID, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) != len(ids) {
+		return nil, ErrNotFound
+	}
+	return images, nil
+}
+
+// freeFilename returns filename, or filename with a number
+// added before its extension, such that no file with that name
+// exists in the gallery yet.
+func freeFilename(galleryID uint, filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	name := filename
+	for i := 2; ; i++ {
+		_, err := os.Stat(galleryDir(galleryID) + name)
+		if os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// ImportFiles appends the files without a record to the end of
+// their gallery, in glob order. The records of every gallery are
+// read at once, so only the files to import cost a query. It is
+// run at startup, and never while serving requests.
+func (is *imageService) ImportFiles() (int, error) {
+	known, err := is.db.Filenames()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for galleryID, filenames := range known {
+		gallerypath := is.galleryPath(galleryID)
+		imagesPaths, err := filepath.Glob(gallerypath + "*")
+		if err != nil {
+			return n, err
+		}
+		for _, imagePath := range imagesPaths {
+			filename := strings.Replace(imagePath, gallerypath, "", 1)
+			if filenames[filename] {
+				continue
+			}
+			if _, err := is.createRecord(galleryID, filename); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (is *imageService) galleryPath(galleryID uint) string {
+	return galleryDir(galleryID)
+}
+
+// stagingPath returns the directory where files are kept while
+// a bulk operation is in progress, creating it if needed.
+func (is *imageService) stagingPath() string {
+	path := "images/staging/"
+	if err := os.MkdirAll(path, 0755); err != nil {
+		is.logger.Error("creating staging directory failed", "err", err)
+	}
+	return path
 }
 
 func (is *imageService) mkImagePath(galleryID uint) (string, error) {
@@ -97,3 +1088,329 @@ func (is *imageService) mkImagePath(galleryID uint) (string, error) {
 
 	return galleryPath, nil
 }
+
+type imageDB interface {
+	ByGalleryID(galleryID uint) ([]Image, error)
+	ByGalleryIDs(galleryIDs []uint) (map[uint][]Image, error)
+	// ByUserID and ByChecksum look for images in every gallery
+	// of the user.
+	ByUserID(userID uint) ([]Image, error)
+	ByChecksum(userID uint, checksum string) ([]Image, error)
+	// Owner returns the user the gallery belongs to.
+	Owner(galleryID uint) (*User, error)
+	// Unsized returns the images whose size was never read.
+	Unsized() ([]Image, error)
+	ByIDs(galleryID uint, ids []uint) ([]Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
+	NextPosition(galleryID uint) (int, error)
+	Create(image *Image) error
+	Update(image *Image) error
+	Reorder(galleryID uint, ids []uint) error
+	Delete(id uint) error
+	// Filenames returns the filenames of the images of every
+	// gallery, keyed by gallery ID. Galleries without images are
+	// included with no filenames.
+	Filenames() (map[uint]map[string]bool, error)
+	// AddUsage adds delta bytes to the storage used by the user,
+	// and RecountUsage sets it to the sum of the sizes of their
+	// images for every user.
+	AddUsage(userID uint, delta int64) error
+	// ReserveUsage adds delta bytes to the storage used by the
+	// user only if that keeps it within quota, and reports
+	// whether it did.
+	ReserveUsage(userID uint, delta, quota int64) (bool, error)
+	RecountUsage() error
+	// Transaction calls fn with an imageDB whose changes are all
+	// committed when fn returns nil, and rolled back otherwise.
+	Transaction(fn func(db imageDB) error) error
+}
+
+type imageValidator struct {
+	imageDB
+}
+
+func (iv *imageValidator) Create(image *Image) error {
+	err := runImageValFuncs(image,
+		iv.galleryIDRequired,
+		iv.filenameRequired)
+	if err != nil {
+		return err
+	}
+	return iv.imageDB.Create(image)
+}
+
+func (iv *imageValidator) Update(image *Image) error {
+	err := runImageValFuncs(image,
+		iv.galleryIDRequired,
+		iv.filenameRequired,
+		iv.trimText)
+	if err != nil {
+		return err
+	}
+	return iv.imageDB.Update(image)
+}
+
+func (iv *imageValidator) Transaction(fn func(db imageDB) error) error {
+	return iv.imageDB.Transaction(func(db imageDB) error {
+		return fn(&imageValidator{db})
+	})
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return iv.imageDB.Delete(id)
+}
+
+func (iv *imageValidator) galleryIDRequired(i *Image) error {
+	if i.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (iv *imageValidator) filenameRequired(i *Image) error {
+	if i.Filename == "" {
+		return ErrFilenameRequired
+	}
+	return nil
+}
+
+func (iv *imageValidator) trimText(i *Image) error {
+	i.Caption = strings.TrimSpace(i.Caption)
+	i.AltText = strings.TrimSpace(i.AltText)
+	return nil
+}
+
+var _ imageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ?", galleryID).
+		Order("position asc, id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) ByGalleryIDs(galleryIDs []uint) (map[uint][]Image, error) {
+	byGallery := make(map[uint][]Image, len(galleryIDs))
+	if len(galleryIDs) == 0 {
+		return byGallery, nil
+	}
+	var images []Image
+	err := ig.db.Where("gallery_id in (?)", galleryIDs).
+		Order("gallery_id asc, position asc, id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		byGallery[image.GalleryID] = append(byGallery[image.GalleryID], image)
+	}
+	return byGallery, nil
+}
+
+func (ig *imageGorm) Filenames() (map[uint]map[string]bool, error) {
+	var galleryIDs []uint
+	if err := ig.db.Model(&Gallery{}).Pluck("id", &galleryIDs).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[uint]map[string]bool, len(galleryIDs))
+	for _, id := range galleryIDs {
+		known[id] = make(map[string]bool)
+	}
+	rows, err := ig.db.Model(&Image{}).Select("gallery_id, filename").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var galleryID uint
+		var filename string
+		if err := rows.Scan(&galleryID, &filename); err != nil {
+			return nil, err
+		}
+		if filenames, ok := known[galleryID]; ok {
+			filenames[filename] = true
+		}
+	}
+	return known, rows.Err()
+}
+
+func (ig *imageGorm) ByUserID(userID uint) ([]Image, error) {
+	var images []Image
+	err := ig.byUser(userID).
+		Order("images.gallery_id asc, images.position asc, images.id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) ByChecksum(userID uint, checksum string) ([]Image, error) {
+	var images []Image
+	err := ig.byUser(userID).
+		Where("images.checksum = ?", checksum).
+		Order("images.id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// byUser scopes queries to the images in the galleries of the
+// user.
+func (ig *imageGorm) byUser(userID uint) *gorm.DB {
+	return ig.db.Select("images.*").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Where("galleries.user_id = ? AND galleries.deleted_at IS NULL", userID)
+}
+
+func (ig *imageGorm) Owner(galleryID uint) (*User, error) {
+	var user User
+	db := ig.db.Select("users.*").
+		Joins("JOIN galleries ON galleries.user_id = users.id").
+		Where("galleries.id = ?", galleryID)
+	err := first(db, &user)
+	return &user, err
+}
+
+func (ig *imageGorm) Unsized() ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("size = 0").Order("id asc").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) ByIDs(galleryID uint, ids []uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ? AND id IN (?)", galleryID, ids).
+		Order("position asc, id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) ByFilename(galleryID uint, filename string) (*Image, error) {
+	var image Image
+	db := ig.db.Where("gallery_id = ? AND filename = ?", galleryID, filename)
+	err := first(db, &image)
+	return &image, err
+}
+
+// NextPosition returns the position an image appended to the
+// end of the gallery should get.
+func (ig *imageGorm) NextPosition(galleryID uint) (int, error) {
+	var pos int
+	row := ig.db.Model(&Image{}).
+		Where("gallery_id = ?", galleryID).
+		Select("COALESCE(MAX(position), -1) + 1").
+		Row()
+	if err := row.Scan(&pos); err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Create(image).Error
+}
+
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Save(image).Error
+}
+
+func (ig *imageGorm) Reorder(galleryID uint, ids []uint) error {
+	tx := ig.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for pos, id := range ids {
+		res := tx.Model(&Image{}).
+			Where("id = ? AND gallery_id = ?", id, galleryID).
+			Update("position", pos)
+		if res.Error != nil {
+			tx.Rollback()
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			tx.Rollback()
+			return ErrNotFound
+		}
+	}
+	return tx.Commit().Error
+}
+
+// Delete only marks the image as deleted, as it stays in the
+// trash for a while.
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Delete(&image).Error
+}
+
+func (ig *imageGorm) AddUsage(userID uint, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	return ig.db.Model(&User{}).
+		Where("id = ?", userID).
+		UpdateColumn("storage_used", gorm.Expr("GREATEST(storage_used + ?, 0)", delta)).
+		Error
+}
+
+func (ig *imageGorm) ReserveUsage(userID uint, delta, quota int64) (bool, error) {
+	res := ig.db.Model(&User{}).
+		Where("id = ? AND storage_used + ? <= ?", userID, delta, quota).
+		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", delta))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// RecountUsage also counts the galleries and images in the
+// trash, which are only freed once they are purged.
+func (ig *imageGorm) RecountUsage() error {
+	return ig.db.Exec(`UPDATE users SET storage_used = COALESCE((
+		SELECT SUM(images.size) FROM images
+		JOIN galleries ON galleries.id = images.gallery_id
+		WHERE galleries.user_id = users.id
+	), 0)`).Error
+}
+
+func (ig *imageGorm) Transaction(fn func(db imageDB) error) error {
+	tx := ig.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(&imageGorm{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+type imageValFunc func(*Image) error
+
+func runImageValFuncs(image *Image, fns ...imageValFunc) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
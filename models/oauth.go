@@ -11,8 +11,8 @@ const (
 
 type OAuth struct {
 	gorm.Model
-	UserID  uint   `gorm:not null;unique_index:user_id_service`
-	Service string `gorm:not null;unique_index:user_id_service`
+	UserID  uint   `gorm:"not null;unique_index:user_id_service"`
+	Service string `gorm:"not null;unique_index:user_id_service"`
 	oauth2.Token
 }
 
@@ -0,0 +1,79 @@
+package models
+
+// Quotas holds the storage quota, in bytes, of each plan. Users
+// on a plan that is not listed get the quota of DefaultPlan,
+// and a quota of zero or less means unlimited storage.
+type Quotas struct {
+	Plans       map[string]int64
+	DefaultPlan string
+}
+
+// For returns the storage quota of the user in bytes.
+func (q Quotas) For(user *User) int64 {
+	if quota, ok := q.Plans[user.Plan]; ok {
+		return quota
+	}
+	return q.Plans[q.DefaultPlan]
+}
+
+// StorageLimited reports whether the plan of the user limits
+// how much they can store.
+func (u *User) StorageLimited() bool {
+	return u.StorageQuota > 0
+}
+
+// CanStore reports whether the user has room for size more
+// bytes.
+func (u *User) CanStore(size int64) bool {
+	return !u.StorageLimited() || u.StorageUsed+size <= u.StorageQuota
+}
+
+// StoragePercent returns how much of their quota the user uses,
+// from 0 to 100.
+func (u *User) StoragePercent() int {
+	if !u.StorageLimited() {
+		return 0
+	}
+	percent := u.StorageUsed * 100 / u.StorageQuota
+	if percent > 100 {
+		return 100
+	}
+	return int(percent)
+}
+
+// userQuota sets the storage quota of the users it looks up.
+type userQuota struct {
+	UserDB
+	quotas Quotas
+}
+
+func (uq *userQuota) ByID(id uint) (*User, error) {
+	return uq.set(uq.UserDB.ByID(id))
+}
+
+func (uq *userQuota) ByEmail(email string) (*User, error) {
+	return uq.set(uq.UserDB.ByEmail(email))
+}
+
+func (uq *userQuota) ByUsername(username string) (*User, error) {
+	return uq.set(uq.UserDB.ByUsername(username))
+}
+
+func (uq *userQuota) ByRemember(token string) (*User, error) {
+	return uq.set(uq.UserDB.ByRemember(token))
+}
+
+func (uq *userQuota) Search(query string, limit int) ([]User, error) {
+	users, err := uq.UserDB.Search(query, limit)
+	for i := range users {
+		users[i].StorageQuota = uq.quotas.For(&users[i])
+	}
+	return users, err
+}
+
+func (uq *userQuota) set(user *User, err error) (*User, error) {
+	if err == nil {
+		user.StorageQuota = uq.quotas.For(user)
+	}
+	return user, err
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testingGallery(t *testing.T, quotas Quotas) (*Services, *User, *Gallery) {
+	services := testingServices(t, quotas)
+	chdirTemp(t)
+	user := createTestUser(t, services)
+	gallery := Gallery{
+		UserID: user.ID,
+		Title:  "Quota",
+	}
+	if err := services.Gallery.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	return services, user, &gallery
+}
+
+func upload(services *Services, gallery *Gallery, filename, content string) error {
+	return services.Image.Create(gallery.ID, ioutil.NopCloser(strings.NewReader(content)), filename)
+}
+
+func storageUsed(t *testing.T, services *Services, user *User) int64 {
+	t.Helper()
+	user, err := services.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.StorageUsed
+}
+
+func TestImageQuota(t *testing.T) {
+	quotas := Quotas{
+		Plans:       map[string]int64{"tiny": 10},
+		DefaultPlan: "tiny",
+	}
+	services, user, gallery := testingGallery(t, quotas)
+
+	if err := upload(services, gallery, "a.txt", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if used := storageUsed(t, services, user); used != 6 {
+		t.Errorf("used %d bytes after the first upload, want 6", used)
+	}
+
+	// A file that does not fit changes nothing.
+	if err := upload(services, gallery, "b.txt", "abcdef"); err != ErrQuotaExceeded {
+		t.Errorf("uploading over the quota returned %v, want %v", err, ErrQuotaExceeded)
+	}
+	if used := storageUsed(t, services, user); used != 6 {
+		t.Errorf("used %d bytes after a rejected upload, want 6", used)
+	}
+	if _, err := services.Image.ByFilename(gallery.ID, "b.txt"); err != ErrNotFound {
+		t.Errorf("rejected upload was stored: %v", err)
+	}
+
+	// Replacing a file only counts the difference, so it fits
+	// even though both files together would not.
+	if err := upload(services, gallery, "a.txt", "12345678"); err != nil {
+		t.Fatal(err)
+	}
+	if used := storageUsed(t, services, user); used != 8 {
+		t.Errorf("used %d bytes after replacing a file, want 8", used)
+	}
+
+	// Images in the trash count until they are purged.
+	image, err := services.Image.ByFilename(gallery.ID, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.Image.Delete(image); err != nil {
+		t.Fatal(err)
+	}
+	if used := storageUsed(t, services, user); used != 8 {
+		t.Errorf("used %d bytes with the image in the trash, want 8", used)
+	}
+	if err := services.Trash.PurgeImage(image); err != nil {
+		t.Fatal(err)
+	}
+	if used := storageUsed(t, services, user); used != 0 {
+		t.Errorf("used %d bytes after purging the image, want 0", used)
+	}
+}
+
+func TestImageQuotaConcurrent(t *testing.T) {
+	quotas := Quotas{
+		Plans:       map[string]int64{"tiny": 10},
+		DefaultPlan: "tiny",
+	}
+	services, user, gallery := testingGallery(t, quotas)
+
+	// Every upload fits in the room left when it starts, but
+	// only one of them fits once the others are stored.
+	const uploads = 5
+	errs := make(chan error, uploads)
+	for i := 0; i < uploads; i++ {
+		go func(i int) {
+			errs <- upload(services, gallery, fmt.Sprintf("%d.txt", i), fmt.Sprintf("12345%d", i))
+		}(i)
+	}
+	stored := 0
+	for i := 0; i < uploads; i++ {
+		switch err := <-errs; err {
+		case nil:
+			stored++
+		case ErrQuotaExceeded:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if stored != 1 {
+		t.Errorf("stored %d uploads, want 1", stored)
+	}
+	if used := storageUsed(t, services, user); used != 6 {
+		t.Errorf("used %d bytes after concurrent uploads, want 6", used)
+	}
+}
+
+func TestRecountUsage(t *testing.T) {
+	services, user, gallery := testingGallery(t, Quotas{})
+	if err := upload(services, gallery, "a.txt", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	// The running total drifted, as if an update was lost.
+	err := services.db.Model(&User{}).Where("id = ?", user.ID).
+		UpdateColumn("storage_used", 1006).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.Image.RecountUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if used := storageUsed(t, services, user); used != 6 {
+		t.Errorf("used %d bytes after recounting, want 6", used)
+	}
+}
@@ -0,0 +1,270 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ReportOpen reports wait in the moderation queue. Admins
+	// close them as ReportActioned when they acted on the
+	// gallery, or ReportDismissed when nothing was wrong.
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+
+	maxReportCommentLen = 2000
+)
+
+// The reasons visitors can give for reporting a gallery.
+const (
+	ReasonSpam       = "spam"
+	ReasonCopyright  = "copyright"
+	ReasonExplicit   = "explicit"
+	ReasonHarassment = "harassment"
+	ReasonOther      = "other"
+)
+
+// ReportReasons lists every reason, in the order they are
+// offered on the report form.
+var ReportReasons = []ReportReason{
+	{ReasonSpam, "Spam or advertising"},
+	{ReasonCopyright, "It uses someone's work without permission"},
+	{ReasonExplicit, "Sexual or violent content"},
+	{ReasonHarassment, "Harassment or hate speech"},
+	{ReasonOther, "Something else"},
+}
+
+// ReportReason is a reason for reporting a gallery, along with
+// how it is described to people.
+type ReportReason struct {
+	Value string
+	Label string
+}
+
+// ReportStates lists every state, in the order the moderation
+// queue offers them.
+var ReportStates = []string{ReportOpen, ReportActioned, ReportDismissed}
+
+// Report flags a gallery for admins to review. Visitors who are
+// not logged in can report galleries too, in which case
+// ReporterID is 0 and only their IP address is known.
+type Report struct {
+	gorm.Model
+	GalleryID  uint   `gorm:"not null;index"`
+	ReporterID uint   `gorm:"not null;default:0"`
+	IP         string `gorm:"not null"`
+	Reason     string `gorm:"not null"`
+	Comment    string `gorm:"type:text"`
+	State      string `gorm:"not null;default:'open';index"`
+	// ResolvedByID is the admin who actioned or dismissed the
+	// report.
+	ResolvedByID uint
+	ResolvedAt   *time.Time
+}
+
+// ReasonLabel returns the description of the reason of the
+// report.
+func (r *Report) ReasonLabel() string {
+	for _, reason := range ReportReasons {
+		if reason.Value == r.Reason {
+			return reason.Label
+		}
+	}
+	return r.Reason
+}
+
+// Open reports whether the report still waits for an admin.
+func (r *Report) Open() bool {
+	return r.State == ReportOpen
+}
+
+type ReportService interface {
+	ReportDB
+
+	// Resolve closes every open report about the gallery with
+	// the provided state, on behalf of the admin.
+	Resolve(galleryID uint, state string, adminID uint) error
+}
+
+func NewReportService(db *gorm.DB) ReportService {
+	return &reportService{
+		ReportDB: &reportValidator{
+			&reportGorm{db},
+		},
+	}
+}
+
+type reportService struct {
+	ReportDB
+}
+
+func (rs *reportService) Resolve(galleryID uint, state string, adminID uint) error {
+	if galleryID <= 0 {
+		return ErrIDInvalid
+	}
+	if state != ReportActioned && state != ReportDismissed {
+		return ErrReportStateInvalid
+	}
+	return rs.CloseOpen(galleryID, state, adminID, time.Now())
+}
+
+// ReportDB is used to interact with the reports database.
+// Single report queries return ErrNotFound when nothing
+// matches.
+type ReportDB interface {
+	ByID(id uint) (*Report, error)
+	// ByState returns up to limit reports in the state. Open
+	// reports come oldest first, so the queue is worked
+	// through in order, and closed ones most recently resolved
+	// first.
+	ByState(state string, limit int) ([]Report, error)
+	// ByGalleryID returns every report about the gallery,
+	// newest first.
+	ByGalleryID(galleryID uint) ([]Report, error)
+	// Create returns ErrReportDuplicate when the reporter
+	// already has an open report about the gallery.
+	Create(report *Report) error
+	// CloseOpen sets the state of the open reports about the
+	// gallery, as resolved at the provided time.
+	CloseOpen(galleryID uint, state string, adminID uint, at time.Time) error
+}
+
+type reportValidator struct {
+	ReportDB
+}
+
+func (rv *reportValidator) Create(report *Report) error {
+	err := runReportValFuncs(report,
+		rv.galleryIDRequired,
+		rv.reasonValid,
+		rv.normalizeComment,
+		rv.commentLength,
+		rv.setOpen,
+		rv.notDuplicate)
+	if err != nil {
+		return err
+	}
+	return rv.ReportDB.Create(report)
+}
+
+func (rv *reportValidator) galleryIDRequired(r *Report) error {
+	if r.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (rv *reportValidator) reasonValid(r *Report) error {
+	for _, reason := range ReportReasons {
+		if r.Reason == reason.Value {
+			return nil
+		}
+	}
+	return ErrReportReasonInvalid
+}
+
+func (rv *reportValidator) normalizeComment(r *Report) error {
+	r.Comment = strings.TrimSpace(r.Comment)
+	return nil
+}
+
+func (rv *reportValidator) commentLength(r *Report) error {
+	if len([]rune(r.Comment)) > maxReportCommentLen {
+		return ErrReportCommentTooLong
+	}
+	return nil
+}
+
+// setOpen always files new reports as open, so they cannot be
+// created already resolved.
+func (rv *reportValidator) setOpen(r *Report) error {
+	r.State = ReportOpen
+	r.ResolvedByID = 0
+	r.ResolvedAt = nil
+	return nil
+}
+
+// notDuplicate keeps a reporter, known by their account or by
+// their IP address when logged out, from filing the same
+// gallery over and over.
+func (rv *reportValidator) notDuplicate(r *Report) error {
+	reports, err := rv.ReportDB.ByGalleryID(r.GalleryID)
+	if err != nil {
+		return err
+	}
+	for _, existing := range reports {
+		if !existing.Open() {
+			continue
+		}
+		if r.ReporterID != 0 && existing.ReporterID == r.ReporterID {
+			return ErrReportDuplicate
+		}
+		if r.ReporterID == 0 && existing.ReporterID == 0 && existing.IP == r.IP {
+			return ErrReportDuplicate
+		}
+	}
+	return nil
+}
+
+var _ ReportDB = &reportGorm{}
+
+type reportGorm struct {
+	db *gorm.DB
+}
+
+func (rg *reportGorm) ByID(id uint) (*Report, error) {
+	var report Report
+	err := first(rg.db.Where("id = ?", id), &report)
+	return &report, err
+}
+
+func (rg *reportGorm) ByState(state string, limit int) ([]Report, error) {
+	order := "resolved_at desc, id desc"
+	if state == ReportOpen {
+		order = "created_at, id"
+	}
+	var reports []Report
+	err := rg.db.Where("state = ?", state).Order(order).Limit(limit).Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (rg *reportGorm) ByGalleryID(galleryID uint) ([]Report, error) {
+	var reports []Report
+	err := rg.db.Where("gallery_id = ?", galleryID).Order("created_at desc").Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (rg *reportGorm) Create(report *Report) error {
+	return rg.db.Create(report).Error
+}
+
+func (rg *reportGorm) CloseOpen(galleryID uint, state string, adminID uint, at time.Time) error {
+	return rg.db.Model(&Report{}).
+		Where("gallery_id = ? AND state = ?", galleryID, ReportOpen).
+		UpdateColumns(map[string]interface{}{
+			"state":          state,
+			"resolved_by_id": adminID,
+			"resolved_at":    at,
+			"updated_at":     at,
+		}).Error
+}
+
+type reportValFunc func(*Report) error
+
+func runReportValFuncs(report *Report, fns ...reportValFunc) error {
+	for _, fn := range fns {
+		if err := fn(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql"
+	"log/slog"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
@@ -19,26 +22,111 @@ func WithGorm(dialect, connectionInfo string) ServicesConfig {
 	}
 }
 
-func WithUser(pepper, hmacKey string) ServicesConfig {
+// WithLogger sets the logger of the services that log what
+// they cannot return, and must come before them. slog.Default()
+// is used otherwise.
+func WithLogger(logger *slog.Logger) ServicesConfig {
+	return func(s *Services) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+func WithUser(pepper, hmacKey string, quotas Quotas) ServicesConfig {
+
+	return func(s *Services) error {
+
+		s.User = NewUserService(s.db, pepper, hmacKey, quotas)
+		return nil
+	}
+}
+
+func WithAudit() ServicesConfig {
+	return func(s *Services) error {
+		s.Audit = NewAuditService(s.db)
+		return nil
+	}
+}
 
+func WithReport() ServicesConfig {
 	return func(s *Services) error {
+		s.Report = NewReportService(s.db)
+		return nil
+	}
+}
 
-		s.User = NewUserService(s.db, pepper, hmacKey)
+func WithAPIToken(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.db, hmacKey)
 		return nil
 	}
 }
 
+// WithGallery must come after WithWebhook, as gallery changes
+// are sent to webhooks.
 func WithGallery() ServicesConfig {
 
 	return func(s *Services) error {
-		s.Gallery = NewGalleryService(s.db)
+		if s.Webhook == nil {
+			return ErrServiceRequired
+		}
+		s.Gallery = NewGalleryService(s.db, s.Webhook)
 		return nil
 	}
 }
 
-func WithImage() ServicesConfig {
+func WithCollection() ServicesConfig {
 	return func(s *Services) error {
-		s.Image = NewImageService()
+		s.Collection = NewCollectionService(s.db)
+		return nil
+	}
+}
+
+// WithImage must come after WithWatermark, as images are
+// watermarked when they are served, and after WithWebhook.
+func WithImage(quotas Quotas) ServicesConfig {
+	return func(s *Services) error {
+		if s.Watermark == nil || s.Webhook == nil {
+			return ErrServiceRequired
+		}
+		s.Image = NewImageService(s.db, s.Watermark, quotas, s.Webhook, s.logger)
+		return nil
+	}
+}
+
+// WithWebhook lets webhooks point to private addresses when
+// allowPrivate is true, which is only meant for development.
+func WithWebhook(allowPrivate bool) ServicesConfig {
+	return func(s *Services) error {
+		s.Webhook = NewWebhookService(s.db, allowPrivate, s.logger)
+		return nil
+	}
+}
+
+func WithTrash() ServicesConfig {
+	return func(s *Services) error {
+		s.Trash = NewTrashService(s.db, s.logger)
+		return nil
+	}
+}
+
+func WithGC() ServicesConfig {
+	return func(s *Services) error {
+		s.GC = NewGCService(s.db, s.logger)
+		return nil
+	}
+}
+
+func WithWatermark() ServicesConfig {
+	return func(s *Services) error {
+		s.Watermark = NewWatermarkService(s.db)
+		return nil
+	}
+}
+
+func WithUpload() ServicesConfig {
+	return func(s *Services) error {
+		s.Upload = NewUploadService(s.db)
 		return nil
 	}
 }
@@ -58,7 +146,7 @@ func WithLogMode(logMode bool) ServicesConfig {
 }
 
 func NewServices(cfgs ...ServicesConfig) (*Services, error) {
-	var s Services
+	s := Services{logger: slog.Default()}
 	for _, cfg := range cfgs {
 		if err := cfg(&s); err != nil {
 			return nil, err
@@ -70,11 +158,21 @@ func NewServices(cfgs ...ServicesConfig) (*Services, error) {
 }
 
 type Services struct {
-	Gallery GalleryService
-	Image   ImageService
-	User    UserService
-	OAuth   OAuthService
-	db      *gorm.DB
+	Gallery    GalleryService
+	Collection CollectionService
+	Image      ImageService
+	Trash      TrashService
+	GC         GCService
+	Upload     UploadService
+	Watermark  WatermarkService
+	Webhook    WebhookService
+	User       UserService
+	APIToken   APITokenService
+	OAuth      OAuthService
+	Audit      AuditService
+	Report     ReportService
+	db         *gorm.DB
+	logger     *slog.Logger
 }
 
 // Close closes the database connection
@@ -82,15 +180,21 @@ func (s *Services) Close() error {
 	return s.db.Close()
 }
 
+// DB returns the connection pool of the database, so its stats
+// can be reported.
+func (s *Services) DB() *sql.DB {
+	return s.db.DB()
+}
+
 // AutoMigrate will attempt to automatically migrate the
 // all tables
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{}, &OAuth{}).Error
+	return s.db.AutoMigrate(&User{}, &Gallery{}, &Image{}, &Collection{}, &collectionGallery{}, &Upload{}, &Watermark{}, &pwReset{}, &APIToken{}, &Webhook{}, &WebhookDelivery{}, &OAuth{}, &AuditEvent{}, &Report{}).Error
 }
 
 // DestructiveReset drops the all tables and rebuilds them
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &pwReset{}, &OAuth{}).Error
+	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &Image{}, &Collection{}, &collectionGallery{}, &Upload{}, &Watermark{}, &pwReset{}, &APIToken{}, &Webhook{}, &WebhookDelivery{}, &OAuth{}, &AuditEvent{}, &Report{}).Error
 	if err != nil {
 		return err
 	}
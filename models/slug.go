@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxSlugLen = 64
+
+// slugRegex matches the slugs and usernames we allow in URLs.
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// slugify turns the provided string into something that is
+// safe to use as a single URL path segment, such as
+// "My Wedding Photos!" -> "my-wedding-photos".
+func slugify(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			dash = false
+			sb.WriteRune(r)
+		default:
+			dash = true
+		}
+		if sb.Len() >= maxSlugLen {
+			break
+		}
+	}
+	return truncateSlug(sb.String(), maxSlugLen)
+}
+
+// truncateSlug shortens slug to at most n bytes, without leaving
+// a dash at either end.
+func truncateSlug(slug string, n int) string {
+	if len(slug) > n {
+		slug = slug[:n]
+	}
+	return strings.Trim(slug, "-")
+}
+
+// uniqueSlug appends an increasing number to base until taken
+// reports that the result is not in use. The base is shortened
+// as needed so the result is never longer than max.
+func uniqueSlug(base string, max int, taken func(string) (bool, error)) (string, error) {
+	slug := truncateSlug(base, max)
+	for i := 2; ; i++ {
+		isTaken, err := taken(slug)
+		if err != nil {
+			return "", err
+		}
+		if !isTaken {
+			return slug, nil
+		}
+		suffix := fmt.Sprintf("-%d", i)
+		slug = truncateSlug(base, max-len(suffix)) + suffix
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugifyLength(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"My Wedding Photos!", "my-wedding-photos"},
+		{strings.Repeat("a", 63) + " b", strings.Repeat("a", 63)},
+		{strings.Repeat("a", 64) + " b", strings.Repeat("a", 64)},
+		{strings.Repeat("a", 62) + " bc", strings.Repeat("a", 62) + "-b"},
+	}
+	for _, test := range tests {
+		got := slugify(test.title)
+		if got != test.want {
+			t.Errorf("slugify(%q) = %q, want %q", test.title, got, test.want)
+		}
+		if len(got) > maxSlugLen || !slugRegex.MatchString(got) {
+			t.Errorf("slugify(%q) = %q, which is not a valid slug", test.title, got)
+		}
+	}
+}
+
+func TestUniqueSlugLength(t *testing.T) {
+	tests := []struct {
+		base  string
+		taken int
+		want  string
+	}{
+		{"trip", 2, "trip-3"},
+		{strings.Repeat("a", 64), 1, strings.Repeat("a", 62) + "-2"},
+		{strings.Repeat("a", 64), 9, strings.Repeat("a", 61) + "-10"},
+		// Cutting the base must not leave it ending in a dash.
+		{strings.Repeat("a", 61) + "-bcd", 1, strings.Repeat("a", 61) + "-2"},
+	}
+	for _, test := range tests {
+		calls := 0
+		got, err := uniqueSlug(test.base, maxSlugLen, func(string) (bool, error) {
+			calls++
+			return calls <= test.taken, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("uniqueSlug(%q) with %d taken = %q, want %q", test.base, test.taken, got, test.want)
+		}
+		if len(got) > maxSlugLen || !slugRegex.MatchString(got) {
+			t.Errorf("uniqueSlug(%q) = %q, which is not a valid slug", test.base, got)
+		}
+	}
+}
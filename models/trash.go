@@ -0,0 +1,420 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TrashRetention is how long deleted galleries and images stay
+// in the trash, where they can be restored, before they are
+// purged for good.
+const TrashRetention = 30 * 24 * time.Hour
+
+// trashPath returns where the file of a deleted image is kept,
+// so the gallery can use its name again. The images of deleted
+// galleries stay in the gallery directory.
+func (i *Image) trashPath() string {
+	return fmt.Sprintf("images/trash/%v/%v", i.ID, i.Filename)
+}
+
+// PurgeAt returns when the deleted gallery is purged.
+func (g *Gallery) PurgeAt() time.Time {
+	return purgeAt(g.DeletedAt)
+}
+
+// PurgeAt returns when the deleted image is purged.
+func (i *Image) PurgeAt() time.Time {
+	return purgeAt(i.DeletedAt)
+}
+
+func purgeAt(deletedAt *time.Time) time.Time {
+	if deletedAt == nil {
+		return time.Time{}
+	}
+	return deletedAt.Add(TrashRetention)
+}
+
+// Trash holds what a user deleted. Images only has the images
+// deleted from galleries that are still there, as the images
+// of deleted galleries come back with their gallery.
+type Trash struct {
+	Galleries []Gallery
+	Images    []Image
+}
+
+// Empty reports whether there is nothing in the trash.
+func (t *Trash) Empty() bool {
+	return len(t.Galleries) == 0 && len(t.Images) == 0
+}
+
+// TrashService keeps deleted galleries and images around for
+// TrashRetention. What is in the trash still counts towards the
+// storage quota of its owner until it is purged.
+type TrashService interface {
+	// ByUserID returns the trash of the user, most recently
+	// deleted first.
+	ByUserID(userID uint) (*Trash, error)
+	// GalleryByID and ImageByID return a gallery or an image in
+	// the trash, or ErrNotFound if it is not in there.
+	GalleryByID(id uint) (*Gallery, error)
+	ImageByID(id uint) (*Image, error)
+	// OpenImage returns the file of an image in the trash.
+	OpenImage(image *Image) (io.ReadCloser, error)
+
+	RestoreGallery(gallery *Gallery) error
+	// RestoreImage appends the image to the end of its gallery,
+	// renaming it if another file took its name meanwhile.
+	RestoreImage(image *Image) error
+
+	// PurgeGallery deletes a gallery, with all of its images,
+	// for good, and PurgeImage an image.
+	PurgeGallery(gallery *Gallery) error
+	PurgeImage(image *Image) error
+	// Empty purges everything in the trash of the user.
+	Empty(userID uint) error
+	// Expire purges what was deleted more than TrashRetention
+	// ago, returning how many galleries and images were.
+	Expire() (int, error)
+}
+
+func NewTrashService(db *gorm.DB, logger *slog.Logger) TrashService {
+	return &trashService{
+		db:     &trashGorm{db},
+		logger: logger,
+	}
+}
+
+type trashService struct {
+	db     trashDB
+	logger *slog.Logger
+}
+
+func (ts *trashService) ByUserID(userID uint) (*Trash, error) {
+	galleries, err := ts.db.Galleries(userID)
+	if err != nil {
+		return nil, err
+	}
+	images, err := ts.db.Images(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Trash{
+		Galleries: galleries,
+		Images:    images,
+	}, nil
+}
+
+func (ts *trashService) GalleryByID(id uint) (*Gallery, error) {
+	return ts.db.GalleryByID(id)
+}
+
+func (ts *trashService) ImageByID(id uint) (*Image, error) {
+	return ts.db.ImageByID(id)
+}
+
+func (ts *trashService) OpenImage(image *Image) (io.ReadCloser, error) {
+	return os.Open(image.trashPath())
+}
+
+func (ts *trashService) RestoreGallery(gallery *Gallery) error {
+	return ts.db.RestoreGallery(gallery.ID)
+}
+
+func (ts *trashService) RestoreImage(image *Image) error {
+	files := fileOps{logger: ts.logger}
+	err := ts.db.Transaction(func(db trashDB) error {
+		pos, err := db.NextPosition(image.GalleryID)
+		if err != nil {
+			return err
+		}
+		src := image.trashPath()
+		image.Filename = freeFilename(image.GalleryID, image.Filename)
+		image.Position = pos
+		if err := db.RestoreImage(image); err != nil {
+			return err
+		}
+		return files.move(src, image.RelativePath())
+	})
+	if err != nil {
+		files.rollback()
+	}
+	return err
+}
+
+// PurgeGallery removes the files once the records are gone, as
+// they cannot be put back.
+func (ts *trashService) PurgeGallery(gallery *Gallery) error {
+	images, err := ts.db.GalleryImages(gallery.ID)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, image := range images {
+		size += image.Size
+	}
+	err = ts.db.Transaction(func(db trashDB) error {
+		if err := db.PurgeGallery(gallery.ID); err != nil {
+			return err
+		}
+		return db.AddUsage(gallery.UserID, -size)
+	})
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if image.DeletedAt != nil {
+			removeAll(ts.logger, fmt.Sprintf("images/trash/%v/", image.ID))
+		}
+	}
+	removeAll(ts.logger, galleryDir(gallery.ID))
+	removeAll(ts.logger, fmt.Sprintf("images/variants/%v/", gallery.ID))
+	return nil
+}
+
+func (ts *trashService) PurgeImage(image *Image) error {
+	owner, err := ts.db.Owner(image.GalleryID)
+	if err != nil {
+		return err
+	}
+	err = ts.db.Transaction(func(db trashDB) error {
+		if err := db.PurgeImage(image.ID); err != nil {
+			return err
+		}
+		return db.AddUsage(owner.ID, -image.Size)
+	})
+	if err != nil {
+		return err
+	}
+	removeAll(ts.logger, fmt.Sprintf("images/trash/%v/", image.ID))
+	return nil
+}
+
+func (ts *trashService) Empty(userID uint) error {
+	trash, err := ts.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for i := range trash.Galleries {
+		if err := ts.PurgeGallery(&trash.Galleries[i]); err != nil {
+			return err
+		}
+	}
+	for i := range trash.Images {
+		if err := ts.PurgeImage(&trash.Images[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire purges the expired galleries first, which may take
+// some of the expired images with them.
+func (ts *trashService) Expire() (int, error) {
+	before := time.Now().Add(-TrashRetention)
+	galleries, err := ts.db.ExpiredGalleries(before)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range galleries {
+		if err := ts.PurgeGallery(&galleries[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	images, err := ts.db.ExpiredImages(before)
+	if err != nil {
+		return n, err
+	}
+	for i := range images {
+		if err := ts.PurgeImage(&images[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// removeAll logs instead of failing, as the records of the
+// files are already gone.
+func removeAll(logger *slog.Logger, path string) {
+	if err := os.RemoveAll(path); err != nil {
+		logger.Error("removing files failed", "path", path, "err", err)
+	}
+}
+
+// trashDB is used to interact with the deleted galleries and
+// images in the database.
+type trashDB interface {
+	// Galleries and Images return what the user deleted, most
+	// recently deleted first.
+	Galleries(userID uint) ([]Gallery, error)
+	Images(userID uint) ([]Image, error)
+	GalleryByID(id uint) (*Gallery, error)
+	ImageByID(id uint) (*Image, error)
+	// GalleryImages returns every image of the gallery, whether
+	// it was deleted or not.
+	GalleryImages(galleryID uint) ([]Image, error)
+	// ExpiredGalleries and ExpiredImages return what was deleted
+	// before the provided time.
+	ExpiredGalleries(before time.Time) ([]Gallery, error)
+	ExpiredImages(before time.Time) ([]Image, error)
+	Owner(galleryID uint) (*User, error)
+	NextPosition(galleryID uint) (int, error)
+
+	RestoreGallery(id uint) error
+	// RestoreImage also saves the filename and the position of
+	// the image.
+	RestoreImage(image *Image) error
+	// PurgeGallery deletes the gallery along with its images and
+	// its place in any collection.
+	PurgeGallery(id uint) error
+	PurgeImage(id uint) error
+	AddUsage(userID uint, delta int64) error
+	// Transaction calls fn with a trashDB whose changes are all
+	// committed when fn returns nil, and rolled back otherwise.
+	Transaction(fn func(db trashDB) error) error
+}
+
+type trashGorm struct {
+	db *gorm.DB
+}
+
+func (tg *trashGorm) Galleries(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := tg.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at desc").
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (tg *trashGorm) Images(userID uint) ([]Image, error) {
+	var images []Image
+	err := tg.images().
+		Where("galleries.user_id = ?", userID).
+		Order("images.deleted_at desc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// images scopes queries to the deleted images of galleries that
+// were not deleted.
+func (tg *trashGorm) images() *gorm.DB {
+	return tg.db.Unscoped().Select("images.*").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Where("images.deleted_at IS NOT NULL AND galleries.deleted_at IS NULL")
+}
+
+func (tg *trashGorm) GalleryByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := tg.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+func (tg *trashGorm) ImageByID(id uint) (*Image, error) {
+	var image Image
+	db := tg.images().Where("images.id = ?", id)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (tg *trashGorm) GalleryImages(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := tg.db.Unscoped().Where("gallery_id = ?", galleryID).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (tg *trashGorm) ExpiredGalleries(before time.Time) ([]Gallery, error) {
+	var galleries []Gallery
+	err := tg.db.Unscoped().Where("deleted_at < ?", before).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (tg *trashGorm) ExpiredImages(before time.Time) ([]Image, error) {
+	var images []Image
+	err := tg.db.Unscoped().Where("deleted_at < ?", before).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (tg *trashGorm) Owner(galleryID uint) (*User, error) {
+	var user User
+	db := tg.db.Select("users.*").
+		Joins("JOIN galleries ON galleries.user_id = users.id").
+		Where("galleries.id = ?", galleryID)
+	err := first(db, &user)
+	return &user, err
+}
+
+func (tg *trashGorm) NextPosition(galleryID uint) (int, error) {
+	return (&imageGorm{tg.db}).NextPosition(galleryID)
+}
+
+func (tg *trashGorm) RestoreGallery(id uint) error {
+	return tg.db.Unscoped().Model(&Gallery{}).
+		Where("id = ?", id).
+		UpdateColumn("deleted_at", nil).Error
+}
+
+func (tg *trashGorm) RestoreImage(image *Image) error {
+	image.DeletedAt = nil
+	return tg.db.Unscoped().Model(&Image{}).
+		Where("id = ?", image.ID).
+		UpdateColumns(map[string]interface{}{
+			"deleted_at": nil,
+			"filename":   image.Filename,
+			"position":   image.Position,
+		}).Error
+}
+
+func (tg *trashGorm) PurgeGallery(id uint) error {
+	db := tg.db.Unscoped()
+	if err := db.Where("gallery_id = ?", id).Delete(&Image{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("gallery_id = ?", id).Delete(&collectionGallery{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&Gallery{Model: gorm.Model{ID: id}}).Error
+}
+
+func (tg *trashGorm) PurgeImage(id uint) error {
+	return tg.db.Unscoped().Delete(&Image{Model: gorm.Model{ID: id}}).Error
+}
+
+func (tg *trashGorm) AddUsage(userID uint, delta int64) error {
+	return (&imageGorm{tg.db}).AddUsage(userID, delta)
+}
+
+func (tg *trashGorm) Transaction(fn func(db trashDB) error) error {
+	tx := tg.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(&trashGorm{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+)
+
+const (
+	// UploadExpiry is how long an upload may go without
+	// receiving any data before it is considered abandoned.
+	UploadExpiry = 24 * time.Hour
+	// MaxUploadLength is the largest file that may be uploaded
+	// through a resumable upload.
+	MaxUploadLength = 4 << 30 // 4 gigabytes
+
+	uploadsPath = "images/uploads/"
+)
+
+// Upload tracks a resumable upload of a single file to a
+// gallery. The bytes received so far are kept in a staging
+// file, and Offset is only advanced once they are on disk, so
+// a client can always resume from the offset we report.
+type Upload struct {
+	gorm.Model
+	Token     string    `gorm:"not null;unique_index"`
+	UserID    uint      `gorm:"not null;index"`
+	GalleryID uint      `gorm:"not null;index"`
+	Filename  string    `gorm:"not null"`
+	Length    int64     `gorm:"not null"`
+	Offset    int64     `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// Complete reports whether every byte of the file was
+// received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+func (u *Upload) path() string {
+	return uploadsPath + u.Token
+}
+
+type UploadService interface {
+	UploadDB
+
+	// Write appends what is read from r to the upload, which
+	// must be at the provided offset. The offset is advanced by
+	// what was written even when reading r fails midway, so the
+	// client can resume from there.
+	Write(u *Upload, offset int64, r io.Reader) error
+	// Open returns the bytes received so far.
+	Open(u *Upload) (io.ReadCloser, error)
+	// Remove deletes the upload along with its staging file.
+	Remove(u *Upload) error
+	// Expire removes every upload past its expiration time and
+	// returns how many there were.
+	Expire() (int, error)
+}
+
+func NewUploadService(db *gorm.DB) UploadService {
+	return &uploadService{
+		UploadDB: &uploadValidator{
+			&uploadGorm{db},
+		},
+	}
+}
+
+type uploadService struct {
+	UploadDB
+	// locks serializes the writes made to a single upload,
+	// keyed by its token.
+	locks sync.Map
+}
+
+func (us *uploadService) Create(upload *Upload) error {
+	if err := us.UploadDB.Create(upload); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(uploadsPath, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(upload.path())
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (us *uploadService) Write(upload *Upload, offset int64, r io.Reader) error {
+	mu, _ := us.locks.LoadOrStore(upload.Token, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	// Another request may have written to the upload since it
+	// was looked up.
+	current, err := us.ByToken(upload.Token)
+	if err != nil {
+		return err
+	}
+	*upload = *current
+	if offset != upload.Offset {
+		return ErrUploadOffsetMismatch
+	}
+
+	f, err := os.OpenFile(upload.path(), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Drop anything written past the stored offset by a request
+	// that failed before the offset was saved.
+	if err := f.Truncate(upload.Offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(UploadExpiry)
+	if err := us.Update(upload); err != nil {
+		return err
+	}
+	return copyErr
+}
+
+func (us *uploadService) Open(upload *Upload) (io.ReadCloser, error) {
+	return os.Open(upload.path())
+}
+
+func (us *uploadService) Remove(upload *Upload) error {
+	if err := os.Remove(upload.path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	us.locks.Delete(upload.Token)
+	return us.Delete(upload.ID)
+}
+
+func (us *uploadService) Expire() (int, error) {
+	uploads, err := us.Expired(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for i := range uploads {
+		if err := us.Remove(&uploads[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(uploads), nil
+}
+
+// UploadDB is used to interact with the uploads database.
+type UploadDB interface {
+	// ByToken returns the upload with the provided token, or
+	// ErrNotFound if it does not exist or has expired.
+	ByToken(token string) (*Upload, error)
+	// Expired returns the uploads that expired before now.
+	Expired(now time.Time) ([]Upload, error)
+	Create(upload *Upload) error
+	Update(upload *Upload) error
+	Delete(id uint) error
+}
+
+type uploadValidator struct {
+	UploadDB
+}
+
+func (uv *uploadValidator) Create(upload *Upload) error {
+	err := runUploadValFuncs(upload,
+		uv.userIDRequired,
+		uv.galleryIDRequired,
+		uv.normalizeFilename,
+		uv.filenameRequired,
+		uv.lengthValid,
+		uv.setToken,
+		uv.setExpiresAt)
+	if err != nil {
+		return err
+	}
+	return uv.UploadDB.Create(upload)
+}
+
+func (uv *uploadValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return uv.UploadDB.Delete(id)
+}
+
+func (uv *uploadValidator) userIDRequired(u *Upload) error {
+	if u.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (uv *uploadValidator) galleryIDRequired(u *Upload) error {
+	if u.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+// normalizeFilename keeps only the base name of the uploaded
+// file, as some clients send the path it was picked from.
+func (uv *uploadValidator) normalizeFilename(u *Upload) error {
+	name := strings.Replace(u.Filename, "\\", "/", -1)
+	u.Filename = filepath.Base(strings.TrimSpace(name))
+	return nil
+}
+
+func (uv *uploadValidator) filenameRequired(u *Upload) error {
+	switch u.Filename {
+	case "", ".", "/", "..":
+		return ErrUploadFilenameRequired
+	}
+	return nil
+}
+
+func (uv *uploadValidator) lengthValid(u *Upload) error {
+	if u.Length <= 0 || u.Length > MaxUploadLength {
+		return ErrUploadLengthInvalid
+	}
+	return nil
+}
+
+func (uv *uploadValidator) setToken(u *Upload) error {
+	token, err := rand.UploadToken()
+	if err != nil {
+		return err
+	}
+	u.Token = token
+	return nil
+}
+
+func (uv *uploadValidator) setExpiresAt(u *Upload) error {
+	u.ExpiresAt = time.Now().Add(UploadExpiry)
+	return nil
+}
+
+var _ UploadDB = &uploadGorm{}
+
+type uploadGorm struct {
+	db *gorm.DB
+}
+
+func (ug *uploadGorm) ByToken(token string) (*Upload, error) {
+	var upload Upload
+	db := ug.db.Where("token = ? AND expires_at > ?", token, time.Now())
+	err := first(db, &upload)
+	return &upload, err
+}
+
+func (ug *uploadGorm) Expired(now time.Time) ([]Upload, error) {
+	var uploads []Upload
+	err := ug.db.Where("expires_at <= ?", now).Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func (ug *uploadGorm) Create(upload *Upload) error {
+	return ug.db.Create(upload).Error
+}
+
+func (ug *uploadGorm) Update(upload *Upload) error {
+	return ug.db.Save(upload).Error
+}
+
+// Delete removes the record for good, as there is nothing
+// left to restore once the staging file is gone.
+func (ug *uploadGorm) Delete(id uint) error {
+	upload := Upload{Model: gorm.Model{ID: id}}
+	return ug.db.Unscoped().Delete(&upload).Error
+}
+
+type uploadValFunc func(*Upload) error
+
+func runUploadValFuncs(upload *Upload, fns ...uploadValFunc) error {
+	for _, fn := range fns {
+		if err := fn(upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
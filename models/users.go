@@ -19,12 +19,52 @@ import (
 // access to their content.
 type User struct {
 	gorm.Model
-	Name         string
-	Email        string `gorm:"not null;unique_index"`
+	Name  string
+	Email string `gorm:"not null;unique_index"`
+	// Username is used in the public URLs of the user's
+	// galleries, eg /u/:username/:slug
+	Username string `gorm:"unique_index"`
+	// Bio is shown on the user's public profile and may
+	// contain Markdown.
+	Bio string `gorm:"type:text"`
+	// Theme is the portfolio theme used to render the public
+	// profile, one of ThemeClassic, ThemeDark or ThemeMinimal.
+	Theme string `gorm:"not null;default:'classic'"`
+	// DuplicatePolicy is what happens when the user uploads an
+	// image identical to one they already have, either
+	// DuplicatesWarn or DuplicatesSkip.
+	DuplicatePolicy string `gorm:"not null;default:'warn'"`
+	// Plan is the name of the plan the user is on, which sets
+	// their storage quota. It is empty for the default plan.
+	Plan string
+	// StorageUsed is the sum of the sizes of the user's images
+	// in bytes, and StorageQuota what their plan allows them,
+	// which is set when the user is looked up.
+	StorageUsed  int64  `gorm:"not null;default:0"`
+	StorageQuota int64  `gorm:"-"`
 	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null;unique_index"`
+	// Admin gives access to the /admin area. It is only ever
+	// set with the -admin flag.
+	Admin bool `gorm:"not null;default:false"`
+	// DisabledAt is when an admin disabled the account, which
+	// keeps the user from logging in or using the API.
+	DisabledAt *time.Time
+}
+
+// Disabled reports whether an admin disabled the account.
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// PublicTo reports whether the profile, galleries and
+// collections of the user may be shown to viewer, which is nil
+// for visitors that are not logged in. Disabling an account
+// takes them down for everyone but admins.
+func (u *User) PublicTo(viewer *User) bool {
+	return !u.Disabled() || (viewer != nil && viewer.Admin)
 }
 
 // UserDB is used to interact with the users database.
@@ -42,11 +82,21 @@ type UserDB interface {
 	// Methods for querying single users
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
+	ByUsername(username string) (*User, error)
 	ByRemember(token string) (*User, error)
+	// Search returns up to limit users whose email, name or
+	// username contains query, newest first. An empty query
+	// returns the newest users.
+	Search(query string, limit int) ([]User, error)
 
 	// Methods for altering users
 	Create(user *User) error
 	Update(user *User) error
+	// UpdateProfile only saves the name, username, bio, theme
+	// and duplicate policy of the user, so it never undoes
+	// changes made to the rest of the account meanwhile, such
+	// as the storage used going up with an upload.
+	UpdateProfile(user *User) error
 	Delete(id uint) error
 }
 
@@ -69,13 +119,13 @@ type UserService interface {
 	UserDB
 }
 
-func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
+func NewUserService(db *gorm.DB, pepper, hmacKey string, quotas Quotas) UserService {
 	ug := &userGorm{db}
 
 	hmac := hash.NewHMAC(hmacKey)
 	uv := newUserValidator(ug, hmac, pepper)
 	return &userService{
-		UserDB:    uv,
+		UserDB:    &userQuota{uv, quotas},
 		pepper:    pepper,
 		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
 	}
@@ -113,6 +163,9 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 			return nil, err
 		}
 	}
+	if foundUser.Disabled() {
+		return nil, ErrAccountDisabled
+	}
 
 	return foundUser, nil
 }
@@ -161,6 +214,28 @@ func (us *userService) CompleteReset(token, newPw string) (*User, error) {
 	return user, nil
 }
 
+const (
+	minUsernameLen = 3
+	maxUsernameLen = 30
+	maxBioLen      = 2000
+)
+
+const (
+	// ThemeClassic renders the profile with the same layout as
+	// the rest of the site.
+	ThemeClassic = "classic"
+	ThemeDark    = "dark"
+	ThemeMinimal = "minimal"
+)
+
+const (
+	// DuplicatesWarn adds the duplicate image and warns the user
+	// about it.
+	DuplicatesWarn = "warn"
+	// DuplicatesSkip leaves the duplicate image out.
+	DuplicatesSkip = "skip"
+)
+
 type userValFunc func(*User) error
 
 func runUserValFuncs(user *User, fns ...userValFunc) error {
@@ -206,6 +281,19 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
+// ByUsername will normalize the username before calling
+// ByUsername on the UserDB field.
+func (uv *userValidator) ByUsername(username string) (*User, error) {
+	user := User{
+		Username: username,
+	}
+	if err := runUserValFuncs(&user, uv.normalizeUsername); err != nil {
+		return nil, err
+	}
+
+	return uv.UserDB.ByUsername(user.Username)
+}
+
 // ByRemember will hash the remember token and then call
 // ByRemember on the subsequent UserDB Layer.
 func (uv *userValidator) ByRemember(token string) (*User, error) {
@@ -234,7 +322,14 @@ func (uv *userValidator) Create(user *User) error {
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.normalizeUsername,
+		uv.setUsernameIfUnset,
+		uv.usernameFormat,
+		uv.usernameIsAvail,
+		uv.bioMaxLength,
+		uv.themeValid,
+		uv.duplicatePolicyValid)
 	if err != nil {
 		return err
 	}
@@ -254,13 +349,36 @@ func (uv *userValidator) Update(user *User) error {
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.normalizeUsername,
+		uv.setUsernameIfUnset,
+		uv.usernameFormat,
+		uv.usernameIsAvail,
+		uv.bioMaxLength,
+		uv.themeValid,
+		uv.duplicatePolicyValid)
 	if err != nil {
 		return err
 	}
 	return uv.UserDB.Update(user)
 }
 
+func (uv *userValidator) UpdateProfile(user *User) error {
+	err := runUserValFuncs(user,
+		uv.idGreaterThan(0),
+		uv.normalizeUsername,
+		uv.setUsernameIfUnset,
+		uv.usernameFormat,
+		uv.usernameIsAvail,
+		uv.bioMaxLength,
+		uv.themeValid,
+		uv.duplicatePolicyValid)
+	if err != nil {
+		return err
+	}
+	return uv.UserDB.UpdateProfile(user)
+}
+
 // Delete will delete the user with the provided ID
 func (uv *userValidator) Delete(id uint) error {
 	var user User
@@ -383,6 +501,94 @@ func (uv *userValidator) emailIsAvail(user *User) error {
 	return nil
 }
 
+func (uv *userValidator) normalizeUsername(user *User) error {
+	user.Username = strings.ToLower(user.Username)
+	user.Username = strings.TrimSpace(user.Username)
+	return nil
+}
+
+// setUsernameIfUnset derives a username from the local part of
+// the user's email address. Accounts created before usernames
+// existed get one the next time they are updated.
+func (uv *userValidator) setUsernameIfUnset(user *User) error {
+	if user.Username != "" {
+		return nil
+	}
+	base := slugify(strings.Split(user.Email, "@")[0])
+	if len(base) < minUsernameLen {
+		base = "user-" + base
+		base = strings.Trim(base, "-")
+	}
+	username, err := uniqueSlug(base, maxUsernameLen, func(username string) (bool, error) {
+		existing, err := uv.ByUsername(username)
+		if err == ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return existing.ID != user.ID, nil
+	})
+	if err != nil {
+		return err
+	}
+	user.Username = username
+	return nil
+}
+
+func (uv *userValidator) usernameFormat(user *User) error {
+	n := len(user.Username)
+	if n < minUsernameLen || n > maxUsernameLen ||
+		!slugRegex.MatchString(user.Username) {
+		return ErrUsernameInvalid
+	}
+	return nil
+}
+
+func (uv *userValidator) usernameIsAvail(user *User) error {
+	existing, err := uv.ByUsername(user.Username)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.ID != existing.ID {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+func (uv *userValidator) bioMaxLength(user *User) error {
+	user.Bio = strings.TrimSpace(user.Bio)
+	if len(user.Bio) > maxBioLen {
+		return ErrBioTooLong
+	}
+	return nil
+}
+
+func (uv *userValidator) themeValid(user *User) error {
+	switch user.Theme {
+	case "":
+		user.Theme = ThemeClassic
+	case ThemeClassic, ThemeDark, ThemeMinimal:
+	default:
+		return ErrThemeInvalid
+	}
+	return nil
+}
+
+func (uv *userValidator) duplicatePolicyValid(user *User) error {
+	switch user.DuplicatePolicy {
+	case "":
+		user.DuplicatePolicy = DuplicatesWarn
+	case DuplicatesWarn, DuplicatesSkip:
+	default:
+		return ErrDuplicatePolicyInvalid
+	}
+	return nil
+}
+
 func (uv *userValidator) passwordMinLength(user *User) error {
 	if user.Password == "" {
 		return nil
@@ -452,6 +658,17 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
+// ByUsername looks up a user with the given username and
+// returns that user.
+// Errors are the same as ByEmail
+func (ug *userGorm) ByUsername(username string) (*User, error) {
+	var user User
+	db := ug.db.Where("username = ?", username)
+	err := first(db, &user)
+
+	return &user, err
+}
+
 // ByRemember looks up a user with the given remember token
 // and returns that user, This method expects the remember
 // token to already be hashed.
@@ -465,6 +682,25 @@ func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
 	return &user, err
 }
 
+func (ug *userGorm) Search(query string, limit int) ([]User, error) {
+	var users []User
+	db := ug.db.Order("created_at desc").Limit(limit)
+	if query = strings.TrimSpace(query); query != "" {
+		like := "%" + escapeLike(strings.ToLower(query)) + "%"
+		db = db.Where("lower(email) LIKE ? OR lower(name) LIKE ? OR lower(username) LIKE ?", like, like, like)
+	}
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// escapeLike escapes the characters that have a meaning in the
+// patterns of LIKE, so they match themselves.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields.
 func (ug *userGorm) Create(user *User) error {
@@ -472,9 +708,20 @@ func (ug *userGorm) Create(user *User) error {
 }
 
 // Update will update the provided user with all of the data
-// in the provided the user object.
+// in the provided the user object, except for the storage used,
+// which only changes as images are added and removed.
 func (ug *userGorm) Update(user *User) error {
-	return ug.db.Save(user).Error
+	return ug.db.Omit("storage_used").Save(user).Error
+}
+
+func (ug *userGorm) UpdateProfile(user *User) error {
+	return ug.db.Model(user).Updates(map[string]interface{}{
+		"name":             user.Name,
+		"username":         user.Username,
+		"bio":              user.Bio,
+		"theme":            user.Theme,
+		"duplicate_policy": user.DuplicatePolicy,
+	}).Error
 }
 
 // Delete will delete the user with the provided ID
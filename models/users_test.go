@@ -2,11 +2,14 @@ package models
 
 import (
 	"fmt"
+	"os"
 	"testing"
 	"time"
 )
 
-func testingUserService() (*UserService, error) {
+// testingServices connects to the test database and resets it.
+// Tests using it are skipped when the database is not running.
+func testingServices(t *testing.T, quotas Quotas) *Services {
 	const (
 		host     = "localhost"
 		port     = 5432
@@ -17,30 +20,55 @@ func testingUserService() (*UserService, error) {
 	psqlinfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
-	us, err := NewUserService(psqlinfo)
-
+	services, err := NewServices(
+		WithGorm("postgres", psqlinfo),
+		WithLogMode(false),
+		WithUser("test-pepper", "test-hmac-key", quotas),
+		WithWebhook(true),
+		WithGallery(),
+		WithWatermark(),
+		WithImage(quotas),
+		WithTrash(),
+	)
 	if err != nil {
-		return nil, err
+		t.Skipf("test database is not available: %v", err)
+	}
+	t.Cleanup(func() { services.Close() })
+	// Clear the tables between tests
+	if err := services.DestructiveReset(); err != nil {
+		t.Fatal(err)
 	}
-	us.db.LogMode(false)
-	// Clear the users table between tests
-	us.DestructiveReset()
-	return us, nil
+	return services
 }
 
-func TestCreateUser(t *testing.T) {
-	us, err := testingUserService()
+// chdirTemp runs the rest of the test in an empty directory, so
+// the files services write are removed afterwards.
+func chdirTemp(t *testing.T) {
+	wd, err := os.Getwd()
 	if err != nil {
-		t.Fatal()
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func createTestUser(t *testing.T, services *Services) *User {
 	user := User{
-		Name:  "A name here",
-		Email: "email2@email.com",
+		Name:     "A name here",
+		Email:    "email2@email.com",
+		Password: "password123",
 	}
-	err = us.Create(&user)
-	if err != nil {
+	if err := services.User.Create(&user); err != nil {
 		t.Fatal(err)
 	}
+	return &user
+}
+
+func TestCreateUser(t *testing.T) {
+	services := testingServices(t, Quotas{})
+	user := createTestUser(t, services)
 
 	if user.ID == 0 {
 		t.Errorf("Expected ID > 0. Received %d", user.ID)
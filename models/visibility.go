@@ -0,0 +1,26 @@
+package models
+
+const (
+	// VisibilityPublic resources are listed on the owner's
+	// public pages and can be seen by anyone.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted resources can be seen by anyone with
+	// the link but are never listed.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate resources can only be seen by their
+	// owner, or through a share link when they have one.
+	VisibilityPrivate = "private"
+)
+
+// normalizeVisibility returns the visibility to store for v,
+// defaulting to public, or ErrVisibilityInvalid.
+func normalizeVisibility(v string) (string, error) {
+	switch v {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return v, nil
+	default:
+		return "", ErrVisibilityInvalid
+	}
+}
@@ -0,0 +1,328 @@
+package models
+
+import (
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/imaging"
+)
+
+const (
+	// The kinds of watermark a user can choose from.
+	WatermarkNone = "none"
+	WatermarkText = "text"
+	WatermarkLogo = "logo"
+
+	// maxWatermarkText is the longest text, in characters, a
+	// watermark may show.
+	maxWatermarkText = 100
+	// maxLogoSize is the largest width and height a logo is
+	// stored at. Watermarks are never drawn larger than the web
+	// size, so anything bigger would only slow them down.
+	maxLogoSize = imaging.WebSize
+
+	watermarksPath = "images/watermarks/"
+)
+
+// Watermark holds the settings of the mark a user has drawn
+// over the web size of the images in their galleries. Originals
+// are never watermarked.
+type Watermark struct {
+	gorm.Model
+	UserID uint `gorm:"not null;unique_index"`
+	// Kind is one of WatermarkNone, WatermarkText or
+	// WatermarkLogo.
+	Kind string `gorm:"not null;default:'none'"`
+	Text string
+	// HasLogo reports whether the user uploaded a logo.
+	HasLogo bool `gorm:"not null;default:false"`
+	// Position is where the mark is drawn, one of the
+	// imaging.Position constants.
+	Position string `gorm:"not null;default:'bottom-right'"`
+	// Opacity and Scale are percentages, of full opacity and of
+	// the width of the image respectively.
+	Opacity int `gorm:"not null;default:50"`
+	Scale   int `gorm:"not null;default:20"`
+}
+
+// NewWatermark returns the settings a user starts with, which
+// draw nothing.
+func NewWatermark(userID uint) *Watermark {
+	return &Watermark{
+		UserID:   userID,
+		Kind:     WatermarkNone,
+		Position: imaging.PositionBottomRight,
+		Opacity:  50,
+		Scale:    20,
+	}
+}
+
+// Enabled reports whether the watermark draws anything.
+func (w *Watermark) Enabled() bool {
+	return w.Kind == WatermarkText || w.Kind == WatermarkLogo
+}
+
+func (w *Watermark) logoPath() string {
+	return fmt.Sprintf("%v%v/logo.png", watermarksPath, w.UserID)
+}
+
+// watermarkPositions lists the positions a watermark can be
+// drawn at.
+var watermarkPositions = []string{
+	imaging.PositionTopLeft,
+	imaging.PositionTopRight,
+	imaging.PositionCenter,
+	imaging.PositionBottomLeft,
+	imaging.PositionBottomRight,
+}
+
+type WatermarkService interface {
+	// ByUserID returns the watermark of the user, or the one
+	// returned by NewWatermark when they never saved any.
+	ByUserID(userID uint) (*Watermark, error)
+	// ForGallery returns the watermark to draw over the images
+	// of the gallery, or nil when its owner does not use one.
+	ForGallery(galleryID uint) (*imaging.Watermark, error)
+	// Save creates or updates the watermark of its user.
+	Save(w *Watermark) error
+	// SetLogo stores the image read from r as the logo of the
+	// watermark. It is saved along with the other settings.
+	SetLogo(w *Watermark, r io.Reader) error
+	// OpenLogo returns the uploaded logo as a PNG image.
+	OpenLogo(w *Watermark) (io.ReadCloser, error)
+}
+
+func NewWatermarkService(db *gorm.DB) WatermarkService {
+	return &watermarkService{
+		WatermarkDB: &watermarkValidator{
+			&watermarkGorm{db},
+		},
+	}
+}
+
+type watermarkService struct {
+	WatermarkDB
+}
+
+func (ws *watermarkService) ByUserID(userID uint) (*Watermark, error) {
+	w, err := ws.WatermarkDB.ByUserID(userID)
+	if err == ErrNotFound {
+		return NewWatermark(userID), nil
+	}
+	return w, err
+}
+
+func (ws *watermarkService) ForGallery(galleryID uint) (*imaging.Watermark, error) {
+	w, err := ws.ByGalleryID(galleryID)
+	switch {
+	case err == ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case !w.Enabled():
+		return nil, nil
+	}
+	mark := imaging.Watermark{
+		Position: w.Position,
+		Opacity:  float64(w.Opacity) / 100,
+		Scale:    float64(w.Scale) / 100,
+	}
+	if w.Kind == WatermarkText {
+		mark.Text = w.Text
+		return &mark, nil
+	}
+	f, err := os.Open(w.logoPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mark.Logo, err = png.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return &mark, nil
+}
+
+func (ws *watermarkService) Save(w *Watermark) error {
+	if w.ID == 0 {
+		return ws.Create(w)
+	}
+	return ws.Update(w)
+}
+
+// SetLogo converts the logo to PNG, which keeps its
+// transparency, scaling it down when it is larger than needed.
+func (ws *watermarkService) SetLogo(w *Watermark, r io.Reader) error {
+	img, _, err := imaging.Decode(r)
+	if err != nil {
+		return ErrWatermarkLogoInvalid
+	}
+	if err := os.MkdirAll(filepath.Dir(w.logoPath()), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(w.logoPath())
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, imaging.Fit(img, maxLogoSize))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	w.HasLogo = true
+	return nil
+}
+
+func (ws *watermarkService) OpenLogo(w *Watermark) (io.ReadCloser, error) {
+	if !w.HasLogo {
+		return nil, ErrNotFound
+	}
+	return os.Open(w.logoPath())
+}
+
+// WatermarkDB is used to interact with the watermarks database.
+type WatermarkDB interface {
+	ByUserID(userID uint) (*Watermark, error)
+	// ByGalleryID returns the watermark of the owner of the
+	// gallery.
+	ByGalleryID(galleryID uint) (*Watermark, error)
+	Create(w *Watermark) error
+	Update(w *Watermark) error
+}
+
+type watermarkValidator struct {
+	WatermarkDB
+}
+
+func (wv *watermarkValidator) Create(w *Watermark) error {
+	if err := wv.validate(w); err != nil {
+		return err
+	}
+	return wv.WatermarkDB.Create(w)
+}
+
+func (wv *watermarkValidator) Update(w *Watermark) error {
+	if err := wv.validate(w); err != nil {
+		return err
+	}
+	return wv.WatermarkDB.Update(w)
+}
+
+func (wv *watermarkValidator) validate(w *Watermark) error {
+	return runWatermarkValFuncs(w,
+		wv.userIDRequired,
+		wv.kindValid,
+		wv.normalizeText,
+		wv.textValid,
+		wv.logoRequired,
+		wv.positionValid,
+		wv.percentsValid)
+}
+
+func (wv *watermarkValidator) userIDRequired(w *Watermark) error {
+	if w.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (wv *watermarkValidator) kindValid(w *Watermark) error {
+	switch w.Kind {
+	case WatermarkNone, WatermarkText, WatermarkLogo:
+		return nil
+	case "":
+		w.Kind = WatermarkNone
+		return nil
+	}
+	return ErrWatermarkKindInvalid
+}
+
+func (wv *watermarkValidator) normalizeText(w *Watermark) error {
+	w.Text = strings.TrimSpace(w.Text)
+	return nil
+}
+
+func (wv *watermarkValidator) textValid(w *Watermark) error {
+	if w.Kind == WatermarkText && w.Text == "" {
+		return ErrWatermarkTextRequired
+	}
+	if utf8.RuneCountInString(w.Text) > maxWatermarkText {
+		return ErrWatermarkTextTooLong
+	}
+	return nil
+}
+
+func (wv *watermarkValidator) logoRequired(w *Watermark) error {
+	if w.Kind == WatermarkLogo && !w.HasLogo {
+		return ErrWatermarkLogoRequired
+	}
+	return nil
+}
+
+func (wv *watermarkValidator) positionValid(w *Watermark) error {
+	for _, p := range watermarkPositions {
+		if w.Position == p {
+			return nil
+		}
+	}
+	return ErrWatermarkPositionInvalid
+}
+
+func (wv *watermarkValidator) percentsValid(w *Watermark) error {
+	if w.Opacity < 5 || w.Opacity > 100 {
+		return ErrWatermarkOpacityInvalid
+	}
+	if w.Scale < 5 || w.Scale > 100 {
+		return ErrWatermarkScaleInvalid
+	}
+	return nil
+}
+
+var _ WatermarkDB = &watermarkGorm{}
+
+type watermarkGorm struct {
+	db *gorm.DB
+}
+
+func (wg *watermarkGorm) ByUserID(userID uint) (*Watermark, error) {
+	var w Watermark
+	err := first(wg.db.Where("user_id = ?", userID), &w)
+	return &w, err
+}
+
+func (wg *watermarkGorm) ByGalleryID(galleryID uint) (*Watermark, error) {
+	var w Watermark
+	db := wg.db.Select("watermarks.*").
+		Joins("JOIN galleries ON galleries.user_id = watermarks.user_id").
+		Where("galleries.id = ?", galleryID)
+	err := first(db, &w)
+	return &w, err
+}
+
+func (wg *watermarkGorm) Create(w *Watermark) error {
+	return wg.db.Create(w).Error
+}
+
+func (wg *watermarkGorm) Update(w *Watermark) error {
+	return wg.db.Save(w).Error
+}
+
+type watermarkValFunc func(*Watermark) error
+
+func runWatermarkValFuncs(w *Watermark, fns ...watermarkValFunc) error {
+	for _, fn := range fns {
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
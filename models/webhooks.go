@@ -0,0 +1,673 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samueldaviddelacruz/lenslocked.com/hash"
+	"github.com/samueldaviddelacruz/lenslocked.com/rand"
+)
+
+// The events webhooks may subscribe to. EventPing is only sent
+// by the test button of a webhook. There is no
+// selection.submitted event yet, as galleries do not let
+// visitors submit a selection of images.
+const (
+	EventGalleryCreated = "gallery.created"
+	EventGalleryUpdated = "gallery.updated"
+	EventGalleryDeleted = "gallery.deleted"
+	EventImageUploaded  = "image.uploaded"
+	EventImageDeleted   = "image.deleted"
+	EventPing           = "ping"
+)
+
+// WebhookEvents lists the events webhooks may subscribe to, in
+// the order they are shown.
+var WebhookEvents = []string{
+	EventGalleryCreated,
+	EventGalleryUpdated,
+	EventGalleryDeleted,
+	EventImageUploaded,
+	EventImageDeleted,
+}
+
+// The states of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// WebhookLogRetention is how long deliveries are kept in
+	// the log of their webhook.
+	WebhookLogRetention = 30 * 24 * time.Hour
+
+	// maxWebhookAttempts is how many times a delivery is sent
+	// before it is given up on. Attempts are spaced by
+	// webhookBackoff, which doubles every time.
+	maxWebhookAttempts = 6
+	webhookBackoff     = time.Minute
+	// webhookLease is how long a delivery being sent is kept
+	// from being picked up again, should the server stop
+	// before the result is saved.
+	webhookLease   = 5 * time.Minute
+	webhookTimeout = 10 * time.Second
+	// webhookWorkers is how many deliveries Trigger sends at
+	// once, and webhookQueue how many more may wait for them.
+	// Deliveries that do not fit are left to DeliverDue.
+	webhookWorkers = 4
+	webhookQueue   = 256
+	// maxWebhookResponse is how much of a response is kept in
+	// the log.
+	maxWebhookResponse = 1024
+)
+
+// Webhook is an endpoint of a user that events are posted to.
+type Webhook struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	URL    string `gorm:"not null"`
+	// Events holds the events the webhook is subscribed to,
+	// separated by spaces.
+	Events string `gorm:"not null"`
+	// Secret signs the payloads. Unlike tokens it is stored as
+	// is, as every delivery needs it.
+	Secret   string `gorm:"not null"`
+	Disabled bool   `gorm:"not null;default:false"`
+}
+
+// EventList returns the events the webhook is subscribed to.
+func (w *Webhook) EventList() []string {
+	return strings.Fields(w.Events)
+}
+
+// Subscribed reports whether the webhook wants the event.
+func (w *Webhook) Subscribed(event string) bool {
+	for _, e := range w.EventList() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is an event posted, or to be posted, to a
+// webhook, along with the outcome of the last attempt.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID uint   `gorm:"not null;index"`
+	Event     string `gorm:"not null"`
+	Payload   string `gorm:"type:text;not null"`
+	Status    string `gorm:"not null;default:'pending'"`
+	Attempts  int    `gorm:"not null;default:0"`
+	// StatusCode and Response are those of the last attempt,
+	// and Error why it failed when no response was received.
+	StatusCode    int
+	Response      string `gorm:"type:text"`
+	Error         string
+	LastAttemptAt *time.Time
+	// NextAttemptAt is when the delivery is sent again. It is
+	// nil once the delivery succeeded or was given up on.
+	NextAttemptAt *time.Time `gorm:"index"`
+}
+
+// webhookPayload is the body posted to webhooks.
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookGallery and webhookImage are what payloads hold for
+// galleries and images, with the fields named as in the API.
+type webhookGallery struct {
+	ID         uint   `json:"id"`
+	UserID     uint   `json:"user_id"`
+	Title      string `json:"title"`
+	Slug       string `json:"slug"`
+	Visibility string `json:"visibility"`
+	URL        string `json:"url"`
+}
+
+func newWebhookGallery(g *Gallery) *webhookGallery {
+	return &webhookGallery{
+		ID:         g.ID,
+		UserID:     g.UserID,
+		Title:      g.Title,
+		Slug:       g.Slug,
+		Visibility: g.Visibility,
+		URL:        fmt.Sprintf("/galleries/%v", g.ID),
+	}
+}
+
+type webhookImage struct {
+	ID        uint   `json:"id"`
+	GalleryID uint   `json:"gallery_id"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum"`
+	URL       string `json:"url"`
+}
+
+func newWebhookImage(i *Image) *webhookImage {
+	return &webhookImage{
+		ID:        i.ID,
+		GalleryID: i.GalleryID,
+		Filename:  i.Filename,
+		Size:      i.Size,
+		Checksum:  i.Checksum,
+		URL:       i.Path(),
+	}
+}
+
+// WebhookService posts events to the webhooks of users. Every
+// payload is signed with the secret of its webhook, and sent in
+// the X-Lenslocked-Signature header as "t=<unix time>,v1=<sig>",
+// where sig is the HMAC-SHA256 of the time, a dot and the body,
+// in unpadded URL-safe base64.
+type WebhookService interface {
+	WebhookDB
+
+	// Trigger queues the event for the enabled webhooks of the
+	// user subscribed to it, and has a few background workers
+	// send it right away. When they are busy with too many
+	// deliveries, it is sent by the next DeliverDue instead.
+	// Failures are only logged, so they never fail what
+	// triggered the event.
+	Trigger(userID uint, event string, data interface{})
+	// Test sends a ping to the webhook, once, and returns the
+	// delivery once it is done.
+	Test(hook *Webhook) (*WebhookDelivery, error)
+	// DeliverDue sends the deliveries due for another attempt
+	// and returns how many there were.
+	DeliverDue() (int, error)
+	// Prune removes the deliveries older than
+	// WebhookLogRetention and returns how many there were.
+	Prune() (int, error)
+}
+
+// NewWebhookService returns a WebhookService. Webhooks may only
+// point to private addresses, such as localhost, when
+// allowPrivate is true, so users cannot reach into our network.
+func NewWebhookService(db *gorm.DB, allowPrivate bool, logger *slog.Logger) WebhookService {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = publicOnly
+	}
+	ws := &webhookService{
+		WebhookDB: &webhookValidator{&webhookGorm{db}},
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: webhookTimeout,
+			},
+			// A redirect is reported as the response, rather
+			// than followed, so what was sent where is clear.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+		queue:  make(chan queuedDelivery, webhookQueue),
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go ws.work()
+	}
+	return ws
+}
+
+type webhookService struct {
+	WebhookDB
+	client *http.Client
+	logger *slog.Logger
+	queue  chan queuedDelivery
+}
+
+// queuedDelivery is a delivery waiting for a worker, along
+// with its webhook.
+type queuedDelivery struct {
+	hook     *Webhook
+	delivery *WebhookDelivery
+}
+
+func (ws *webhookService) Trigger(userID uint, event string, data interface{}) {
+	hooks, err := ws.ByUserID(userID)
+	if err != nil {
+		ws.logger.Error("loading webhooks failed", "user_id", userID, "err", err)
+		return
+	}
+	var payload []byte
+	for i := range hooks {
+		hook := &hooks[i]
+		if hook.Disabled || !hook.Subscribed(event) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(&webhookPayload{
+				Event:     event,
+				CreatedAt: time.Now(),
+				Data:      data,
+			})
+			if err != nil {
+				ws.logger.Error("encoding webhook payload failed", "event", event, "err", err)
+				return
+			}
+		}
+		// The delivery is due right away, and claimed by whoever
+		// gets to it first of the workers and DeliverDue.
+		now := time.Now()
+		delivery := WebhookDelivery{
+			WebhookID:     hook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := ws.CreateDelivery(&delivery); err != nil {
+			ws.logger.Error("queueing webhook delivery failed", "webhook_id", hook.ID, "err", err)
+			continue
+		}
+		select {
+		case ws.queue <- queuedDelivery{hook: hook, delivery: &delivery}:
+		default:
+		}
+	}
+}
+
+// work sends the deliveries queued by Trigger, one at a time.
+func (ws *webhookService) work() {
+	for q := range ws.queue {
+		now := time.Now()
+		claimed, err := ws.ClaimDelivery(q.delivery.ID, now, now.Add(webhookLease))
+		if err != nil {
+			ws.logger.Error("claiming webhook delivery failed", "delivery_id", q.delivery.ID, "err", err)
+			continue
+		}
+		if claimed {
+			ws.attempt(q.hook, q.delivery)
+		}
+	}
+}
+
+func (ws *webhookService) Test(hook *Webhook) (*WebhookDelivery, error) {
+	payload, err := json.Marshal(&webhookPayload{
+		Event:     EventPing,
+		CreatedAt: time.Now(),
+		Data:      map[string]uint{"webhook_id": hook.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	delivery := WebhookDelivery{
+		WebhookID: hook.ID,
+		Event:     EventPing,
+		Payload:   string(payload),
+		Status:    DeliveryPending,
+	}
+	if err := ws.CreateDelivery(&delivery); err != nil {
+		return nil, err
+	}
+	if err := ws.attempt(hook, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (ws *webhookService) DeliverDue() (int, error) {
+	now := time.Now()
+	deliveries, err := ws.DueDeliveries(now)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		claimed, err := ws.ClaimDelivery(delivery.ID, now, now.Add(webhookLease))
+		if err != nil {
+			return n, err
+		}
+		if !claimed {
+			continue
+		}
+		hook, err := ws.ByID(delivery.WebhookID)
+		switch err {
+		case nil:
+		case ErrNotFound:
+			// The webhook was deleted while the delivery was
+			// being looked up.
+			continue
+		default:
+			return n, err
+		}
+		n++
+		if err := ws.attempt(hook, delivery); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (ws *webhookService) Prune() (int, error) {
+	return ws.DeleteDeliveriesBefore(time.Now().Add(-WebhookLogRetention))
+}
+
+// attempt sends the delivery and saves the outcome. Failed
+// deliveries are scheduled again, unless they ran out of
+// attempts, were a ping, or the webhook was disabled.
+func (ws *webhookService) attempt(hook *Webhook, delivery *WebhookDelivery) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.StatusCode, delivery.Response, delivery.Error = 0, "", ""
+
+	res, err := ws.send(hook, delivery)
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.StatusCode = res.StatusCode
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxWebhookResponse))
+		res.Body.Close()
+		delivery.Response = string(body)
+	}
+
+	switch {
+	case err == nil && res.StatusCode >= 200 && res.StatusCode < 300:
+		delivery.Status = DeliveryDelivered
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= maxWebhookAttempts || delivery.Event == EventPing || hook.Disabled:
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(webhookBackoff << uint(delivery.Attempts-1))
+		delivery.Status = DeliveryPending
+		delivery.NextAttemptAt = &next
+	}
+	if err := ws.UpdateDelivery(delivery); err != nil {
+		ws.logger.Error("saving webhook delivery failed", "delivery_id", delivery.ID, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (ws *webhookService) send(hook *Webhook, delivery *WebhookDelivery) (*http.Response, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return nil, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Lenslocked-Webhooks/1")
+	req.Header.Set("X-Lenslocked-Event", delivery.Event)
+	req.Header.Set("X-Lenslocked-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Lenslocked-Signature", "t="+ts+",v1="+signWebhook(hook.Secret, ts, delivery.Payload))
+	return ws.client.Do(req)
+}
+
+// signWebhook returns the signature of a payload sent at the
+// provided unix time.
+func signWebhook(secret, ts, payload string) string {
+	sig := hash.NewHMAC(secret).Hash(ts + "." + payload)
+	return strings.TrimRight(sig, "=")
+}
+
+// publicOnly refuses connections to loopback, private and
+// link-local addresses. It runs once the host of a webhook is
+// resolved, so names pointing to such addresses are refused as
+// well.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrWebhookAddressPrivate
+	}
+	return nil
+}
+
+// WebhookDB is used to interact with the webhooks database.
+// Single webhook queries return ErrNotFound when nothing
+// matches.
+type WebhookDB interface {
+	ByID(id uint) (*Webhook, error)
+	ByUserID(userID uint) ([]Webhook, error)
+	// Create sets the secret of the webhook when it has none.
+	Create(hook *Webhook) error
+	Update(hook *Webhook) error
+	// Delete removes the webhook along with its deliveries.
+	Delete(id uint) error
+
+	// Deliveries returns the latest deliveries of the webhook,
+	// newest first.
+	Deliveries(webhookID uint, limit int) ([]WebhookDelivery, error)
+	CreateDelivery(delivery *WebhookDelivery) error
+	UpdateDelivery(delivery *WebhookDelivery) error
+	// DueDeliveries returns the pending deliveries whose next
+	// attempt is due at now.
+	DueDeliveries(now time.Time) ([]WebhookDelivery, error)
+	// ClaimDelivery moves the next attempt of the delivery to
+	// until if it is still due at now, and reports whether it
+	// did, so a delivery is only sent by one caller at once.
+	ClaimDelivery(id uint, now, until time.Time) (bool, error)
+	// PendingDeliveries returns how many deliveries are waiting
+	// to be sent, whether or not they are due yet.
+	PendingDeliveries() (int, error)
+	DeleteDeliveriesBefore(t time.Time) (int, error)
+}
+
+type webhookValidator struct {
+	WebhookDB
+}
+
+func (wv *webhookValidator) Create(hook *Webhook) error {
+	err := runWebhookValFuncs(hook,
+		wv.userIDRequired,
+		wv.urlValid,
+		wv.eventsValid,
+		wv.setSecretIfUnset)
+	if err != nil {
+		return err
+	}
+	return wv.WebhookDB.Create(hook)
+}
+
+func (wv *webhookValidator) Update(hook *Webhook) error {
+	err := runWebhookValFuncs(hook,
+		wv.userIDRequired,
+		wv.urlValid,
+		wv.eventsValid,
+		wv.setSecretIfUnset)
+	if err != nil {
+		return err
+	}
+	return wv.WebhookDB.Update(hook)
+}
+
+func (wv *webhookValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return wv.WebhookDB.Delete(id)
+}
+
+func (wv *webhookValidator) userIDRequired(hook *Webhook) error {
+	if hook.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (wv *webhookValidator) urlValid(hook *Webhook) error {
+	hook.URL = strings.TrimSpace(hook.URL)
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrWebhookURLInvalid
+	}
+	return nil
+}
+
+// eventsValid checks every event is known, and puts them in the
+// order of WebhookEvents without duplicates.
+func (wv *webhookValidator) eventsValid(hook *Webhook) error {
+	chosen := make(map[string]bool)
+	for _, event := range hook.EventList() {
+		chosen[event] = true
+	}
+	var events []string
+	for _, event := range WebhookEvents {
+		if chosen[event] {
+			events = append(events, event)
+			delete(chosen, event)
+		}
+	}
+	if len(chosen) > 0 {
+		return ErrWebhookEventInvalid
+	}
+	if len(events) == 0 {
+		return ErrWebhookEventsRequired
+	}
+	hook.Events = strings.Join(events, " ")
+	return nil
+}
+
+func (wv *webhookValidator) setSecretIfUnset(hook *Webhook) error {
+	if hook.Secret != "" {
+		return nil
+	}
+	secret, err := rand.WebhookSecret()
+	if err != nil {
+		return err
+	}
+	hook.Secret = secret
+	return nil
+}
+
+var _ WebhookDB = &webhookGorm{}
+
+type webhookGorm struct {
+	db *gorm.DB
+}
+
+func (wg *webhookGorm) ByID(id uint) (*Webhook, error) {
+	var hook Webhook
+	err := first(wg.db.Where("id = ?", id), &hook)
+	return &hook, err
+}
+
+func (wg *webhookGorm) ByUserID(userID uint) ([]Webhook, error) {
+	var hooks []Webhook
+	err := wg.db.Where("user_id = ?", userID).Order("id asc").Find(&hooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (wg *webhookGorm) Create(hook *Webhook) error {
+	return wg.db.Create(hook).Error
+}
+
+func (wg *webhookGorm) Update(hook *Webhook) error {
+	return wg.db.Save(hook).Error
+}
+
+func (wg *webhookGorm) Delete(id uint) error {
+	tx := wg.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := tx.Unscoped().Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	hook := Webhook{Model: gorm.Model{ID: id}}
+	if err := tx.Unscoped().Delete(&hook).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (wg *webhookGorm) Deliveries(webhookID uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := wg.db.Where("webhook_id = ?", webhookID).
+		Order("id desc").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (wg *webhookGorm) CreateDelivery(delivery *WebhookDelivery) error {
+	return wg.db.Create(delivery).Error
+}
+
+func (wg *webhookGorm) UpdateDelivery(delivery *WebhookDelivery) error {
+	return wg.db.Save(delivery).Error
+}
+
+func (wg *webhookGorm) DueDeliveries(now time.Time) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := wg.db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at asc").
+		Limit(100).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (wg *webhookGorm) ClaimDelivery(id uint, now, until time.Time) (bool, error) {
+	res := wg.db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, DeliveryPending, now).
+		UpdateColumn("next_attempt_at", until)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (wg *webhookGorm) PendingDeliveries() (int, error) {
+	var n int
+	err := wg.db.Model(&WebhookDelivery{}).
+		Where("status = ?", DeliveryPending).
+		Count(&n).Error
+	return n, err
+}
+
+func (wg *webhookGorm) DeleteDeliveriesBefore(t time.Time) (int, error) {
+	res := wg.db.Unscoped().Where("created_at < ?", t).Delete(&WebhookDelivery{})
+	return int(res.RowsAffected), res.Error
+}
+
+type webhookValFunc func(*Webhook) error
+
+func runWebhookValFuncs(hook *Webhook, fns ...webhookValFunc) error {
+	for _, fn := range fns {
+		if err := fn(hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimDelivery(t *testing.T) {
+	services := testingServices(t, Quotas{})
+	user := createTestUser(t, services)
+	hook := Webhook{
+		UserID: user.ID,
+		URL:    "http://localhost/hook",
+		Events: EventImageUploaded,
+	}
+	if err := services.Webhook.Create(&hook); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	due := now.Add(-time.Minute)
+	delivery := WebhookDelivery{
+		WebhookID:     hook.ID,
+		Event:         EventImageUploaded,
+		Payload:       "{}",
+		Status:        DeliveryPending,
+		NextAttemptAt: &due,
+	}
+	if err := services.Webhook.CreateDelivery(&delivery); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := services.Webhook.PendingDeliveries(); err != nil || n != 1 {
+		t.Errorf("PendingDeliveries() = %d, %v, want 1", n, err)
+	}
+
+	until := now.Add(webhookLease)
+	claim := func(now, until time.Time) bool {
+		t.Helper()
+		claimed, err := services.Webhook.ClaimDelivery(delivery.ID, now, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+	if !claim(now, until) {
+		t.Fatal("a due delivery was not claimed")
+	}
+	// Whoever comes next finds it leased until then.
+	if claim(now, until) {
+		t.Error("a claimed delivery was claimed again")
+	}
+	// Once the lease runs out, the delivery is due again.
+	if !claim(until.Add(time.Second), until.Add(webhookLease)) {
+		t.Error("a delivery whose lease ran out was not claimed")
+	}
+
+	delivery.Status = DeliveryDelivered
+	delivery.NextAttemptAt = nil
+	if err := services.Webhook.UpdateDelivery(&delivery); err != nil {
+		t.Fatal(err)
+	}
+	if claim(until.Add(time.Hour), until.Add(2*time.Hour)) {
+		t.Error("a delivered delivery was claimed")
+	}
+	if n, err := services.Webhook.PendingDeliveries(); err != nil || n != 0 {
+		t.Errorf("PendingDeliveries() = %d, %v, want 0", n, err)
+	}
+}
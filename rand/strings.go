@@ -7,6 +7,21 @@ import (
 
 const (
 	RememberTokenBytes = 32
+	// ShareTokenBytes is a multiple of 3 so the encoded token
+	// never ends with base64 padding.
+	ShareTokenBytes = 15
+	// UploadTokenBytes is also a multiple of 3, as upload tokens
+	// are used in URLs.
+	UploadTokenBytes = 24
+	// APITokenBytes is a multiple of 3 as well, so API tokens
+	// can be pasted without padding getting in the way.
+	APITokenBytes = 30
+	// WebhookSecretBytes is the size of the secrets webhook
+	// payloads are signed with.
+	WebhookSecretBytes = 30
+	// RequestIDBytes is a multiple of 3 too, so request IDs read
+	// cleanly in logs and headers.
+	RequestIDBytes = 12
 )
 
 // Bytes will help us generate n random bytes, or will
@@ -49,3 +64,32 @@ func String(nBytes int) (string, error) {
 func RememberToken() (string, error) {
 	return String(RememberTokenBytes)
 }
+
+// ShareToken is a helper function designed to generate the
+// tokens used in share links.
+func ShareToken() (string, error) {
+	return String(ShareTokenBytes)
+}
+
+// UploadToken is a helper function designed to generate the
+// tokens identifying resumable uploads.
+func UploadToken() (string, error) {
+	return String(UploadTokenBytes)
+}
+
+// APIToken is a helper function designed to generate the
+// personal access tokens used with the API.
+func APIToken() (string, error) {
+	return String(APITokenBytes)
+}
+
+// RequestID generates the ID a request is logged with.
+func RequestID() (string, error) {
+	return String(RequestIDBytes)
+}
+
+// WebhookSecret generates the secret a webhook signs its
+// payloads with.
+func WebhookSecret() (string, error) {
+	return String(WebhookSecretBytes)
+}
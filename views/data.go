@@ -1,7 +1,6 @@
 package views
 
 import (
-	"log"
 	"net/http"
 	"time"
 
@@ -25,12 +24,28 @@ type Alert struct {
 	Message string
 }
 
+// Meta is used to render the page title along with the
+// OpenGraph and Twitter card tags of pages meant to be shared.
+// URL and Image should be absolute URLs.
+type Meta struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+}
+
 // Data is the top level structure that views expect data
 // to come in.
 type Data struct {
 	Alert *Alert
 	User  *models.User
-	Yield interface{}
+	// Impersonator is the admin acting as User, if any.
+	Impersonator *models.User
+	Meta         *Meta
+	Yield        interface{}
+	// err is the private error SetAlert hid behind the generic
+	// message, which Render logs with the request.
+	err error
 }
 
 func (d *Data) AlertError(msg string) {
@@ -47,7 +62,7 @@ func (d *Data) SetAlert(err error) {
 			Message: pErr.Public(),
 		}
 	} else {
-		log.Println(err)
+		d.err = err
 		d.Alert = &Alert{
 			Level:   AlertLvlError,
 			Message: AlertMsgGeneric,
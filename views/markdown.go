@@ -0,0 +1,25 @@
+package views
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+
+	"github.com/yuin/goldmark"
+)
+
+// md renders Markdown with the goldmark defaults, which leave
+// out any raw HTML and drop dangerous links such as javascript:
+// URLs, so user provided text is safe to render as is.
+var md = goldmark.New()
+
+// markdown converts the provided Markdown source into HTML
+// that can be embedded in a template.
+func markdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		slog.Error("rendering markdown failed", "err", err)
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(buf.String())
+}
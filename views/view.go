@@ -5,7 +5,6 @@ import (
 	"errors"
 	"html/template"
 	"io"
-	"log"
 	"net/http"
 	"path/filepath"
 
@@ -34,6 +33,8 @@ func NewView(layout string, files ...string) *View {
 			"csrfField": func() (template.HTML, error) {
 				return "", errors.New("csrfField is not implemented")
 			},
+			"markdown": markdown,
+			"bytes":    formatBytes,
 		},
 	).ParseFiles(files...) //template.ParseFiles(files...)
 	if err != nil {
@@ -74,6 +75,11 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 	}
 
 	vd.User = context.User(r.Context())
+	vd.Impersonator = context.Impersonator(r.Context())
+	logger := context.Logger(r.Context())
+	if vd.err != nil {
+		logger.Error("request failed", "err", vd.err)
+	}
 
 	var buf bytes.Buffer
 	csrfField := csrf.TemplateField(r)
@@ -85,7 +91,7 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 		},
 	)
 	if err := tpl.ExecuteTemplate(&buf, v.Layout, vd); err != nil {
-		log.Println(err)
+		logger.Error("rendering template failed", "layout", v.Layout, "err", err)
 		http.Error(w, "Something went wrong. If the problem persists, please email support@lenslocked-project-demo.net",
 			http.StatusInternalServerError)
 		return